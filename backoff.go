@@ -0,0 +1,73 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const defaultResetBackoffMaxSeconds = 1800
+
+// peerBackoff tracks consecutive resets of a peer that did not lead to a new handshake.
+type peerBackoff struct {
+	consecutiveResets int
+	lastReset         time.Time
+	handshakeAtReset  time.Time
+	// cooldownUntil is the end of the cooldown of the escalation step that has been tried last.
+	cooldownUntil time.Time
+}
+
+// delay returns the duration to wait after the last reset before the peer may be reset again. The delay doubles
+// with each consecutive reset, starting at base and capped at maxDelay.
+func (b *peerBackoff) delay(base, maxDelay time.Duration) time.Duration {
+	if b.consecutiveResets == 0 {
+		return 0
+	}
+
+	delay := base
+	for i := 1; i < b.consecutiveResets && delay < maxDelay; i++ {
+		delay *= 2
+	}
+
+	return min(delay, maxDelay)
+}
+
+// remaining returns the duration until the backoff and the cooldown expire.
+func (b *peerBackoff) remaining(base, maxDelay time.Duration) time.Duration {
+	return max(0, b.delay(base, maxDelay)-time.Since(b.lastReset), time.Until(b.cooldownUntil))
+}
+
+// ResetLimiter limits the number of resets across all interfaces within a sliding window of one minute.
+type ResetLimiter struct {
+	mutex     sync.Mutex
+	maxResets int
+	window    time.Duration
+	resets    []time.Time
+}
+
+func NewResetLimiter(maxResetsPerMinute int) *ResetLimiter {
+	return &ResetLimiter{
+		maxResets: maxResetsPerMinute,
+		window:    time.Minute,
+	}
+}
+
+// Allow reports whether another reset is allowed and records it if so.
+func (l *ResetLimiter) Allow() bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	cutoff := now.Add(-l.window)
+	idx := 0
+	for idx < len(l.resets) && !l.resets[idx].After(cutoff) {
+		idx++
+	}
+	l.resets = l.resets[idx:]
+
+	if len(l.resets) >= l.maxResets {
+		return false
+	}
+
+	l.resets = append(l.resets, now)
+	return true
+}
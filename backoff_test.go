@@ -0,0 +1,192 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_peerBackoff_delay(t *testing.T) {
+	base := 30 * time.Second
+	maxDelay := 5 * time.Minute
+
+	tests := []struct {
+		resets int
+		want   time.Duration
+	}{
+		{resets: 0, want: 0},
+		{resets: 1, want: 30 * time.Second},
+		{resets: 2, want: time.Minute},
+		{resets: 3, want: 2 * time.Minute},
+		{resets: 4, want: 4 * time.Minute},
+		{resets: 5, want: 5 * time.Minute},
+		{resets: 100, want: 5 * time.Minute},
+	}
+	for _, tt := range tests {
+		backoff := &peerBackoff{consecutiveResets: tt.resets}
+		if got := backoff.delay(base, maxDelay); got != tt.want {
+			t.Errorf("delay() with %d resets = %v, want %v", tt.resets, got, tt.want)
+		}
+	}
+}
+
+func TestResetLimiter_Allow(t *testing.T) {
+	limiter := NewResetLimiter(2)
+	if !limiter.Allow() || !limiter.Allow() {
+		t.Fatal("Allow() denied reset within limit")
+	}
+	if limiter.Allow() {
+		t.Fatal("Allow() permitted reset exceeding limit")
+	}
+
+	// pretend the recorded resets happened more than a minute ago
+	limiter.resets = []time.Time{time.Now().Add(-2 * time.Minute), time.Now().Add(-61 * time.Second)}
+	if !limiter.Allow() {
+		t.Fatal("Allow() denied reset after window passed")
+	}
+}
+
+func TestTunnelguard_backoff(t *testing.T) {
+	lastSeen := handshakeAgo(time.Hour)
+	driver := &fakeDriver{
+		peers:     []Peer{{PublicKey: "offline", HandshakeLastSeen: lastSeen}},
+		endpoints: map[string]string{"offline": "host.example:51820"},
+	}
+	conf := InterfaceConfig{
+		Interface:               "wg-backoff",
+		HandshakeTimeoutSeconds: 180,
+		WaitSeconds:             30,
+		ResetBackoffMaxSeconds:  300,
+	}
+	tg, err := NewTunnelguard(driver, nil, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decide := func() string {
+		return tg.conditionallyResetPeers().Peers[0].Decision
+	}
+
+	if got := decide(); got != decisionReset {
+		t.Fatalf("first cycle decision = %s, want %s", got, decisionReset)
+	}
+	if got := decide(); got != decisionSkippedBackoff {
+		t.Fatalf("second cycle decision = %s, want %s", got, decisionSkippedBackoff)
+	}
+
+	// let the first backoff expire
+	tg.backoff["offline"].lastReset = time.Now().Add(-31 * time.Second)
+	if got := decide(); got != decisionReset {
+		t.Fatalf("third cycle decision = %s, want %s", got, decisionReset)
+	}
+	report := tg.conditionallyResetPeers()
+	if report.Peers[0].Decision != decisionSkippedBackoff {
+		t.Fatalf("fourth cycle decision = %s, want %s", report.Peers[0].Decision, decisionSkippedBackoff)
+	}
+	if report.NextCheck < 59*time.Second || report.NextCheck > time.Minute {
+		t.Errorf("fourth cycle next check = %v, want ~1m", report.NextCheck)
+	}
+
+	// a new handshake that is stale again starts a new episode
+	driver.peers[0].HandshakeLastSeen = handshakeAgo(30 * time.Minute)
+	if got := decide(); got != decisionReset {
+		t.Fatalf("decision after new handshake = %s, want %s", got, decisionReset)
+	}
+	if got := tg.backoff["offline"].consecutiveResets; got != 1 {
+		t.Errorf("consecutive resets after new handshake = %d, want 1", got)
+	}
+}
+
+func TestTunnelguard_rateLimit(t *testing.T) {
+	driver := &fakeDriver{
+		peers: []Peer{
+			{PublicKey: "a", HandshakeLastSeen: handshakeAgo(time.Hour)},
+			{PublicKey: "b", HandshakeLastSeen: handshakeAgo(time.Hour)},
+		},
+		endpoints: map[string]string{"a": "a.example:51820", "b": "b.example:51820"},
+	}
+	conf := InterfaceConfig{Interface: "wg-ratelimit", HandshakeTimeoutSeconds: 180, WaitSeconds: 30}
+	tg, err := NewTunnelguard(driver, nil, conf, WithResetLimiter(NewResetLimiter(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report := tg.conditionallyResetPeers()
+	if report.Peers[0].Decision != decisionReset || report.Peers[1].Decision != decisionSkippedRateLimit {
+		t.Errorf("decisions = %v, want reset and rate limited", report.Peers)
+	}
+	if len(driver.resets) != 1 {
+		t.Errorf("resets = %v, want exactly one", driver.resets)
+	}
+}
+
+func TestTunnelguard_rateLimitDryRun(t *testing.T) {
+	driver := &fakeDriver{
+		peers: []Peer{
+			{PublicKey: "a", HandshakeLastSeen: handshakeAgo(time.Hour)},
+			{PublicKey: "b", HandshakeLastSeen: handshakeAgo(time.Hour)},
+		},
+		endpoints: map[string]string{"a": "a.example:51820", "b": "b.example:51820"},
+	}
+	conf := InterfaceConfig{Interface: "wg-ratelimit-dryrun", HandshakeTimeoutSeconds: 180, WaitSeconds: 30}
+	limiter := NewResetLimiter(1)
+	tg, err := NewTunnelguard(driver, nil, conf, WithDryRun(), WithResetLimiter(limiter))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// dry-run resets neither use up the limit nor count as rate limited
+	report := tg.conditionallyResetPeers()
+	for _, peer := range report.Peers {
+		if peer.Decision != decisionDryRunReset {
+			t.Errorf("decision of %s = %s, want %s", peer.PublicKey, peer.Decision, decisionDryRunReset)
+		}
+	}
+	if len(limiter.resets) != 0 {
+		t.Errorf("limiter recorded %d resets, want none", len(limiter.resets))
+	}
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+	for key := range metrics.PeerResetsRateLimited {
+		if key.Interface == conf.Interface {
+			t.Errorf("peer %s counted as rate limited", key.PublicKey)
+		}
+	}
+}
+
+func TestTunnelguard_rateLimitEscalation(t *testing.T) {
+	for _, action := range []string{actionReaddPeer, actionSyncConfig, actionRestartInterface} {
+		t.Run(action, func(t *testing.T) {
+			driver := &fakeRemediationDriver{
+				fakeDriver: fakeDriver{
+					peers: []Peer{
+						{PublicKey: "a", HandshakeLastSeen: handshakeAgo(time.Hour)},
+						{PublicKey: "b", HandshakeLastSeen: handshakeAgo(time.Hour)},
+					},
+					endpoints: map[string]string{"a": "a.example:51820", "b": "b.example:51820"},
+				},
+			}
+			conf := InterfaceConfig{
+				Interface:               "wg-ratelimit",
+				HandshakeTimeoutSeconds: 180,
+				WaitSeconds:             30,
+				Escalation:              []EscalationStep{{Action: action}},
+			}
+			limiter := NewResetLimiter(1)
+			limiter.Allow()
+			tg, err := NewTunnelguard(driver, nil, conf, WithResetLimiter(limiter))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			report := tg.conditionallyResetPeers()
+			for _, peer := range report.Peers {
+				if peer.Decision != decisionSkippedRateLimit {
+					t.Errorf("decision of %s = %s, want %s", peer.PublicKey, peer.Decision, decisionSkippedRateLimit)
+				}
+			}
+			if len(driver.actions) != 0 {
+				t.Errorf("actions = %v, want none", driver.actions)
+			}
+		})
+	}
+}
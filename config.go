@@ -1,44 +1,515 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
 )
 
 const (
 	defaultMetricsFile         = "/var/lib/node_exporter/tunnelguard.prom"
 	defaultWireguardInterface  = "wg0"
 	defaultWireguardConfigFile = "/etc/wireguard/wg0.conf"
+	defaultWireguardConfigDir  = "/etc/wireguard"
+
+	defaultHandshakeTimeoutSeconds = 180
+	defaultWaitSeconds             = 30
+
+	driverCli  = "cli"
+	driverUapi = "uapi"
+
+	// envPrefix is the prefix of environment variables that override config options
+	envPrefix = "TUNNELGUARD_"
+	// configDropInDirName is the directory next to the config file whose files are merged into the config
+	configDropInDirName = "conf.d"
 )
 
 type TunnelguardConfig struct {
 	Interface  string `json:"wg_interface_name"`
 	ConfigFile string `json:"wg_config_file"`
 
+	// Interfaces lists all interfaces to supervise. If empty, the single interface defined by Interface and
+	// ConfigFile is used.
+	Interfaces []InterfaceConfig `json:"interfaces"`
+	// AutoDiscover finds all interfaces using 'wg show interfaces' and expects their config files at
+	// /etc/wireguard/<iface>.conf unless defined in Interfaces.
+	AutoDiscover bool `json:"wg_autodiscover"`
+
+	// Driver selects how to talk to WireGuard, either "cli" (default) or "uapi".
+	Driver string `json:"wg_driver"`
+
 	PublicKeyDict map[string]string `json:"pubkey_dict"`
 
+	// HandshakeTimeoutSeconds is the age of the latest handshake after which a peer is considered stale.
+	HandshakeTimeoutSeconds int `json:"handshake_timeout_seconds"`
+	// WaitSeconds is the polling interval used while peers are stale or WireGuard can not be queried.
+	WaitSeconds int `json:"wait_seconds"`
+	// Peers holds settings for individual peers, keyed by their public key.
+	Peers map[string]PeerConfig `json:"peers"`
+	// ResetBackoffMaxSeconds caps the exponential backoff between consecutive resets of a peer that do not lead to a
+	// new handshake. The backoff starts at WaitSeconds.
+	ResetBackoffMaxSeconds int `json:"reset_backoff_max_seconds"`
+	// PeerFilter selects the peers that are monitored.
+	PeerFilter PeerFilterConfig `json:"peer_filter"`
+	// Escalation is the ladder of remediation steps for stale peers, by default peers are only reset.
+	Escalation []EscalationStep `json:"escalation"`
+	// InterfaceRestartWindowSeconds limits restarts of an interface by the escalation ladder to one per window.
+	InterfaceRestartWindowSeconds int `json:"interface_restart_window_seconds"`
+	// MaxResetsPerMinute limits the number of resets across all interfaces, 0 means unlimited.
+	MaxResetsPerMinute int `json:"max_resets_per_minute"`
+
+	// ResetOnlyOnAddressChange resolves the endpoint's hostname and only resets a stale peer if the address differs
+	// from the endpoint currently used by WireGuard.
+	ResetOnlyOnAddressChange bool           `json:"reset_only_on_address_change"`
+	Resolver                 ResolverConfig `json:"resolver"`
+
+	Notifications NotificationsConfig `json:"notifications"`
+
+	// DryRun only logs and counts the actions that would have been taken without touching the interfaces.
+	DryRun bool `json:"dry_run"`
+
+	Logging LoggingConfig `json:"logging"`
+
 	MetricsFile string `json:"metrics_file"`
+	// MetricsSinks push the metrics to monitoring systems in addition to the metrics file.
+	MetricsSinks []MetricsSinkConfig `json:"metrics_sinks"`
+	// StateFile persists reset counters, backoff and health states of all peers across restarts, empty disables it.
+	StateFile string `json:"state_file"`
+	// ListenAddress enables the built-in http server that serves metrics on /metrics and the /healthz and /readyz
+	// probes.
+	ListenAddress string `json:"listen_address"`
+	// HealthMaxHeartbeatAgeSeconds is the age of the latest heartbeat after which /healthz fails. Defaults to twice
+	// the sum of handshake timeout and polling interval.
+	HealthMaxHeartbeatAgeSeconds int `json:"health_max_heartbeat_age_seconds"`
+	// ConfigWatchIntervalSeconds enables polling the config files for changes and reloading them, 0 disables it.
+	ConfigWatchIntervalSeconds int `json:"config_watch_interval_seconds"`
+	// ShutdownTimeoutSeconds is the time to wait for in-flight cycles to finish on shutdown.
+	ShutdownTimeoutSeconds int `json:"shutdown_timeout_seconds"`
+}
+
+// InterfaceConfig holds the settings of a single supervised WireGuard interface.
+type InterfaceConfig struct {
+	Interface  string `json:"wg_interface_name"`
+	ConfigFile string `json:"wg_config_file"`
+
+	// Driver overrides the global driver for this interface.
+	Driver string `json:"wg_driver"`
+	// UapiSocket is the path of the control socket used by the uapi driver, defaults to
+	// /var/run/wireguard/<iface>.sock.
+	UapiSocket string `json:"uapi_socket"`
+
+	// PublicKeyDict is merged with the global dict, entries of the interface take precedence.
+	PublicKeyDict map[string]string `json:"pubkey_dict"`
+
+	// HandshakeTimeoutSeconds overrides the global handshake timeout for this interface.
+	HandshakeTimeoutSeconds int `json:"handshake_timeout_seconds"`
+	// WaitSeconds overrides the global polling interval for this interface.
+	WaitSeconds int `json:"wait_seconds"`
+	// Peers is merged with the global peer settings, entries of the interface take precedence.
+	Peers map[string]PeerConfig `json:"peers"`
+	// ResetBackoffMaxSeconds overrides the global backoff cap for this interface.
+	ResetBackoffMaxSeconds int `json:"reset_backoff_max_seconds"`
+	// Escalation overrides the global escalation ladder for this interface.
+	Escalation []EscalationStep `json:"escalation"`
+	// InterfaceRestartWindowSeconds overrides the global restart window for this interface.
+	InterfaceRestartWindowSeconds int `json:"interface_restart_window_seconds"`
+	// PeerFilter adds rules to the global peer filter for this interface.
+	PeerFilter PeerFilterConfig `json:"peer_filter"`
+}
+
+// PeerConfig holds the settings of a single peer.
+type PeerConfig struct {
+	// HandshakeTimeoutSeconds overrides the handshake timeout of the interface for this peer.
+	HandshakeTimeoutSeconds int `json:"handshake_timeout_seconds"`
+	// Probe actively checks the reachability of a target inside the tunnel.
+	Probe *ProbeConfig `json:"probe,omitempty"`
+	// Escalation overrides the escalation ladder of the interface for this peer.
+	Escalation []EscalationStep `json:"escalation,omitempty"`
+	// Monitor set to false excludes the peer from monitoring.
+	Monitor *bool `json:"monitor,omitempty"`
+	// Endpoints are the candidate endpoints of the peer ordered by priority. Each reset of a stale peer rotates to the
+	// next candidate, they override the endpoint of the WireGuard config file.
+	Endpoints []string `json:"endpoints,omitempty"`
+	// FailbackSeconds is the time a peer must be healthy on a secondary endpoint before it is moved back to the primary.
+	FailbackSeconds int `json:"failback_seconds"`
 }
 
 func getDefault() TunnelguardConfig {
 	return TunnelguardConfig{
 		Interface:   defaultWireguardInterface,
 		ConfigFile:  defaultWireguardConfigFile,
+		Driver:      driverCli,
 		MetricsFile: defaultMetricsFile,
+		Logging: LoggingConfig{
+			Format:      logFormatText,
+			Level:       "info",
+			Destination: logDestinationStdout,
+		},
+
+		HandshakeTimeoutSeconds: defaultHandshakeTimeoutSeconds,
+		WaitSeconds:             defaultWaitSeconds,
+		ResetBackoffMaxSeconds:  defaultResetBackoffMaxSeconds,
+		ShutdownTimeoutSeconds:  defaultShutdownTimeoutSeconds,
+
+		InterfaceRestartWindowSeconds: defaultInterfaceRestartWindowSeconds,
 	}
 }
 
+// readConfig reads the config file, merges the drop-in files of the conf.d directory next to it and applies the
+// TUNNELGUARD_* environment variables.
 func readConfig(file string) (*TunnelguardConfig, error) {
+	return loadConfig(file, false)
+}
+
+// loadConfig is readConfig that optionally rejects unknown options.
+func loadConfig(file string, strict bool) (*TunnelguardConfig, error) {
+	values := map[string]any{}
+	if file != "" {
+		var err error
+		values, err = decodeConfigFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		dropIns, err := getConfigDropIns(file)
+		if err != nil {
+			return nil, err
+		}
+		for _, dropIn := range dropIns {
+			dropInValues, err := decodeConfigFile(dropIn)
+			if err != nil {
+				return nil, err
+			}
+			values = mergeConfigValues(values, dropInValues)
+		}
+	}
+
+	values, err := applyEnvOverrides(values)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(values)
+	if err != nil {
+		return nil, err
+	}
+
 	conf := getDefault()
-	if file == "" {
-		return &conf, nil
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if strict {
+		decoder.DisallowUnknownFields()
+	}
+	if err := decoder.Decode(&conf); err != nil {
+		return nil, err
 	}
+	return &conf, nil
+}
 
+// decodeConfigFile decodes a JSON, YAML or TOML file, depending on its extension.
+func decodeConfigFile(file string) (map[string]any, error) {
 	data, err := os.ReadFile(file)
 	if err != nil {
 		return nil, err
 	}
 
-	err = json.Unmarshal(data, &conf)
-	return &conf, err
+	var values map[string]any
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".json":
+		values, err = decodeJson(data)
+	case ".yaml", ".yml":
+		values, err = decodeYaml(data)
+	case ".toml":
+		values, err = decodeToml(data)
+	default:
+		return nil, fmt.Errorf("%s: unknown config format, expected .json, .yaml, .yml or .toml", file)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return values, nil
+}
+
+func decodeJson(data []byte) (map[string]any, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var values map[string]any
+	if err := decoder.Decode(&values); err != nil {
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			line := 1 + bytes.Count(data[:syntaxErr.Offset], []byte("\n"))
+			return nil, ConfigProblem{Line: line, Message: syntaxErr.Error()}
+		}
+		return nil, err
+	}
+	if values == nil {
+		values = map[string]any{}
+	}
+	return values, nil
+}
+
+func decodeYaml(data []byte) (map[string]any, error) {
+	var values map[string]any
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return nil, err
+	}
+	if values == nil {
+		values = map[string]any{}
+	}
+	return values, nil
+}
+
+func decodeToml(data []byte) (map[string]any, error) {
+	var values map[string]any
+	if err := toml.Unmarshal(data, &values); err != nil {
+		return nil, err
+	}
+	if values == nil {
+		values = map[string]any{}
+	}
+	return values, nil
+}
+
+// getConfigDropIns returns the config files in the conf.d directory next to the config file in lexical order.
+func getConfigDropIns(file string) ([]string, error) {
+	entries, err := os.ReadDir(configDropInDir(file))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var files []string
+	for _, entry := range entries {
+		switch strings.ToLower(filepath.Ext(entry.Name())) {
+		case ".json", ".yaml", ".yml", ".toml":
+			if !entry.IsDir() {
+				files = append(files, filepath.Join(configDropInDir(file), entry.Name()))
+			}
+		}
+	}
+	return files, nil
+}
+
+func configDropInDir(file string) string {
+	return filepath.Join(filepath.Dir(file), configDropInDirName)
+}
+
+// mergeConfigValues merges the override into base. Nested objects are merged, all other values are replaced.
+func mergeConfigValues(base, override map[string]any) map[string]any {
+	merged := mergeDicts(base, nil)
+	for key, val := range override {
+		baseMap, baseIsMap := merged[key].(map[string]any)
+		overrideMap, overrideIsMap := val.(map[string]any)
+		if baseIsMap && overrideIsMap {
+			merged[key] = mergeConfigValues(baseMap, overrideMap)
+		} else {
+			merged[key] = val
+		}
+	}
+	return merged
+}
+
+// applyEnvOverrides sets the options that are defined as TUNNELGUARD_<OPTION> environment variables, e.g.
+// TUNNELGUARD_WAIT_SECONDS. Objects and lists are given as JSON and objects are merged with the configured values.
+func applyEnvOverrides(values map[string]any) (map[string]any, error) {
+	configType := reflect.TypeOf(TunnelguardConfig{})
+	for idx := 0; idx < configType.NumField(); idx++ {
+		field := configType.Field(idx)
+		option, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if option == "" || option == "-" {
+			continue
+		}
+
+		name := envPrefix + strings.ToUpper(option)
+		raw, found := os.LookupEnv(name)
+		if !found {
+			continue
+		}
+
+		var value any
+		var err error
+		switch field.Type.Kind() {
+		case reflect.String:
+			value = raw
+		case reflect.Int:
+			value, err = strconv.Atoi(strings.TrimSpace(raw))
+		case reflect.Bool:
+			value, err = strconv.ParseBool(strings.TrimSpace(raw))
+		default:
+			decoder := json.NewDecoder(strings.NewReader(raw))
+			decoder.UseNumber()
+			err = decoder.Decode(&value)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for %s: %w", name, err)
+		}
+
+		values = mergeConfigValues(values, map[string]any{option: value})
+	}
+	return values, nil
+}
+
+// GetInterfaces returns the effective configuration of all interfaces that should be supervised. The discover
+// func is only invoked if auto-discovery is enabled.
+func (c *TunnelguardConfig) GetInterfaces(discover func() ([]string, error)) ([]InterfaceConfig, error) {
+	var interfaces []InterfaceConfig
+
+	switch {
+	case c.AutoDiscover:
+		if discover == nil {
+			return nil, errors.New("auto-discovery enabled but no discovery method available")
+		}
+		names, err := discover()
+		if err != nil {
+			return nil, fmt.Errorf("could not discover interfaces: %w", err)
+		}
+
+		explicit := make(map[string]InterfaceConfig, len(c.Interfaces))
+		for _, iface := range c.Interfaces {
+			explicit[iface.Interface] = iface
+		}
+		for _, name := range names {
+			iface, found := explicit[name]
+			if !found {
+				iface = InterfaceConfig{Interface: name}
+			}
+			interfaces = append(interfaces, iface)
+		}
+	case len(c.Interfaces) > 0:
+		interfaces = append(interfaces, c.Interfaces...)
+	default:
+		interfaces = append(interfaces, InterfaceConfig{
+			Interface:  c.Interface,
+			ConfigFile: c.ConfigFile,
+		})
+	}
+
+	if len(interfaces) == 0 {
+		return nil, errors.New("no interfaces to supervise")
+	}
+
+	seen := map[string]bool{}
+	for idx := range interfaces {
+		iface := &interfaces[idx]
+		if len(iface.Interface) == 0 {
+			return nil, fmt.Errorf("interface #%d: empty interface name", idx)
+		}
+		if seen[iface.Interface] {
+			return nil, fmt.Errorf("interface %q defined multiple times", iface.Interface)
+		}
+		seen[iface.Interface] = true
+
+		if len(iface.ConfigFile) == 0 {
+			iface.ConfigFile = filepath.Join(defaultWireguardConfigDir, iface.Interface+".conf")
+		}
+
+		if len(iface.Driver) == 0 {
+			iface.Driver = c.Driver
+		}
+		if len(iface.Driver) == 0 {
+			iface.Driver = driverCli
+		}
+		if iface.Driver != driverCli && iface.Driver != driverUapi {
+			return nil, fmt.Errorf("interface %q: unknown driver %q", iface.Interface, iface.Driver)
+		}
+		iface.PublicKeyDict = mergeDicts(c.PublicKeyDict, iface.PublicKeyDict)
+
+		if iface.HandshakeTimeoutSeconds == 0 {
+			iface.HandshakeTimeoutSeconds = c.HandshakeTimeoutSeconds
+		}
+		if iface.HandshakeTimeoutSeconds == 0 {
+			iface.HandshakeTimeoutSeconds = defaultHandshakeTimeoutSeconds
+		}
+		if iface.WaitSeconds == 0 {
+			iface.WaitSeconds = c.WaitSeconds
+		}
+		if iface.WaitSeconds == 0 {
+			iface.WaitSeconds = defaultWaitSeconds
+		}
+		if iface.ResetBackoffMaxSeconds == 0 {
+			iface.ResetBackoffMaxSeconds = c.ResetBackoffMaxSeconds
+		}
+		if iface.ResetBackoffMaxSeconds == 0 {
+			iface.ResetBackoffMaxSeconds = defaultResetBackoffMaxSeconds
+		}
+		iface.Peers = mergeDicts(c.Peers, iface.Peers)
+		iface.PeerFilter = c.PeerFilter.merge(iface.PeerFilter)
+		if len(iface.Escalation) == 0 {
+			iface.Escalation = c.Escalation
+		}
+		if iface.InterfaceRestartWindowSeconds == 0 {
+			iface.InterfaceRestartWindowSeconds = c.InterfaceRestartWindowSeconds
+		}
+		if iface.InterfaceRestartWindowSeconds == 0 {
+			iface.InterfaceRestartWindowSeconds = defaultInterfaceRestartWindowSeconds
+		}
+
+		if err := iface.validate(); err != nil {
+			return nil, fmt.Errorf("interface %q: %w", iface.Interface, err)
+		}
+	}
+
+	return interfaces, nil
+}
+
+func (c *InterfaceConfig) validate() error {
+	if c.HandshakeTimeoutSeconds < 0 {
+		return fmt.Errorf("invalid handshake timeout %d", c.HandshakeTimeoutSeconds)
+	}
+	if c.WaitSeconds < 0 {
+		return fmt.Errorf("invalid wait seconds %d", c.WaitSeconds)
+	}
+	if c.ResetBackoffMaxSeconds < 0 {
+		return fmt.Errorf("invalid reset backoff %d", c.ResetBackoffMaxSeconds)
+	}
+	if err := c.PeerFilter.validate(); err != nil {
+		return fmt.Errorf("invalid peer filter: %w", err)
+	}
+	if c.InterfaceRestartWindowSeconds < 0 {
+		return fmt.Errorf("invalid interface restart window %d", c.InterfaceRestartWindowSeconds)
+	}
+	if err := validateEscalation(c.Escalation, c.Driver); err != nil {
+		return fmt.Errorf("invalid escalation: %w", err)
+	}
+
+	for publicKey, peer := range c.Peers {
+		if peer.HandshakeTimeoutSeconds < 0 {
+			return fmt.Errorf("peer %s: invalid handshake timeout %d", publicKey, peer.HandshakeTimeoutSeconds)
+		}
+		if err := validateEscalation(peer.Escalation, c.Driver); err != nil {
+			return fmt.Errorf("peer %s: invalid escalation: %w", publicKey, err)
+		}
+		if err := peer.validateEndpoints(); err != nil {
+			return fmt.Errorf("peer %s: invalid endpoints: %w", publicKey, err)
+		}
+		if peer.Probe != nil {
+			if err := peer.Probe.validate(); err != nil {
+				return fmt.Errorf("peer %s: invalid probe: %w", publicKey, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func mergeDicts[V any](base, override map[string]V) map[string]V {
+	merged := make(map[string]V, len(base)+len(override))
+	for key, val := range base {
+		merged[key] = val
+	}
+	for key, val := range override {
+		merged[key] = val
+	}
+	return merged
 }
@@ -0,0 +1,478 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTunnelguardConfig_GetInterfaces(t *testing.T) {
+	tests := []struct {
+		name     string
+		conf     TunnelguardConfig
+		discover func() ([]string, error)
+		want     []InterfaceConfig
+		wantErr  bool
+	}{
+		{
+			name: "legacy single interface",
+			conf: TunnelguardConfig{
+				Interface:     "wg0",
+				ConfigFile:    "/etc/wireguard/wg0.conf",
+				PublicKeyDict: map[string]string{"a": "A"},
+			},
+			want: []InterfaceConfig{
+				{
+					Interface:     "wg0",
+					ConfigFile:    "/etc/wireguard/wg0.conf",
+					Driver:        driverCli,
+					PublicKeyDict: map[string]string{"a": "A"},
+				},
+			},
+		},
+		{
+			name: "multiple interfaces",
+			conf: TunnelguardConfig{
+				Interface:     "wg0",
+				ConfigFile:    "/etc/wireguard/wg0.conf",
+				PublicKeyDict: map[string]string{"a": "A", "b": "B"},
+				Interfaces: []InterfaceConfig{
+					{
+						Interface:     "wg1",
+						ConfigFile:    "/tmp/wg1.conf",
+						PublicKeyDict: map[string]string{"b": "b-override"},
+					},
+					{
+						Interface: "wg-backup",
+						Driver:    driverUapi,
+					},
+				},
+			},
+			want: []InterfaceConfig{
+				{
+					Interface:     "wg1",
+					ConfigFile:    "/tmp/wg1.conf",
+					Driver:        driverCli,
+					PublicKeyDict: map[string]string{"a": "A", "b": "b-override"},
+				},
+				{
+					Interface:     "wg-backup",
+					ConfigFile:    "/etc/wireguard/wg-backup.conf",
+					Driver:        driverUapi,
+					PublicKeyDict: map[string]string{"a": "A", "b": "B"},
+				},
+			},
+		},
+		{
+			name: "timeouts",
+			conf: TunnelguardConfig{
+				HandshakeTimeoutSeconds: 150,
+				WaitSeconds:             10,
+				Peers: map[string]PeerConfig{
+					"a": {HandshakeTimeoutSeconds: 600},
+					"b": {HandshakeTimeoutSeconds: 300},
+				},
+				Interfaces: []InterfaceConfig{
+					{
+						Interface:   "wg0",
+						WaitSeconds: 20,
+						Peers: map[string]PeerConfig{
+							"b": {HandshakeTimeoutSeconds: 900},
+						},
+					},
+					{
+						Interface:               "wg1",
+						HandshakeTimeoutSeconds: 120,
+					},
+				},
+			},
+			want: []InterfaceConfig{
+				{
+					Interface:               "wg0",
+					ConfigFile:              "/etc/wireguard/wg0.conf",
+					Driver:                  driverCli,
+					PublicKeyDict:           map[string]string{},
+					HandshakeTimeoutSeconds: 150,
+					WaitSeconds:             20,
+					Peers: map[string]PeerConfig{
+						"a": {HandshakeTimeoutSeconds: 600},
+						"b": {HandshakeTimeoutSeconds: 900},
+					},
+				},
+				{
+					Interface:               "wg1",
+					ConfigFile:              "/etc/wireguard/wg1.conf",
+					Driver:                  driverCli,
+					PublicKeyDict:           map[string]string{},
+					HandshakeTimeoutSeconds: 120,
+					WaitSeconds:             10,
+					Peers: map[string]PeerConfig{
+						"a": {HandshakeTimeoutSeconds: 600},
+						"b": {HandshakeTimeoutSeconds: 300},
+					},
+				},
+			},
+		},
+		{
+			name: "invalid peer timeout",
+			conf: TunnelguardConfig{
+				Interface: "wg0",
+				Peers: map[string]PeerConfig{
+					"a": {HandshakeTimeoutSeconds: -1},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "duplicate interfaces",
+			conf: TunnelguardConfig{
+				Interfaces: []InterfaceConfig{
+					{Interface: "wg1"},
+					{Interface: "wg1"},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "unknown driver",
+			conf: TunnelguardConfig{
+				Interfaces: []InterfaceConfig{
+					{Interface: "wg1", Driver: "netlink"},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "autodiscovery",
+			conf: TunnelguardConfig{
+				AutoDiscover: true,
+				Interfaces: []InterfaceConfig{
+					{Interface: "wg1", ConfigFile: "/tmp/wg1.conf"},
+				},
+			},
+			discover: func() ([]string, error) {
+				return []string{"wg0", "wg1"}, nil
+			},
+			want: []InterfaceConfig{
+				{
+					Interface:     "wg0",
+					ConfigFile:    "/etc/wireguard/wg0.conf",
+					Driver:        driverCli,
+					PublicKeyDict: map[string]string{},
+				},
+				{
+					Interface:     "wg1",
+					ConfigFile:    "/tmp/wg1.conf",
+					Driver:        driverCli,
+					PublicKeyDict: map[string]string{},
+				},
+			},
+		},
+		{
+			name: "autodiscovery fails",
+			conf: TunnelguardConfig{
+				AutoDiscover: true,
+			},
+			discover: func() ([]string, error) {
+				return nil, errors.New("wg not found")
+			},
+			wantErr: true,
+		},
+		{
+			name: "autodiscovery finds nothing",
+			conf: TunnelguardConfig{
+				AutoDiscover: true,
+			},
+			discover: func() ([]string, error) {
+				return nil, nil
+			},
+			wantErr: true,
+		},
+	}
+	for idx := range tests {
+		for i := range tests[idx].want {
+			want := &tests[idx].want[i]
+			if want.HandshakeTimeoutSeconds == 0 {
+				want.HandshakeTimeoutSeconds = defaultHandshakeTimeoutSeconds
+			}
+			if want.WaitSeconds == 0 {
+				want.WaitSeconds = defaultWaitSeconds
+			}
+			if want.ResetBackoffMaxSeconds == 0 {
+				want.ResetBackoffMaxSeconds = defaultResetBackoffMaxSeconds
+			}
+			if want.InterfaceRestartWindowSeconds == 0 {
+				want.InterfaceRestartWindowSeconds = defaultInterfaceRestartWindowSeconds
+			}
+			if want.Peers == nil {
+				want.Peers = map[string]PeerConfig{}
+			}
+		}
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.conf.GetInterfaces(tt.discover)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("GetInterfaces() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GetInterfaces() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func writeConfigFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	file := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestReadConfig_formats(t *testing.T) {
+	want := getDefault()
+	want.Interface = "wg1"
+	want.WaitSeconds = 10
+	want.DryRun = true
+	want.PublicKeyDict = map[string]string{testKeyA: "Home"}
+	want.Peers = map[string]PeerConfig{testKeyA: {HandshakeTimeoutSeconds: 60}}
+
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{
+			name: "config.json",
+			content: fmt.Sprintf(`{"wg_interface_name": "wg1", "wait_seconds": 10, "dry_run": true,
+				"pubkey_dict": {%q: "Home"}, "peers": {%q: {"handshake_timeout_seconds": 60}}}`, testKeyA, testKeyA),
+		},
+		{
+			name: "config.yaml",
+			content: fmt.Sprintf(`wg_interface_name: wg1
+wait_seconds: 10
+dry_run: true
+pubkey_dict:
+  %q: Home
+peers:
+  %s:
+    handshake_timeout_seconds: 60
+`, testKeyA, testKeyA),
+		},
+		{
+			name: "config.toml",
+			content: fmt.Sprintf(`wg_interface_name = "wg1"
+wait_seconds = 10
+dry_run = true
+
+[pubkey_dict]
+%q = "Home"
+
+[peers.%q]
+handshake_timeout_seconds = 60
+`, testKeyA, testKeyA),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := writeConfigFile(t, t.TempDir(), tt.name, tt.content)
+			got, err := readConfig(file)
+			if err != nil {
+				t.Fatalf("readConfig() error = %v", err)
+			}
+			if !reflect.DeepEqual(*got, want) {
+				t.Errorf("readConfig() got = %+v, want %+v", *got, want)
+			}
+		})
+	}
+}
+
+// leading zeros denote decimal numbers and escapes follow the format, not Go
+func TestDecodeConfigFile_scalars(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    map[string]any
+	}{
+		{
+			name:    "config.yaml",
+			content: "pubkey_dict:\n  a: 0800\n  b: \"C:\\\\temp\"\n",
+			want:    map[string]any{"pubkey_dict": map[string]any{"a": 800.0, "b": `C:\temp`}},
+		},
+		{
+			name:    "config.toml",
+			content: "[pubkey_dict]\na = \"\"\"\nmulti\nline\"\"\"\nb = \"C:\\\\temp \\u00e9\"\n",
+			want:    map[string]any{"pubkey_dict": map[string]any{"a": "multi\nline", "b": `C:\temp é`}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := writeConfigFile(t, t.TempDir(), tt.name, tt.content)
+			got, err := decodeConfigFile(file)
+			if err != nil {
+				t.Fatalf("decodeConfigFile() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("decodeConfigFile() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadConfig_errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		wantErr string
+	}{
+		{
+			name:    "unknown extension",
+			file:    "config.ini",
+			content: "wait_seconds = 10",
+			wantErr: "unknown config format",
+		},
+		{
+			name:    "json syntax error",
+			file:    "config.json",
+			content: "{\n\"wait_seconds\": 10,\n}",
+			wantErr: "config.json: line 3",
+		},
+		{
+			name:    "wrong type",
+			file:    "config.yaml",
+			content: "wait_seconds: soon\n",
+			wantErr: "wait_seconds",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := writeConfigFile(t, t.TempDir(), tt.file, tt.content)
+			_, err := readConfig(file)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("readConfig() error = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReadConfig_dropIns(t *testing.T) {
+	dir := t.TempDir()
+	file := writeConfigFile(t, dir, "config.json", `{"wait_seconds": 10, "dry_run": true, "pubkey_dict": {"a": "A", "b": "B"}}`)
+	writeConfigFile(t, dir, "conf.d/10-names.yaml", "pubkey_dict:\n  b: B2\n  c: C\n")
+	writeConfigFile(t, dir, "conf.d/20-wait.toml", "wait_seconds = 20\n")
+	writeConfigFile(t, dir, "conf.d/README", "ignored")
+
+	got, err := readConfig(file)
+	if err != nil {
+		t.Fatalf("readConfig() error = %v", err)
+	}
+	if got.WaitSeconds != 20 {
+		t.Errorf("WaitSeconds = %d, want 20", got.WaitSeconds)
+	}
+	if !got.DryRun {
+		t.Errorf("DryRun = false, want true")
+	}
+	wantDict := map[string]string{"a": "A", "b": "B2", "c": "C"}
+	if !reflect.DeepEqual(got.PublicKeyDict, wantDict) {
+		t.Errorf("PublicKeyDict = %v, want %v", got.PublicKeyDict, wantDict)
+	}
+}
+
+func TestReadConfig_envOverrides(t *testing.T) {
+	file := writeConfigFile(t, t.TempDir(), "config.yaml", "wait_seconds: 10\npubkey_dict:\n  a: A\n")
+	t.Setenv("TUNNELGUARD_WG_INTERFACE_NAME", "wg-env")
+	t.Setenv("TUNNELGUARD_WAIT_SECONDS", "15")
+	t.Setenv("TUNNELGUARD_DRY_RUN", "true")
+	t.Setenv("TUNNELGUARD_PUBKEY_DICT", `{"b": "B"}`)
+	t.Setenv("TUNNELGUARD_RESOLVER", `{"timeout_seconds": 3}`)
+
+	got, err := readConfig(file)
+	if err != nil {
+		t.Fatalf("readConfig() error = %v", err)
+	}
+	if got.Interface != "wg-env" || got.WaitSeconds != 15 || !got.DryRun || got.Resolver.TimeoutSeconds != 3 {
+		t.Errorf("readConfig() got = %+v", *got)
+	}
+	wantDict := map[string]string{"a": "A", "b": "B"}
+	if !reflect.DeepEqual(got.PublicKeyDict, wantDict) {
+		t.Errorf("PublicKeyDict = %v, want %v", got.PublicKeyDict, wantDict)
+	}
+
+	// the environment is used without a config file as well
+	got, err = readConfig("")
+	if err != nil {
+		t.Fatalf("readConfig() error = %v", err)
+	}
+	if got.WaitSeconds != 15 || got.MetricsFile != defaultMetricsFile {
+		t.Errorf("readConfig() got = %+v", *got)
+	}
+
+	t.Setenv("TUNNELGUARD_WAIT_SECONDS", "soon")
+	if _, err := readConfig(file); err == nil || !strings.Contains(err.Error(), "TUNNELGUARD_WAIT_SECONDS") {
+		t.Errorf("readConfig() error = %v, want error naming the variable", err)
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	dir := t.TempDir()
+	validWg := writeConfigFile(t, dir, "wg-valid.conf", fmt.Sprintf("[Peer]\nPublicKey = %s\nEndpoint = peer.example:51820\n", testKeyA))
+	invalidWg := writeConfigFile(t, dir, "wg-invalid.conf", "[Peer]\nPublicKey = invalid\n")
+
+	tests := []struct {
+		name    string
+		content string
+		wantErr []string
+	}{
+		{
+			name:    "valid",
+			content: fmt.Sprintf(`{"wg_interface_name": "wg-valid", "wg_config_file": %q, "metrics_file": ""}`, validWg),
+		},
+		{
+			name:    "unknown option",
+			content: fmt.Sprintf(`{"wg_interface_name": "wg-valid", "wg_config_file": %q, "metrics_file": "", "wait_second": 10}`, validWg),
+			wantErr: []string{"wait_second"},
+		},
+		{
+			name:    "invalid wireguard config",
+			content: fmt.Sprintf(`{"wg_interface_name": "wg-invalid", "wg_config_file": %q, "metrics_file": ""}`, invalidWg),
+			wantErr: []string{invalidWg + ":2:"},
+		},
+		{
+			name: "multiple problems",
+			content: fmt.Sprintf(`{"wg_interface_name": "wg-valid", "wg_config_file": %q, "metrics_file": "",
+				"shutdown_timeout_seconds": -1, "reset_only_on_address_change": true, "resolver": {"prefer": "ipv5"}}`, validWg),
+			wantErr: []string{"shutdown_timeout_seconds", "resolver"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := writeConfigFile(t, t.TempDir(), "config.json", tt.content)
+			err := validateConfig(file, nil)
+			if len(tt.wantErr) == 0 {
+				if err != nil {
+					t.Errorf("validateConfig() error = %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("validateConfig() expected error")
+			}
+			for _, want := range tt.wantErr {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("validateConfig() error = %v, want it to contain %q", err, want)
+				}
+			}
+		})
+	}
+}
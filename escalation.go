@@ -0,0 +1,159 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	actionReaddPeer        = "readd_peer"
+	actionSyncConfig       = "syncconf"
+	actionRestartInterface = "restart_interface"
+
+	defaultInterfaceRestartWindowSeconds = 3600
+
+	remediationSuccess = "success"
+	remediationFailure = "failure"
+)
+
+// RemediationDriver is implemented by drivers that support the escalated remediation steps. Drivers return an error
+// wrapping errors.ErrUnsupported for steps they can not perform.
+type RemediationDriver interface {
+	// ReaddPeer removes the peer and adds it again using its settings from the config file and the given endpoint.
+	ReaddPeer(publicKey string, endpoint string) error
+	// SyncConfig applies the config file to the interface without disrupting peers whose settings did not change.
+	SyncConfig() error
+	// RestartInterface takes the whole interface down and brings it up again.
+	RestartInterface() error
+}
+
+// EscalationStep is a single step of the remediation ladder that is climbed while resets of a stale peer do not
+// lead to a new handshake.
+type EscalationStep struct {
+	// Action is one of reset_peer, readd_peer, syncconf or restart_interface.
+	Action string `json:"action"`
+	// Attempts is the number of attempts before escalating to the next step, defaults to 1. The last step is
+	// repeated until the peer recovers.
+	Attempts int `json:"attempts"`
+	// CooldownSeconds is the minimum time after an attempt of this step before the next attempt of any step.
+	CooldownSeconds int `json:"cooldown_seconds"`
+}
+
+func (s *EscalationStep) validate() error {
+	switch s.Action {
+	case actionResetPeer, actionReaddPeer, actionSyncConfig, actionRestartInterface:
+	default:
+		return fmt.Errorf("unknown action %q", s.Action)
+	}
+
+	if s.Attempts < 0 {
+		return fmt.Errorf("%s: invalid attempts %d", s.Action, s.Attempts)
+	}
+	if s.CooldownSeconds < 0 {
+		return fmt.Errorf("%s: invalid cooldown %d", s.Action, s.CooldownSeconds)
+	}
+	return nil
+}
+
+func (s *EscalationStep) attempts() int {
+	return max(1, s.Attempts)
+}
+
+func (s *EscalationStep) cooldown() time.Duration {
+	return time.Duration(s.CooldownSeconds) * time.Second
+}
+
+// needsEndpoint returns whether the action sets the endpoint of the peer.
+func needsEndpoint(action string) bool {
+	return action == actionResetPeer || action == actionReaddPeer
+}
+
+func validateEscalation(steps []EscalationStep, driver string) error {
+	for idx, step := range steps {
+		if err := step.validate(); err != nil {
+			return fmt.Errorf("step #%d: %w", idx, err)
+		}
+		if driver == driverUapi && (step.Action == actionSyncConfig || step.Action == actionRestartInterface) {
+			return fmt.Errorf("step #%d: %s is not supported by the uapi driver", idx, step.Action)
+		}
+	}
+	return nil
+}
+
+// isSkippedReset returns whether a reset_peer step has been skipped because setting the endpoint again would not
+// change anything. Such peers move on to the next step of the ladder right away.
+func isSkippedReset(decision string) bool {
+	return decision == decisionSkippedNoEndpoint || decision == decisionSkippedStaticEndpoint || decision == decisionSkippedAddressUnchanged
+}
+
+func (t *Tunnelguard) escalationLadder(publicKey string) []EscalationStep {
+	if peer, found := t.peers[publicKey]; found && len(peer.Escalation) > 0 {
+		return peer.Escalation
+	}
+	return t.escalation
+}
+
+// escalationStep returns the step of the peer's ladder for the given number of consecutive attempts and its index.
+// Without a ladder, the peer is reset.
+func (t *Tunnelguard) escalationStep(publicKey string, attempts int) (int, EscalationStep) {
+	ladder := t.escalationLadder(publicKey)
+	if len(ladder) == 0 {
+		return 0, EscalationStep{Action: actionResetPeer}
+	}
+
+	for idx, step := range ladder[:len(ladder)-1] {
+		if attempts < step.attempts() {
+			return idx, step
+		}
+		attempts -= step.attempts()
+	}
+	return len(ladder) - 1, ladder[len(ladder)-1]
+}
+
+// nextStepAttempts returns the number of consecutive attempts at which the step following the given one starts. It
+// returns false if the step is the last one of the peer's ladder.
+func (t *Tunnelguard) nextStepAttempts(publicKey string, idx int) (int, bool) {
+	ladder := t.escalationLadder(publicKey)
+	if idx+1 >= len(ladder) {
+		return 0, false
+	}
+
+	attempts := 0
+	for _, step := range ladder[:idx+1] {
+		attempts += step.attempts()
+	}
+	return attempts, true
+}
+
+// restartAllowed reports whether the interface may be restarted, restarts are limited to one per window.
+func (t *Tunnelguard) restartAllowed() (bool, time.Duration) {
+	if t.lastRestart.IsZero() {
+		return true, 0
+	}
+	remaining := t.restartWindow - time.Since(t.lastRestart)
+	return remaining <= 0, remaining
+}
+
+// remediate performs the action of an escalation step.
+func (t *Tunnelguard) remediate(action string, publicKey string, endpoint string) error {
+	if action == actionResetPeer {
+		return t.wg.ResetPeer(publicKey, endpoint)
+	}
+
+	driver, ok := t.wg.(RemediationDriver)
+	if !ok {
+		return fmt.Errorf("%s is not supported by the driver: %w", action, errors.ErrUnsupported)
+	}
+
+	switch action {
+	case actionReaddPeer:
+		return driver.ReaddPeer(publicKey, endpoint)
+	case actionSyncConfig:
+		return driver.SyncConfig()
+	case actionRestartInterface:
+		return driver.RestartInterface()
+	default:
+		return fmt.Errorf("unknown action %q", action)
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeRemediationDriver records all remediation actions in the order they have been taken.
+type fakeRemediationDriver struct {
+	fakeDriver
+	actions []string
+}
+
+func (f *fakeRemediationDriver) ResetPeer(publicKey string, endpoint string) error {
+	f.actions = append(f.actions, actionResetPeer)
+	return nil
+}
+
+func (f *fakeRemediationDriver) ReaddPeer(publicKey string, endpoint string) error {
+	f.actions = append(f.actions, actionReaddPeer)
+	return nil
+}
+
+func (f *fakeRemediationDriver) SyncConfig() error {
+	f.actions = append(f.actions, actionSyncConfig)
+	return nil
+}
+
+func (f *fakeRemediationDriver) RestartInterface() error {
+	f.actions = append(f.actions, actionRestartInterface)
+	return nil
+}
+
+func TestTunnelguard_escalationStep(t *testing.T) {
+	ladder := []EscalationStep{
+		{Action: actionResetPeer, Attempts: 2},
+		{Action: actionReaddPeer},
+		{Action: actionRestartInterface},
+	}
+	tg := &Tunnelguard{
+		escalation: ladder,
+		peers: map[string]PeerConfig{
+			"custom": {Escalation: []EscalationStep{{Action: actionSyncConfig}}},
+		},
+	}
+
+	tests := []struct {
+		publicKey string
+		attempts  int
+		wantIdx   int
+		want      string
+	}{
+		{publicKey: "a", attempts: 0, wantIdx: 0, want: actionResetPeer},
+		{publicKey: "a", attempts: 1, wantIdx: 0, want: actionResetPeer},
+		{publicKey: "a", attempts: 2, wantIdx: 1, want: actionReaddPeer},
+		{publicKey: "a", attempts: 3, wantIdx: 2, want: actionRestartInterface},
+		{publicKey: "a", attempts: 10, wantIdx: 2, want: actionRestartInterface},
+		{publicKey: "custom", attempts: 0, wantIdx: 0, want: actionSyncConfig},
+	}
+	for _, tt := range tests {
+		idx, step := tg.escalationStep(tt.publicKey, tt.attempts)
+		if idx != tt.wantIdx || step.Action != tt.want {
+			t.Errorf("escalationStep(%s, %d) = %d, %s, want %d, %s", tt.publicKey, tt.attempts, idx, step.Action, tt.wantIdx, tt.want)
+		}
+	}
+
+	if attempts, found := tg.nextStepAttempts("a", 1); !found || attempts != 3 {
+		t.Errorf("nextStepAttempts(a, 1) = %d, %v, want 3, true", attempts, found)
+	}
+	if _, found := tg.nextStepAttempts("a", 2); found {
+		t.Error("nextStepAttempts() of the last step expected no next step")
+	}
+
+	if _, step := (&Tunnelguard{}).escalationStep("a", 5); step.Action != actionResetPeer {
+		t.Errorf("escalationStep() without ladder = %s, want %s", step.Action, actionResetPeer)
+	}
+}
+
+func TestTunnelguard_conditionallyResetPeers_escalation(t *testing.T) {
+	driver := &fakeRemediationDriver{
+		fakeDriver: fakeDriver{
+			peers:     []Peer{{PublicKey: "stale", HandshakeLastSeen: handshakeAgo(time.Hour)}},
+			endpoints: map[string]string{"stale": "host.example:51820"},
+		},
+	}
+	conf := InterfaceConfig{
+		Interface:               "wg-escalation",
+		HandshakeTimeoutSeconds: 180,
+		WaitSeconds:             30,
+		Escalation: []EscalationStep{
+			{Action: actionResetPeer, Attempts: 2},
+			{Action: actionReaddPeer},
+			{Action: actionSyncConfig},
+			{Action: actionRestartInterface},
+		},
+		InterfaceRestartWindowSeconds: 3600,
+	}
+	tg, err := NewTunnelguard(driver, nil, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decisions []string
+	for i := 0; i < 6; i++ {
+		report := tg.conditionallyResetPeers()
+		decisions = append(decisions, report.Peers[0].Decision)
+		// skip the backoff
+		tg.backoff["stale"].lastReset = time.Time{}
+	}
+
+	wantActions := []string{actionResetPeer, actionResetPeer, actionReaddPeer, actionSyncConfig, actionRestartInterface}
+	if !reflect.DeepEqual(driver.actions, wantActions) {
+		t.Errorf("actions = %v, want %v", driver.actions, wantActions)
+	}
+	if got := decisions[5]; got != decisionSkippedRestartWindow {
+		t.Errorf("decision after restart = %s, want %s", got, decisionSkippedRestartWindow)
+	}
+
+	// a new handshake starts at the bottom of the ladder again
+	driver.peers[0].HandshakeLastSeen = handshakeAgo(time.Second)
+	tg.conditionallyResetPeers()
+	driver.peers[0].HandshakeLastSeen = handshakeAgo(time.Hour)
+	tg.conditionallyResetPeers()
+	if got := driver.actions[len(driver.actions)-1]; got != actionResetPeer {
+		t.Errorf("action after recovery = %s, want %s", got, actionResetPeer)
+	}
+}
+
+func TestTunnelguard_conditionallyResetPeers_escalationWithoutDynamicEndpoint(t *testing.T) {
+	tests := []struct {
+		name     string
+		endpoint string
+	}{
+		{name: "static endpoint", endpoint: "192.0.2.1:51820"},
+		{name: "no endpoint"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			driver := &fakeRemediationDriver{
+				fakeDriver: fakeDriver{
+					peers:     []Peer{{PublicKey: "stale", HandshakeLastSeen: handshakeAgo(time.Hour)}},
+					endpoints: map[string]string{"stale": tt.endpoint},
+				},
+			}
+			conf := InterfaceConfig{
+				Interface:               "wg-escalation",
+				HandshakeTimeoutSeconds: 180,
+				WaitSeconds:             30,
+				Escalation: []EscalationStep{
+					{Action: actionResetPeer, Attempts: 2},
+					{Action: actionReaddPeer},
+					{Action: actionSyncConfig},
+				},
+			}
+			tg, err := NewTunnelguard(driver, nil, conf)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var decisions []string
+			for range 3 {
+				report := tg.conditionallyResetPeers()
+				decisions = append(decisions, report.Peers[0].Decision)
+				tg.backoff["stale"].lastReset = time.Time{}
+			}
+
+			// the pointless reset_peer step is passed over right away
+			wantActions := []string{actionReaddPeer, actionSyncConfig, actionSyncConfig}
+			if !reflect.DeepEqual(driver.actions, wantActions) {
+				t.Errorf("actions = %v, want %v", driver.actions, wantActions)
+			}
+			if want := []string{decisionReset, decisionReset, decisionReset}; !reflect.DeepEqual(decisions, want) {
+				t.Errorf("decisions = %v, want %v", decisions, want)
+			}
+		})
+	}
+}
+
+func TestTunnelguard_conditionallyResetPeers_cooldown(t *testing.T) {
+	driver := &fakeRemediationDriver{
+		fakeDriver: fakeDriver{
+			peers:     []Peer{{PublicKey: "stale", HandshakeLastSeen: handshakeAgo(time.Hour)}},
+			endpoints: map[string]string{"stale": "host.example:51820"},
+		},
+	}
+	conf := InterfaceConfig{
+		Interface:               "wg-escalation",
+		HandshakeTimeoutSeconds: 180,
+		WaitSeconds:             30,
+		Escalation:              []EscalationStep{{Action: actionReaddPeer, CooldownSeconds: 600}},
+	}
+	tg, err := NewTunnelguard(driver, nil, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tg.conditionallyResetPeers()
+	tg.backoff["stale"].lastReset = time.Time{}
+	report := tg.conditionallyResetPeers()
+
+	if got := report.Peers[0].Decision; got != decisionSkippedBackoff {
+		t.Errorf("decision during cooldown = %s, want %s", got, decisionSkippedBackoff)
+	}
+	if len(driver.actions) != 1 {
+		t.Errorf("actions = %v, want a single readd", driver.actions)
+	}
+}
+
+func TestTunnelguard_resetPeer_unsupported(t *testing.T) {
+	driver := &fakeDriver{tunnelUp: true}
+	conf := InterfaceConfig{Interface: "wg-escalation", HandshakeTimeoutSeconds: 180, WaitSeconds: 30}
+	tg, err := NewTunnelguard(driver, nil, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report := &CycleReport{}
+	decision, _ := tg.resetPeer(report, Peer{PublicKey: "stale"}, reasonHandshakeStale, actionSyncConfig)
+	if decision != decisionResetFailed {
+		t.Errorf("decision = %s, want %s", decision, decisionResetFailed)
+	}
+	if len(report.Errors) != 1 || !strings.Contains(report.Errors[0], errors.ErrUnsupported.Error()) {
+		t.Errorf("errors = %v, want unsupported error", report.Errors)
+	}
+}
+
+func Test_validateEscalation(t *testing.T) {
+	tests := []struct {
+		name    string
+		steps   []EscalationStep
+		driver  string
+		wantErr bool
+	}{
+		{
+			name:   "valid",
+			steps:  []EscalationStep{{Action: actionResetPeer, Attempts: 3}, {Action: actionRestartInterface, CooldownSeconds: 600}},
+			driver: driverCli,
+		},
+		{
+			name:    "unknown action",
+			steps:   []EscalationStep{{Action: "reboot"}},
+			driver:  driverCli,
+			wantErr: true,
+		},
+		{
+			name:    "negative attempts",
+			steps:   []EscalationStep{{Action: actionResetPeer, Attempts: -1}},
+			driver:  driverCli,
+			wantErr: true,
+		},
+		{
+			name:   "uapi readd",
+			steps:  []EscalationStep{{Action: actionReaddPeer}},
+			driver: driverUapi,
+		},
+		{
+			name:    "uapi restart",
+			steps:   []EscalationStep{{Action: actionRestartInterface}},
+			driver:  driverUapi,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateEscalation(tt.steps, tt.driver); (err != nil) != tt.wantErr {
+				t.Errorf("validateEscalation() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"slices"
+	"time"
+)
+
+const (
+	defaultFailbackSeconds = 3600
+
+	reasonFailback = "failback"
+)
+
+// peerFailover tracks which of the candidate endpoints of a peer is in use.
+type peerFailover struct {
+	endpoints []string
+	active    int
+	// healthySince is the time since the peer is healthy on a secondary endpoint, zero if it is stale or on the primary
+	healthySince time.Time
+}
+
+// next returns the candidate that is used by the next reset.
+func (f *peerFailover) next() string {
+	return f.endpoints[(f.active+1)%len(f.endpoints)]
+}
+
+// activate marks the endpoint as in use, it is ignored if it is not a candidate.
+func (f *peerFailover) activate(endpoint string) {
+	if idx := slices.Index(f.endpoints, endpoint); idx >= 0 {
+		f.active = idx
+		f.healthySince = time.Time{}
+	}
+}
+
+func (c PeerConfig) validateEndpoints() error {
+	if c.FailbackSeconds < 0 {
+		return fmt.Errorf("invalid failback seconds %d", c.FailbackSeconds)
+	}
+
+	for idx, endpoint := range c.Endpoints {
+		if _, _, err := net.SplitHostPort(endpoint); err != nil {
+			return fmt.Errorf("endpoint #%d: %w", idx, err)
+		}
+		if slices.Index(c.Endpoints, endpoint) != idx {
+			return fmt.Errorf("endpoint #%d: duplicate endpoint %q", idx, endpoint)
+		}
+	}
+	if len(c.Endpoints) == 0 && c.FailbackSeconds > 0 {
+		return errors.New("failback seconds set without endpoints")
+	}
+	return nil
+}
+
+func (c PeerConfig) failbackPeriod() time.Duration {
+	if c.FailbackSeconds > 0 {
+		return time.Duration(c.FailbackSeconds) * time.Second
+	}
+	return defaultFailbackSeconds * time.Second
+}
+
+// getFailover returns the failover state of the peer or nil if it has no candidate endpoints configured. The state
+// starts over on the primary if the candidates have been changed by a reload.
+func (t *Tunnelguard) getFailover(publicKey string) *peerFailover {
+	endpoints := t.peers[publicKey].Endpoints
+	if len(endpoints) == 0 {
+		delete(t.failover, publicKey)
+		return nil
+	}
+
+	failover, found := t.failover[publicKey]
+	if !found || !slices.Equal(failover.endpoints, endpoints) {
+		failover = &peerFailover{endpoints: endpoints}
+		t.failover[publicKey] = failover
+	}
+	return failover
+}
+
+// trackFailover exports the active endpoint of the peer and moves a peer that has been healthy on a secondary
+// endpoint for the failback period back to the primary. It returns the duration until the failback is due, zero if
+// none is pending.
+func (t *Tunnelguard) trackFailover(report *CycleReport, peer Peer, stale bool) time.Duration {
+	failover := t.getFailover(peer.PublicKey)
+	if failover == nil {
+		return 0
+	}
+	defer func() {
+		metrics.SetActiveEndpoint(t.iface, peer.PublicKey, t.niceNames[peer.PublicKey], failover.active)
+	}()
+
+	if stale || failover.active == 0 {
+		failover.healthySince = time.Time{}
+		return 0
+	}
+
+	if failover.healthySince.IsZero() {
+		failover.healthySince = time.Now()
+	}
+	if remaining := t.peers[peer.PublicKey].failbackPeriod() - time.Since(failover.healthySince); remaining > 0 {
+		return remaining
+	}
+
+	if t.failback(report, peer, failover.endpoints[0]) {
+		failover.activate(failover.endpoints[0])
+		return 0
+	}
+	return t.waitInterval
+}
+
+// failback resets the endpoint of a healthy peer to its primary endpoint and returns whether it succeeded.
+func (t *Tunnelguard) failback(report *CycleReport, peer Peer, endpoint string) bool {
+	if t.dryRun {
+		metrics.IncDryRunAction(dryRunKey{
+			Interface: t.iface,
+			Action:    actionResetPeer,
+			Reason:    reasonFailback,
+			PublicKey: peer.PublicKey,
+			NiceName:  t.niceNames[peer.PublicKey],
+		})
+		slog.Info("Dry-run: would fail back to primary endpoint", "interface", t.iface, "endpoint", endpoint, t.logPeer(peer.PublicKey))
+		return false
+	}
+
+	if !t.allowReset(peer.PublicKey, actionResetPeer, endpoint) {
+		return false
+	}
+
+	metrics.IncPeerResets(t.iface, peer.PublicKey, t.niceNames[peer.PublicKey])
+	metrics.SetLastReset(t.iface, peer.PublicKey, t.niceNames[peer.PublicKey], time.Now())
+	slog.Info("failing back to primary endpoint", "interface", t.iface, "endpoint", endpoint, t.logPeer(peer.PublicKey))
+	event := Event{
+		Type:      eventPeerReset,
+		Interface: t.iface,
+		PublicKey: peer.PublicKey,
+		NiceName:  t.niceNames[peer.PublicKey],
+		Endpoint:  endpoint,
+		Action:    actionResetPeer,
+		Reason:    reasonFailback,
+	}
+	if err := t.wg.ResetPeer(peer.PublicKey, endpoint); err != nil {
+		metrics.IncPeerResetFailures(t.iface, peer.PublicKey, t.niceNames[peer.PublicKey])
+		slog.Error("failed to fail back to primary endpoint", "interface", t.iface, "endpoint", endpoint, t.logPeer(peer.PublicKey), "error", err)
+		metrics.IncError(t.iface, reasonFailback)
+		report.addError(reasonFailback, err)
+		event.Type = eventPeerResetFailed
+		event.Error = err.Error()
+		t.emit(event)
+		return false
+	}
+	t.emit(event)
+	return true
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func activeEndpointMetric(iface string, publicKey string) int64 {
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+	return metrics.ActiveEndpoint[peerKey{Interface: iface, PublicKey: publicKey}].Value
+}
+
+func TestTunnelguard_failover(t *testing.T) {
+	driver := &fakeDriver{
+		peers:     []Peer{{PublicKey: "site", HandshakeLastSeen: handshakeAgo(time.Hour)}},
+		endpoints: map[string]string{"site": "192.0.2.1:51820"},
+	}
+	conf := InterfaceConfig{
+		Interface:               "wg-failover",
+		HandshakeTimeoutSeconds: 180,
+		WaitSeconds:             30,
+		Peers: map[string]PeerConfig{
+			"site": {Endpoints: []string{"primary.example:51820", "192.0.2.2:51820", "backup.example:51820"}, FailbackSeconds: 600},
+		},
+	}
+	tg, err := NewTunnelguard(driver, nil, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// every reset of the stale peer rotates to the next candidate, static candidates are not skipped
+	for range 4 {
+		delete(tg.backoff, "site")
+		tg.conditionallyResetPeers()
+	}
+	want := []string{"192.0.2.2:51820", "backup.example:51820", "primary.example:51820", "192.0.2.2:51820"}
+	if !reflect.DeepEqual(driver.resetEndpoints, want) {
+		t.Fatalf("reset endpoints = %v, want %v", driver.resetEndpoints, want)
+	}
+	if got := activeEndpointMetric("wg-failover", "site"); got != 1 {
+		t.Errorf("active endpoint metric = %d, want 1", got)
+	}
+
+	// the peer stays on the secondary until it has been healthy for the failback period
+	driver.peers[0].HandshakeLastSeen = handshakeAgo(time.Minute)
+	report := tg.conditionallyResetPeers()
+	if len(driver.resetEndpoints) != 4 {
+		t.Fatalf("unexpected reset before failback period: %v", driver.resetEndpoints)
+	}
+	if report.NextCheck > 600*time.Second {
+		t.Errorf("NextCheck = %v, want at most the failback period", report.NextCheck)
+	}
+
+	resets := metrics.GetPeerResets("wg-failover")["site"]
+	tg.failover["site"].healthySince = time.Now().Add(-11 * time.Minute)
+	tg.conditionallyResetPeers()
+	if got := driver.resetEndpoints[len(driver.resetEndpoints)-1]; len(driver.resetEndpoints) != 5 || got != "primary.example:51820" {
+		t.Fatalf("expected failback to primary, got %v", driver.resetEndpoints)
+	}
+	if got := activeEndpointMetric("wg-failover", "site"); got != 0 {
+		t.Errorf("active endpoint metric = %d, want 0", got)
+	}
+	// the failback is counted like any other reset
+	if got := metrics.GetPeerResets("wg-failover")["site"]; got != resets+1 {
+		t.Errorf("resets after failback = %d, want %d", got, resets+1)
+	}
+	if lastReset := metrics.GetLastResets("wg-failover")["site"]; time.Since(lastReset) > time.Minute {
+		t.Errorf("last reset = %v, want the failback", lastReset)
+	}
+
+	// a healthy peer on its primary is left alone
+	tg.conditionallyResetPeers()
+	if len(driver.resetEndpoints) != 5 {
+		t.Errorf("unexpected reset of healthy peer on primary: %v", driver.resetEndpoints)
+	}
+}
+
+func TestTunnelguard_failoverReload(t *testing.T) {
+	tg := &Tunnelguard{
+		peers:    map[string]PeerConfig{"site": {Endpoints: []string{"a.example:1", "b.example:2"}}},
+		failover: map[string]*peerFailover{},
+	}
+	tg.getFailover("site").activate("b.example:2")
+
+	tg.peers = map[string]PeerConfig{"site": {Endpoints: []string{"a.example:1", "c.example:3"}}}
+	if failover := tg.getFailover("site"); failover.active != 0 {
+		t.Errorf("expected changed candidates to start over on the primary, active = %d", failover.active)
+	}
+
+	tg.peers = map[string]PeerConfig{}
+	if tg.getFailover("site") != nil || len(tg.failover) != 0 {
+		t.Error("expected failover state to be dropped without candidates")
+	}
+}
+
+func TestPeerConfig_validateEndpoints(t *testing.T) {
+	tests := []struct {
+		name    string
+		conf    PeerConfig
+		wantErr bool
+	}{
+		{name: "no endpoints", conf: PeerConfig{}},
+		{name: "valid", conf: PeerConfig{Endpoints: []string{"site.example:51820", "[2001:db8::1]:51820"}, FailbackSeconds: 60}},
+		{name: "missing port", conf: PeerConfig{Endpoints: []string{"site.example"}}, wantErr: true},
+		{name: "duplicate", conf: PeerConfig{Endpoints: []string{"site.example:51820", "site.example:51820"}}, wantErr: true},
+		{name: "negative failback", conf: PeerConfig{Endpoints: []string{"site.example:51820"}, FailbackSeconds: -1}, wantErr: true},
+		{name: "failback without endpoints", conf: PeerConfig{FailbackSeconds: 60}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.conf.validateEndpoints(); (err != nil) != tt.wantErr {
+				t.Errorf("validateEndpoints() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+var startTime = time.Now()
+
+type LivenessStatus struct {
+	Alive               bool    `json:"alive"`
+	LastHeartbeat       string  `json:"last_heartbeat,omitempty"`
+	HeartbeatAgeSeconds float64 `json:"heartbeat_age_seconds"`
+	MaxAgeSeconds       float64 `json:"max_age_seconds"`
+	Reason              string  `json:"reason,omitempty"`
+}
+
+type ReadinessStatus struct {
+	Ready    bool   `json:"ready"`
+	TunnelUp bool   `json:"tunnel_up"`
+	Peers    int    `json:"peers"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// defaultMaxHeartbeatAge returns the duration after which the loop is considered hung. The loop sleeps at most
+// for the handshake timeout, so twice that duration leaves enough headroom for slow wg invocations.
+func (t *Tunnelguard) defaultMaxHeartbeatAge() time.Duration {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return 2 * (t.handshakeTimeout + t.waitInterval)
+}
+
+// Liveness reports whether the loop has run a cycle within the given duration. If maxAge is zero, a default
+// derived from the handshake timeout is used.
+func (t *Tunnelguard) Liveness(maxAge time.Duration) LivenessStatus {
+	if maxAge <= 0 {
+		maxAge = t.defaultMaxHeartbeatAge()
+	}
+
+	status := LivenessStatus{
+		MaxAgeSeconds: maxAge.Seconds(),
+	}
+
+	heartbeat, found := metrics.GetHeartbeat(t.iface)
+	lastSeen := startTime
+	if found {
+		lastSeen = time.Unix(heartbeat, 0)
+		status.LastHeartbeat = lastSeen.Format(time.RFC3339)
+	}
+
+	age := time.Since(lastSeen)
+	status.HeartbeatAgeSeconds = age.Truncate(time.Second).Seconds()
+	status.Alive = age <= maxAge
+	if !status.Alive {
+		if found {
+			status.Reason = fmt.Sprintf("no heartbeat within %v", maxAge)
+		} else {
+			status.Reason = fmt.Sprintf("no heartbeat since start %v ago", age.Truncate(time.Second))
+		}
+	}
+
+	return status
+}
+
+// Readiness queries WireGuard and reports whether the tunnel is up and the peers can be read.
+func (t *Tunnelguard) Readiness() ReadinessStatus {
+	status := ReadinessStatus{}
+
+	up, err := t.wg.IsTunnelUp()
+	if err != nil {
+		status.Reason = fmt.Sprintf("could not check tunnel: %v", err)
+		return status
+	}
+	status.TunnelUp = up
+	if !up {
+		status.Reason = "tunnel is down"
+		return status
+	}
+
+	peers, err := t.wg.GetPeers()
+	if err != nil {
+		status.Reason = fmt.Sprintf("could not get peers: %v", err)
+		return status
+	}
+	status.Peers = len(peers)
+	status.Ready = true
+
+	return status
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"text/template"
+	"time"
+)
+
+const (
+	httpReadTimeout     = 5 * time.Second
+	httpWriteTimeout    = 10 * time.Second
+	httpShutdownTimeout = 5 * time.Second
+)
+
+// HttpServer serves the metrics in the Prometheus exposition format as well as liveness and readiness probes.
+type HttpServer struct {
+	address         string
+	tmpl            *template.Template
+	tunnelguards    []*Tunnelguard
+	maxHeartbeatAge time.Duration
+}
+
+type healthResponse[T any] struct {
+	Status     string       `json:"status"`
+	Interfaces map[string]T `json:"interfaces"`
+}
+
+func NewHttpServer(address string, tunnelguards []*Tunnelguard, maxHeartbeatAge time.Duration) (*HttpServer, error) {
+	if len(address) == 0 {
+		return nil, errors.New("empty listen address provided")
+	}
+
+	tmpl, err := newMetricsTemplate()
+	if err != nil {
+		return nil, err
+	}
+
+	return &HttpServer{
+		address:         address,
+		tmpl:            tmpl,
+		tunnelguards:    tunnelguards,
+		maxHeartbeatAge: maxHeartbeatAge,
+	}, nil
+}
+
+func (s *HttpServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /metrics", s.serveMetrics)
+	mux.HandleFunc("GET /healthz", s.serveLiveness)
+	mux.HandleFunc("GET /readyz", s.serveReadiness)
+	mux.HandleFunc("GET /status", s.serveStatus)
+	return mux
+}
+
+func (s *HttpServer) serveLiveness(w http.ResponseWriter, _ *http.Request) {
+	resp := healthResponse[LivenessStatus]{
+		Status:     "ok",
+		Interfaces: make(map[string]LivenessStatus, len(s.tunnelguards)),
+	}
+
+	for _, tunnelguard := range s.tunnelguards {
+		status := tunnelguard.Liveness(s.maxHeartbeatAge)
+		if !status.Alive {
+			resp.Status = "failing"
+		}
+		resp.Interfaces[tunnelguard.iface] = status
+	}
+
+	writeHealthResponse(w, resp)
+}
+
+func (s *HttpServer) serveReadiness(w http.ResponseWriter, _ *http.Request) {
+	resp := healthResponse[ReadinessStatus]{
+		Status:     "ok",
+		Interfaces: make(map[string]ReadinessStatus, len(s.tunnelguards)),
+	}
+
+	for _, tunnelguard := range s.tunnelguards {
+		status := tunnelguard.Readiness()
+		if !status.Ready {
+			resp.Status = "failing"
+		}
+		resp.Interfaces[tunnelguard.iface] = status
+	}
+
+	writeHealthResponse(w, resp)
+}
+
+func (s *HttpServer) serveStatus(w http.ResponseWriter, _ *http.Request) {
+	data, err := json.Marshal(collectStatus(s.tunnelguards, statusSourceDaemon))
+	if err != nil {
+		http.Error(w, "could not marshal response", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(data)
+}
+
+func writeHealthResponse[T any](w http.ResponseWriter, resp healthResponse[T]) {
+	data, err := json.Marshal(resp)
+	if err != nil {
+		http.Error(w, "could not marshal response", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if resp.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_, _ = w.Write(data)
+}
+
+func (s *HttpServer) serveMetrics(w http.ResponseWriter, _ *http.Request) {
+	var buf bytes.Buffer
+	if err := metrics.Render(s.tmpl, &buf); err != nil {
+		slog.Error("could not render metrics", "err", err)
+		http.Error(w, "could not render metrics", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = w.Write(buf.Bytes())
+}
+
+// Listen serves requests until the context is cancelled.
+func (s *HttpServer) Listen(ctx context.Context, wg *sync.WaitGroup) error {
+	defer wg.Done()
+
+	server := &http.Server{
+		Addr:         s.address,
+		Handler:      s.handler(),
+		ReadTimeout:  httpReadTimeout,
+		WriteTimeout: httpWriteTimeout,
+	}
+
+	errChan := make(chan error, 1)
+	go func() {
+		slog.Info("Starting http server", "address", s.address)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			errChan <- err
+		}
+	}()
+
+	select {
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+		defer cancel()
+		return server.Shutdown(shutdownCtx)
+	case err := <-errChan:
+		return err
+	}
+}
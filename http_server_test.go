@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHttpServer_serveMetrics(t *testing.T) {
+	server, err := NewHttpServer(":0", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	metrics.IncPeerResets("wg-http", "pub_http", "http peer")
+
+	rec := httptest.NewRecorder()
+	server.handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("serveMetrics() status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	want := `tunnelguard_peers_resets_total{interface="wg-http",pub_key="pub_http",nice_name="http peer"} 1`
+	if !strings.Contains(rec.Body.String(), want) {
+		t.Errorf("serveMetrics() body does not contain %q:\n%s", want, rec.Body.String())
+	}
+
+	if contentType := rec.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/plain") {
+		t.Errorf("serveMetrics() content type = %q", contentType)
+	}
+}
+
+func TestHttpServer_health(t *testing.T) {
+	healthy := &fakeDriver{tunnelUp: true, peers: []Peer{{PublicKey: "a"}}}
+	broken := &fakeDriver{tunnelUp: true, peersErr: errors.New("wg not found")}
+	down := &fakeDriver{tunnelUp: false}
+
+	build := func(iface string, driver *fakeDriver) *Tunnelguard {
+		tg, err := NewTunnelguard(driver, nil, InterfaceConfig{Interface: iface, HandshakeTimeoutSeconds: 180, WaitSeconds: 30})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tg
+	}
+
+	metrics.SetHeartbeat("wg-health-ok", time.Now().Unix())
+	metrics.SetHeartbeat("wg-health-hung", time.Now().Add(-time.Hour).Unix())
+
+	tests := []struct {
+		name         string
+		path         string
+		tunnelguards []*Tunnelguard
+		wantStatus   int
+		wantReason   map[string]bool
+	}{
+		{
+			name:         "alive",
+			path:         "/healthz",
+			tunnelguards: []*Tunnelguard{build("wg-health-ok", healthy)},
+			wantStatus:   http.StatusOK,
+		},
+		{
+			name:         "hung loop",
+			path:         "/healthz",
+			tunnelguards: []*Tunnelguard{build("wg-health-ok", healthy), build("wg-health-hung", healthy)},
+			wantStatus:   http.StatusServiceUnavailable,
+			wantReason:   map[string]bool{"wg-health-hung": true},
+		},
+		{
+			name:         "ready",
+			path:         "/readyz",
+			tunnelguards: []*Tunnelguard{build("wg-health-ok", healthy)},
+			wantStatus:   http.StatusOK,
+		},
+		{
+			name:         "get peers fails",
+			path:         "/readyz",
+			tunnelguards: []*Tunnelguard{build("wg-health-ok", healthy), build("wg-health-broken", broken)},
+			wantStatus:   http.StatusServiceUnavailable,
+			wantReason:   map[string]bool{"wg-health-broken": true},
+		},
+		{
+			name:         "tunnel down",
+			path:         "/readyz",
+			tunnelguards: []*Tunnelguard{build("wg-health-down", down)},
+			wantStatus:   http.StatusServiceUnavailable,
+			wantReason:   map[string]bool{"wg-health-down": true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, err := NewHttpServer(":0", tt.tunnelguards, 10*time.Minute)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rec := httptest.NewRecorder()
+			server.handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != tt.wantStatus {
+				t.Errorf("%s status = %d, want %d", tt.path, rec.Code, tt.wantStatus)
+			}
+
+			var resp healthResponse[map[string]any]
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("could not parse response: %v", err)
+			}
+			for iface, status := range resp.Interfaces {
+				_, hasReason := status["reason"]
+				if hasReason != tt.wantReason[iface] {
+					t.Errorf("%s interface %s reason = %v, want %v", tt.path, iface, status["reason"], tt.wantReason[iface])
+				}
+			}
+		})
+	}
+}
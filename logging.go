@@ -0,0 +1,383 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"log/syslog"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+const (
+	logFormatText = "text"
+	logFormatJson = "json"
+
+	logDestinationStdout   = "stdout"
+	logDestinationStderr   = "stderr"
+	logDestinationFile     = "file"
+	logDestinationSyslog   = "syslog"
+	logDestinationJournald = "journald"
+
+	logIdentifier  = "tunnelguard"
+	journaldSocket = "/run/systemd/journal/socket"
+)
+
+// LoggingConfig configures the format, level and destination of the logs.
+type LoggingConfig struct {
+	// Format is either text or json, it is ignored for journald which receives structured fields.
+	Format string `json:"format"`
+	// Level is one of debug, info, warn or error.
+	Level string `json:"level"`
+	// Destination is one of stdout, stderr, file, syslog or journald.
+	Destination string `json:"destination"`
+	// File is the path of the log file if the destination is file. It is reopened on SIGUSR1.
+	File string `json:"file"`
+}
+
+func (c *LoggingConfig) validate() error {
+	if c.Format != logFormatText && c.Format != logFormatJson {
+		return fmt.Errorf("unknown format %q", c.Format)
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Level)); err != nil {
+		return fmt.Errorf("unknown level %q", c.Level)
+	}
+
+	switch c.Destination {
+	case logDestinationStdout, logDestinationStderr, logDestinationSyslog, logDestinationJournald:
+	case logDestinationFile:
+		if len(c.File) == 0 {
+			return errors.New("no file provided for destination file")
+		}
+	default:
+		return fmt.Errorf("unknown destination %q", c.Destination)
+	}
+	return nil
+}
+
+// peerLogAttrs returns the fields that identify a peer in log lines.
+func peerLogAttrs(publicKey string, niceName string) slog.Attr {
+	return slog.Group("", slog.String("pub_key", publicKey), slog.String("nice_name", niceName))
+}
+
+// logPeer returns the fields that identify the peer in log lines.
+func (t *Tunnelguard) logPeer(publicKey string) slog.Attr {
+	return peerLogAttrs(publicKey, t.niceNames[publicKey])
+}
+
+func setupLogger(verbose bool, output io.Writer) {
+	level := slog.LevelInfo
+	if verbose {
+		level = slog.LevelDebug
+	}
+
+	logHandler := slog.NewTextHandler(output, &slog.HandlerOptions{
+		Level: level,
+	})
+
+	logger := slog.New(logHandler)
+	slog.SetDefault(logger)
+}
+
+// setupLogging replaces the default logger according to the config. Logs for the stdout destination are written to
+// the given writer. The returned file is not nil if the logs are written to a file that needs to be reopened after
+// it has been rotated.
+func setupLogging(conf LoggingConfig, verbose bool, stdout io.Writer) (*ReopenableFile, error) {
+	if err := conf.validate(); err != nil {
+		return nil, err
+	}
+
+	var level slog.Level
+	_ = level.UnmarshalText([]byte(conf.Level))
+	if verbose {
+		level = slog.LevelDebug
+	}
+	opts := &slog.HandlerOptions{Level: level}
+
+	var handler slog.Handler
+	var file *ReopenableFile
+	switch conf.Destination {
+	case logDestinationStdout:
+		handler = newFormatHandler(conf.Format, stdout, opts)
+	case logDestinationStderr:
+		handler = newFormatHandler(conf.Format, os.Stderr, opts)
+	case logDestinationFile:
+		var err error
+		file, err = OpenReopenableFile(conf.File)
+		if err != nil {
+			return nil, err
+		}
+		handler = newFormatHandler(conf.Format, file, opts)
+	case logDestinationSyslog:
+		writer, err := syslog.New(syslog.LOG_DAEMON|syslog.LOG_INFO, logIdentifier)
+		if err != nil {
+			return nil, fmt.Errorf("could not connect to syslog: %w", err)
+		}
+		handler = newSyslogHandler(conf.Format, writer, opts)
+	case logDestinationJournald:
+		var err error
+		handler, err = newJournaldHandler(journaldSocket, opts)
+		if err != nil {
+			return nil, fmt.Errorf("could not connect to journald: %w", err)
+		}
+	}
+
+	slog.SetDefault(slog.New(handler))
+	return file, nil
+}
+
+func newFormatHandler(format string, output io.Writer, opts *slog.HandlerOptions) slog.Handler {
+	if format == logFormatJson {
+		return slog.NewJSONHandler(output, opts)
+	}
+	return slog.NewTextHandler(output, opts)
+}
+
+// ReopenableFile is a log file that can be reopened after it has been moved by logrotate.
+type ReopenableFile struct {
+	mutex sync.Mutex
+	path  string
+	file  *os.File
+}
+
+func OpenReopenableFile(path string) (*ReopenableFile, error) {
+	file, err := openLogFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return &ReopenableFile{path: path, file: file}, nil
+}
+
+func openLogFile(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+}
+
+func (f *ReopenableFile) Write(data []byte) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.file.Write(data)
+}
+
+// Reopen opens the file at its path again, the current file is kept if that fails.
+func (f *ReopenableFile) Reopen() error {
+	file, err := openLogFile(f.path)
+	if err != nil {
+		return err
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	old := f.file
+	f.file = file
+	return old.Close()
+}
+
+// reopenLogFileOnSignal reopens the log file whenever SIGUSR1 is received.
+func reopenLogFileOnSignal(file *ReopenableFile) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGUSR1)
+
+	for range sig {
+		if err := file.Reopen(); err != nil {
+			slog.Error("could not reopen log file", "file", file.path, "err", err)
+			continue
+		}
+		slog.Info("reopened log file", "file", file.path)
+	}
+}
+
+// syslogHandler formats records using the text or json handler and passes them to syslog with the priority matching
+// their level. The time is omitted as syslog adds its own.
+type syslogHandler struct {
+	handler slog.Handler
+	writer  *syslog.Writer
+	// buf receives the formatted record of the handler, it is shared with all derived handlers and guarded by mutex
+	buf   *bytes.Buffer
+	mutex *sync.Mutex
+}
+
+func newSyslogHandler(format string, writer *syslog.Writer, opts *slog.HandlerOptions) *syslogHandler {
+	buf := &bytes.Buffer{}
+	formatOpts := *opts
+	formatOpts.ReplaceAttr = func(groups []string, attr slog.Attr) slog.Attr {
+		if len(groups) == 0 && attr.Key == slog.TimeKey {
+			return slog.Attr{}
+		}
+		return attr
+	}
+
+	return &syslogHandler{
+		handler: newFormatHandler(format, buf, &formatOpts),
+		writer:  writer,
+		buf:     buf,
+		mutex:   &sync.Mutex{},
+	}
+}
+
+func (h *syslogHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.handler.Enabled(ctx, level)
+}
+
+func (h *syslogHandler) Handle(ctx context.Context, record slog.Record) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.buf.Reset()
+	if err := h.handler.Handle(ctx, record); err != nil {
+		return err
+	}
+	line := strings.TrimSuffix(h.buf.String(), "\n")
+
+	switch {
+	case record.Level >= slog.LevelError:
+		return h.writer.Err(line)
+	case record.Level >= slog.LevelWarn:
+		return h.writer.Warning(line)
+	case record.Level >= slog.LevelInfo:
+		return h.writer.Info(line)
+	default:
+		return h.writer.Debug(line)
+	}
+}
+
+func (h *syslogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &syslogHandler{handler: h.handler.WithAttrs(attrs), writer: h.writer, buf: h.buf, mutex: h.mutex}
+}
+
+func (h *syslogHandler) WithGroup(name string) slog.Handler {
+	return &syslogHandler{handler: h.handler.WithGroup(name), writer: h.writer, buf: h.buf, mutex: h.mutex}
+}
+
+// journaldHandler sends records to journald using its native protocol, attributes are passed as fields whose names
+// are the upper-cased keys, e.g. PUB_KEY and NICE_NAME. Records that exceed the maximum datagram size are dropped.
+type journaldHandler struct {
+	conn  net.Conn
+	level slog.Leveler
+	// attrs holds the fields of WithAttrs, already encoded
+	attrs  []byte
+	prefix string
+}
+
+func newJournaldHandler(socket string, opts *slog.HandlerOptions) (*journaldHandler, error) {
+	conn, err := net.Dial("unixgram", socket)
+	if err != nil {
+		return nil, err
+	}
+
+	level := opts.Level
+	if level == nil {
+		level = slog.LevelInfo
+	}
+	return &journaldHandler{conn: conn, level: level}, nil
+}
+
+func (h *journaldHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *journaldHandler) Handle(_ context.Context, record slog.Record) error {
+	var buf bytes.Buffer
+	writeJournaldField(&buf, "MESSAGE", record.Message)
+	writeJournaldField(&buf, "PRIORITY", journaldPriority(record.Level))
+	writeJournaldField(&buf, "SYSLOG_IDENTIFIER", logIdentifier)
+	buf.Write(h.attrs)
+	record.Attrs(func(attr slog.Attr) bool {
+		appendJournaldAttr(&buf, h.prefix, attr)
+		return true
+	})
+
+	_, err := h.conn.Write(buf.Bytes())
+	return err
+}
+
+func (h *journaldHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	var buf bytes.Buffer
+	buf.Write(h.attrs)
+	for _, attr := range attrs {
+		appendJournaldAttr(&buf, h.prefix, attr)
+	}
+	return &journaldHandler{conn: h.conn, level: h.level, attrs: buf.Bytes(), prefix: h.prefix}
+}
+
+func (h *journaldHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return &journaldHandler{conn: h.conn, level: h.level, attrs: h.attrs, prefix: h.prefix + name + "_"}
+}
+
+func appendJournaldAttr(buf *bytes.Buffer, prefix string, attr slog.Attr) {
+	attr.Value = attr.Value.Resolve()
+	if attr.Value.Kind() == slog.KindGroup {
+		if attr.Key != "" {
+			prefix += attr.Key + "_"
+		}
+		for _, member := range attr.Value.Group() {
+			appendJournaldAttr(buf, prefix, member)
+		}
+		return
+	}
+	if attr.Equal(slog.Attr{}) {
+		return
+	}
+	writeJournaldField(buf, journaldFieldName(prefix+attr.Key), attr.Value.String())
+}
+
+// journaldFieldName converts the key to a valid field name that consists of upper case letters, digits and
+// underscores and does not start with an underscore or digit.
+func journaldFieldName(key string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		default:
+			return '_'
+		}
+	}, key)
+	name = strings.TrimLeft(name, "_0123456789")
+	if name == "" {
+		return "FIELD"
+	}
+	return name
+}
+
+// writeJournaldField encodes the field, values containing a newline use the binary format.
+func writeJournaldField(buf *bytes.Buffer, name string, value string) {
+	buf.WriteString(name)
+	if !strings.Contains(value, "\n") {
+		buf.WriteByte('=')
+		buf.WriteString(value)
+		buf.WriteByte('\n')
+		return
+	}
+
+	buf.WriteByte('\n')
+	_ = binary.Write(buf, binary.LittleEndian, uint64(len(value)))
+	buf.WriteString(value)
+	buf.WriteByte('\n')
+}
+
+func journaldPriority(level slog.Level) string {
+	switch {
+	case level >= slog.LevelError:
+		return "3"
+	case level >= slog.LevelWarn:
+		return "4"
+	case level >= slog.LevelInfo:
+		return "6"
+	default:
+		return "7"
+	}
+}
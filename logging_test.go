@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"log/slog"
+	"log/syslog"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoggingConfig_validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		conf    LoggingConfig
+		wantErr bool
+	}{
+		{name: "default", conf: getDefault().Logging},
+		{name: "json to file", conf: LoggingConfig{Format: logFormatJson, Level: "debug", Destination: logDestinationFile, File: "/var/log/tunnelguard.log"}},
+		{name: "journald", conf: LoggingConfig{Format: logFormatText, Level: "WARN", Destination: logDestinationJournald}},
+		{name: "unknown format", conf: LoggingConfig{Format: "logfmt", Level: "info", Destination: logDestinationStdout}, wantErr: true},
+		{name: "unknown level", conf: LoggingConfig{Format: logFormatText, Level: "verbose", Destination: logDestinationStdout}, wantErr: true},
+		{name: "unknown destination", conf: LoggingConfig{Format: logFormatText, Level: "info", Destination: "kafka"}, wantErr: true},
+		{name: "file without path", conf: LoggingConfig{Format: logFormatText, Level: "info", Destination: logDestinationFile}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.conf.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSetupLogging(t *testing.T) {
+	defer slog.SetDefault(slog.Default())
+
+	var buf bytes.Buffer
+	conf := LoggingConfig{Format: logFormatJson, Level: "warn", Destination: logDestinationStdout}
+	if _, err := setupLogging(conf, false, &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	slog.Info("dropped")
+	slog.Warn("peer is stale", "interface", "wg0", peerLogAttrs("pub", "nice"))
+
+	var line map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("expected a single json line, got %q: %v", buf.String(), err)
+	}
+	if line["msg"] != "peer is stale" || line["pub_key"] != "pub" || line["nice_name"] != "nice" {
+		t.Errorf("unexpected log line %v", line)
+	}
+
+	// debug overrides the configured level
+	buf.Reset()
+	if _, err := setupLogging(conf, true, &buf); err != nil {
+		t.Fatal(err)
+	}
+	slog.Debug("visible")
+	if !strings.Contains(buf.String(), "visible") {
+		t.Errorf("expected debug log, got %q", buf.String())
+	}
+}
+
+func TestReopenableFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tunnelguard.log")
+	file, err := OpenReopenableFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := file.Write([]byte("before\n")); err != nil {
+		t.Fatal(err)
+	}
+	rotated := filepath.Join(dir, "tunnelguard.log.1")
+	if err := os.Rename(path, rotated); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.Write([]byte("after\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	for file, want := range map[string]string{rotated: "before\n", path: "after\n"} {
+		got, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("%s: got %q, want %q", file, got, want)
+		}
+	}
+}
+
+// listenUnixgram returns a socket that receives datagrams in a temporary directory.
+func listenUnixgram(t *testing.T) (*net.UnixConn, string) {
+	// keep the path short, unix socket paths are limited to about 100 bytes
+	dir, err := os.MkdirTemp("", "tg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	socket := filepath.Join(dir, "sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn, socket
+}
+
+func readDatagram(t *testing.T, conn *net.UnixConn) string {
+	buf := make([]byte, 65536)
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(buf[:n])
+}
+
+func TestJournaldHandler(t *testing.T) {
+	conn, socket := listenUnixgram(t)
+	handler, err := newJournaldHandler(socket, &slog.HandlerOptions{Level: slog.LevelInfo})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger := slog.New(handler).With("interface", "wg0")
+	logger.Debug("dropped")
+	logger.WithGroup("probe").Warn("probe failed", peerLogAttrs("pub", "nice"), "error", "line1\nline2")
+
+	var multiline bytes.Buffer
+	multiline.WriteString("PROBE_ERROR\n")
+	_ = binary.Write(&multiline, binary.LittleEndian, uint64(len("line1\nline2")))
+	multiline.WriteString("line1\nline2\n")
+
+	want := "MESSAGE=probe failed\nPRIORITY=4\nSYSLOG_IDENTIFIER=tunnelguard\nINTERFACE=wg0\nPROBE_PUB_KEY=pub\nPROBE_NICE_NAME=nice\n" + multiline.String()
+	if got := readDatagram(t, conn); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestSyslogHandler(t *testing.T) {
+	conn, socket := listenUnixgram(t)
+	writer, err := syslog.Dial("unixgram", socket, syslog.LOG_DAEMON|syslog.LOG_INFO, logIdentifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger := slog.New(newSyslogHandler(logFormatText, writer, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	logger.Error("failed to reset peer", "interface", "wg0", peerLogAttrs("pub", "nice"))
+
+	got := readDatagram(t, conn)
+	// daemon facility (3) and error severity (3)
+	if !strings.HasPrefix(got, "<27>") {
+		t.Errorf("unexpected priority in %q", got)
+	}
+	want := `tunnelguard[`
+	if !strings.Contains(got, want) || !strings.HasSuffix(strings.TrimSpace(got), `level=ERROR msg="failed to reset peer" interface=wg0 pub_key=pub nice_name=nice`) {
+		t.Errorf("unexpected message %q", got)
+	}
+}
+
+func Test_journaldFieldName(t *testing.T) {
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{key: "pub_key", want: "PUB_KEY"},
+		{key: "nice-name", want: "NICE_NAME"},
+		{key: "_private", want: "PRIVATE"},
+		{key: "1st", want: "ST"},
+		{key: "__", want: "FIELD"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			if got := journaldFieldName(tt.key); got != tt.want {
+				t.Errorf("journaldFieldName() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
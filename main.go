@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
@@ -11,12 +13,17 @@ import (
 	"path/filepath"
 	"sync"
 	"syscall"
+	"time"
 )
 
 var (
 	flagPrintVersion bool
 	flagConfigFile   string
 	flagDebug        bool
+	flagDryRun       bool
+	flagOnce         bool
+	flagJson         bool
+	flagValidate     bool
 
 	BuildVersion string
 	CommitHash   string
@@ -24,6 +31,10 @@ var (
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "status" {
+		os.Exit(runStatus(os.Args[2:]))
+	}
+
 	parseFlags()
 
 	if flagPrintVersion {
@@ -31,17 +42,39 @@ func main() {
 		os.Exit(0)
 	}
 
-	setupLogger(flagDebug)
-	slog.Info("Starting tunnelguard", "version", BuildVersion, "go", GoVersion)
+	if flagValidate {
+		if err := validateConfig(flagConfigFile, discoverWireguardInterfaces); err != nil {
+			fmt.Fprintf(os.Stderr, "invalid config:\n%v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println("config is valid")
+		os.Exit(0)
+	}
+
+	// keep stdout free for the json summary
+	logOutput := os.Stdout
+	if flagOnce && flagJson {
+		logOutput = os.Stderr
+	}
+	setupLogger(flagDebug, logOutput)
 
 	config, err := readConfig(flagConfigFile)
 	if err != nil {
 		log.Fatal("could not read config: ", err)
 	}
 
-	wgDriver, err := NewWgCli(config.Interface, config.ConfigFile)
+	logFile, err := setupLogging(config.Logging, flagDebug, logOutput)
+	if err != nil {
+		log.Fatal("could not set up logging: ", err)
+	}
+	if logFile != nil {
+		go reopenLogFileOnSignal(logFile)
+	}
+	slog.Info("Starting tunnelguard", "version", BuildVersion, "go", GoVersion)
+
+	interfaces, err := config.GetInterfaces(discoverWireguardInterfaces)
 	if err != nil {
-		slog.Error("could not build wg driver", "err", err)
+		slog.Error("could not determine interfaces", "err", err)
 		os.Exit(1)
 	}
 
@@ -51,65 +84,259 @@ func main() {
 		os.Exit(1)
 	}
 
-	tunnelguard := Tunnelguard{
-		wg:            wgDriver,
-		metricsWriter: metricsWriter,
-		niceNames:     config.PublicKeyDict,
+	if flagDryRun {
+		config.DryRun = true
+	}
+
+	opts := []TunnelguardOpt{WithTransitionHandler(LogTransition)}
+	if config.DryRun {
+		slog.Warn("Running in dry-run mode, interfaces will not be touched")
+		opts = append(opts, WithDryRun())
+	}
+	if config.ResetOnlyOnAddressChange {
+		resolver, err := NewResolver(config.Resolver)
+		if err != nil {
+			slog.Error("could not build resolver", "err", err)
+			os.Exit(1)
+		}
+		opts = append(opts, WithResolver(resolver))
+	}
+
+	var dispatcher *NotificationDispatcher
+	if config.Notifications.IsEnabled() {
+		notifiers, err := BuildNotifiers(config.Notifications)
+		if err != nil {
+			slog.Error("could not build notifiers", "err", err)
+			os.Exit(1)
+		}
+		dispatcher, err = NewNotificationDispatcher(config.Notifications, notifiers...)
+		if err != nil {
+			slog.Error("could not build notification dispatcher", "err", err)
+			os.Exit(1)
+		}
+		opts = append(opts, WithEventHandler(dispatcher.Dispatch))
+	}
+
+	if config.MaxResetsPerMinute > 0 {
+		opts = append(opts, WithResetLimiter(NewResetLimiter(config.MaxResetsPerMinute)))
+	}
+
+	if config.StateFile != "" {
+		store, err := NewStateStore(config.StateFile)
+		if err != nil {
+			slog.Error("could not build state store", "err", err)
+			os.Exit(1)
+		}
+		opts = append(opts, WithStateStore(store))
+	}
+
+	var notifier *SystemdNotifier
+	if !flagOnce {
+		notifier, err = NewSystemdNotifier()
+		if err != nil {
+			slog.Warn("could not set up systemd notifications", "err", err)
+		}
+	}
+	if notifier != nil {
+		var names []string
+		for _, iface := range interfaces {
+			names = append(names, iface.Interface)
+		}
+		supervisor := NewSystemdSupervisor(notifier, names)
+		opts = append(opts, WithCycleHandler(supervisor.CycleCompleted))
+		if watchdog := notifier.WatchdogInterval(); watchdog > 0 {
+			slog.Info("systemd watchdog enabled", "timeout", watchdog)
+			// leave headroom for slow cycles
+			opts = append(opts, WithMaxCycleInterval(watchdog/2))
+		}
+	}
+
+	var tunnelguards []*Tunnelguard
+	for _, iface := range interfaces {
+		logWireguardConfigProblems(iface)
+		if err := validateProbeTargets(iface.Peers, iface.ConfigFile); err != nil {
+			slog.Error("invalid probes", "interface", iface.Interface, "err", err)
+			os.Exit(1)
+		}
+		wgDriver, err := buildWireguardDriver(iface)
+		if err != nil {
+			slog.Error("could not build wg driver", "interface", iface.Interface, "err", err)
+			os.Exit(1)
+		}
+
+		tunnelguard, err := NewTunnelguard(wgDriver, metricsWriter, iface, opts...)
+		if err != nil {
+			slog.Error("could not build tunnelguard", "interface", iface.Interface, "err", err)
+			os.Exit(1)
+		}
+		tunnelguards = append(tunnelguards, tunnelguard)
+	}
+
+	if flagOnce {
+		os.Exit(runOnce(tunnelguards, metricsWriter, dispatcher, flagJson))
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	wait := &sync.WaitGroup{}
-	wait.Add(1)
 	go func() {
 		sig := make(chan os.Signal, 1)
-		signal.Notify(sig, syscall.SIGINT)
+		signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
 
-		<-sig
-		slog.Info("Got signal, quitting")
+		received := <-sig
+		slog.Info("Got signal, shutting down", "signal", received)
 		cancel()
 	}()
 
-	tunnelguard.Loop(ctx, wait)
-	wait.Wait()
+	reloader := NewReloader(flagConfigFile, tunnelguards, discoverWireguardInterfaces)
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGHUP)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-sig:
+				slog.Info("Got SIGHUP, reloading config")
+				reloader.Reload()
+			}
+		}
+	}()
+	if config.ConfigWatchIntervalSeconds > 0 {
+		go reloader.Watch(ctx, time.Duration(config.ConfigWatchIntervalSeconds)*time.Second)
+	}
+
+	if len(config.ListenAddress) > 0 {
+		maxHeartbeatAge := time.Duration(config.HealthMaxHeartbeatAgeSeconds) * time.Second
+		httpServer, err := NewHttpServer(config.ListenAddress, tunnelguards, maxHeartbeatAge)
+		if err != nil {
+			slog.Error("could not build http server", "err", err)
+			os.Exit(1)
+		}
+
+		wait.Add(1)
+		go func() {
+			if err := httpServer.Listen(ctx, wait); err != nil {
+				slog.Error("http server failed", "err", err)
+				cancel()
+			}
+		}()
+	}
+
+	if metricsWriter != nil {
+		metricsWriter.Run(ctx)
+	}
+
+	for _, tunnelguard := range tunnelguards {
+		slog.Info("Supervising interface", "interface", tunnelguard.iface)
+		wait.Add(1)
+		go tunnelguard.Loop(ctx, wait)
+	}
+
+	<-ctx.Done()
+	if notifier != nil {
+		_ = notifier.Notify(sdStopping)
+	}
+	shutdown(wait, time.Duration(config.ShutdownTimeoutSeconds)*time.Second, metricsWriter, dispatcher)
 }
 
-func setupLogger(verbose bool) {
-	level := slog.LevelInfo
-	if verbose {
-		level = slog.LevelDebug
+// runOnce runs a single pass for all interfaces and returns the exit code describing the outcome.
+func runOnce(tunnelguards []*Tunnelguard, metricsWriter *MetricsWriter, dispatcher *NotificationDispatcher, printJson bool) int {
+	var reports []*CycleReport
+	for _, tunnelguard := range tunnelguards {
+		reports = append(reports, tunnelguard.RunOnce())
+	}
+	flushNotifications(dispatcher, defaultShutdownTimeoutSeconds*time.Second)
+
+	exitCode := combinedExitCode(reports)
+	if metricsWriter != nil {
+		if err := metricsWriter.Flush(); err != nil {
+			slog.Error("can not write metrics data", "err", err)
+			exitCode = exitCodeErrors
+		}
 	}
 
-	logHandler := slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
-		Level: level,
-	})
+	if printJson {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(reports); err != nil {
+			slog.Error("could not print summary", "err", err)
+			exitCode = exitCodeErrors
+		}
+	}
 
-	logger := slog.New(logHandler)
-	slog.SetDefault(logger)
+	return exitCode
 }
 
 func parseFlags() {
 	flag.StringVar(&flagConfigFile, "config", "", "Path of config file")
 	flag.BoolVar(&flagPrintVersion, "version", false, "Print version and exit")
 	flag.BoolVar(&flagDebug, "debug", false, "Print debug logs")
+	flag.BoolVar(&flagDryRun, "dry-run", false, "Only log and count the actions that would be taken")
+	flag.BoolVar(&flagOnce, "once", false, "Run a single pass, write metrics and exit")
+	flag.BoolVar(&flagJson, "json", false, "Print a JSON summary of each peer's decision to stdout, only used with -once")
+	flag.BoolVar(&flagValidate, "validate-config", false, "Validate the config and the referenced WireGuard configs and exit")
 	flag.Parse()
 }
 
+func buildWireguardDriver(iface InterfaceConfig) (WireguardDriver, error) {
+	if iface.Driver == driverUapi {
+		return NewWgUapi(iface.Interface, iface.ConfigFile, iface.UapiSocket)
+	}
+	return NewWgCli(iface.Interface, iface.ConfigFile)
+}
+
+// logWireguardConfigProblems warns about all problems found in the interface's wg-quick config file.
+func logWireguardConfigProblems(iface InterfaceConfig) {
+	_, problems, err := parseWgQuickConfig(iface.ConfigFile)
+	if err != nil {
+		slog.Warn("could not parse wireguard config", "interface", iface.Interface, "file", iface.ConfigFile, "err", err)
+		return
+	}
+
+	for _, problem := range problems {
+		slog.Warn("problem in wireguard config", "interface", iface.Interface, "file", iface.ConfigFile, "line", problem.Line, "problem", problem.Message)
+	}
+}
+
 func buildMetricsWriter(config *TunnelguardConfig) (*MetricsWriter, error) {
-	if config.MetricsFile == "" {
+	metricsFile := config.MetricsFile
+	if metricsFile != "" {
+		basePath := filepath.Dir(metricsFile)
+		_, err := os.Stat(basePath)
+
+		if err != nil && os.IsNotExist(err) {
+			isUsingDefaultValue := metricsFile == defaultMetricsFile
+			if isUsingDefaultValue {
+				slog.Warn("Disabling metrics writer, path does not exist", "path", basePath)
+				metricsFile = ""
+			} else {
+				return nil, fmt.Errorf("base path for writing metrics does not exist: %w", err)
+			}
+		}
+	}
+
+	if metricsFile == "" && len(config.MetricsSinks) == 0 {
 		return nil, nil
 	}
 
-	basePath := filepath.Dir(config.MetricsFile)
-	_, err := os.Stat(basePath)
+	writer, err := NewMetricsWriter(metricsFile)
+	if err != nil {
+		return nil, err
+	}
 
-	if err != nil && os.IsNotExist(err) {
-		isUsingDefaultValue := config.MetricsFile == defaultMetricsFile
-		if isUsingDefaultValue {
-			slog.Warn("Disabling metrics writer, path does not exist", "path", basePath)
-		} else {
-			return nil, fmt.Errorf("base path for writing metrics does not exist: %w", err)
+	var errs error
+	for _, conf := range config.MetricsSinks {
+		sink, err := BuildMetricsSink(conf)
+		if err != nil {
+			errs = errors.Join(errs, err)
+			continue
 		}
+		writer.AddSink(conf.name(), sink, conf.interval())
+	}
+	if errs != nil {
+		return nil, errs
 	}
 
-	return NewMetricsWriter(config.MetricsFile)
+	return writer, nil
 }
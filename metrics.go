@@ -1,38 +1,266 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"text/template"
 	"time"
 )
 
 const templateData = `# HELP tunnelguard_version version information for the running binary
 # TYPE tunnelguard_version gauge
-tunnelguard_version{app="{{ index .Version "app" }}",go="{{ index .Version "go" }}"} 1
+tunnelguard_version{app="{{ index .Version "app" | label }}",go="{{ index .Version "go" | label }}"} 1
+{{- if gt (len .Heartbeat) 0 }}
 # HELP tunnelguard_heartbeat_timestamp_seconds the timestamp of the invocation
 # TYPE tunnelguard_heartbeat_timestamp_seconds gauge
-tunnelguard_heartbeat_timestamp_seconds {{ .Heartbeat }}
+{{- range $key, $value := .Heartbeat }}
+tunnelguard_heartbeat_timestamp_seconds{interface="{{ $key | label }}"} {{ $value }}
+{{- end }}
+{{- end }}
+{{- if gt .ShutdownTimestamp 0 }}
+# HELP tunnelguard_shutdown_timestamp_seconds the timestamp of the shutdown
+# TYPE tunnelguard_shutdown_timestamp_seconds gauge
+tunnelguard_shutdown_timestamp_seconds {{ .ShutdownTimestamp }}
+# HELP tunnelguard_shutdown_clean whether all loops finished before the shutdown timeout
+# TYPE tunnelguard_shutdown_clean gauge
+tunnelguard_shutdown_clean {{ .ShutdownClean }}
+{{- end }}
+{{- if gt .LastStatusChange 0 }}
+# HELP tunnelguard_last_status_change_timestamp_seconds the timestamp of the most recent state change of any peer
+# TYPE tunnelguard_last_status_change_timestamp_seconds gauge
+tunnelguard_last_status_change_timestamp_seconds {{ .LastStatusChange }}
+{{- end }}
+{{- if gt (len .ConfigReloads) 0 }}
+# HELP tunnelguard_config_reloads_total Number of config reloads by result.
+# TYPE tunnelguard_config_reloads_total counter
+{{- range $key, $value := .ConfigReloads }}
+tunnelguard_config_reloads_total{result="{{ $key | label }}"} {{ $value }}
+{{- end }}
+# HELP tunnelguard_config_last_reload_successful whether the most recent config reload succeeded
+# TYPE tunnelguard_config_last_reload_successful gauge
+tunnelguard_config_last_reload_successful {{ .ConfigLastReloadSuccessful }}
+# HELP tunnelguard_config_last_reload_timestamp_seconds the timestamp of the most recent config reload
+# TYPE tunnelguard_config_last_reload_timestamp_seconds gauge
+tunnelguard_config_last_reload_timestamp_seconds {{ .ConfigLastReload }}
+{{- if gt .ConfigLastReloadSuccess 0 }}
+# HELP tunnelguard_config_last_reload_success_timestamp_seconds the timestamp of the most recent successful config reload
+# TYPE tunnelguard_config_last_reload_success_timestamp_seconds gauge
+tunnelguard_config_last_reload_success_timestamp_seconds {{ .ConfigLastReloadSuccess }}
+{{- end }}
+{{- end }}
+{{- if gt (len .TunnelUp) 0 }}
+# HELP tunnelguard_tunnel_up whether the interface is up
+# TYPE tunnelguard_tunnel_up gauge
+{{- range $key, $value := .TunnelUp }}
+tunnelguard_tunnel_up{interface="{{ $key | label }}"} {{ $value }}
+{{- end }}
+{{- end }}
+{{- if gt (len .PeerCount) 0 }}
+# HELP tunnelguard_peers the number of peers of the interface
+# TYPE tunnelguard_peers gauge
+{{- range $key, $value := .PeerCount }}
+tunnelguard_peers{interface="{{ $key | label }}"} {{ $value }}
+{{- end }}
+{{- end }}
+{{- if gt (len .GetPeersDuration) 0 }}
+# HELP tunnelguard_get_peers_duration_seconds the duration of the most recent query of the peers
+# TYPE tunnelguard_get_peers_duration_seconds gauge
+{{- range $key, $value := .GetPeersDuration }}
+tunnelguard_get_peers_duration_seconds{interface="{{ $key | label }}"} {{ $value }}
+{{- end }}
+{{- end }}
 {{- if gt (len .ErrorsTotal) 0 }}
 # HELP tunnelguard_errors_total Number of errors.
 # TYPE tunnelguard_errors_total counter
 {{- range $key, $value := .ErrorsTotal }}
-tunnelguard_errors_total{error="{{ $key }}"} {{ $value }}
+tunnelguard_errors_total{interface="{{ $key.Interface | label }}",error="{{ $key.Error | label }}"} {{ $value }}
 {{- end }}
 {{- end }}
 {{- if gt (len .PeerResets) 0 }}
-# HELP tunnelguard_resets_total Number of SSH restart errors encountered.
-# TYPE tunnelguard_resets_total counter
+# HELP tunnelguard_peers_resets_total Number of resets of a peer.
+# TYPE tunnelguard_peers_resets_total counter
 {{- range $key, $value := .PeerResets }}
-tunnelguard_peers_resets_total{pub_key="{{ $key }}",nice_name="{{ $value.NiceName }}"} {{ $value.Value }}
+tunnelguard_peers_resets_total{interface="{{ $key.Interface | label }}",pub_key="{{ $key.PublicKey | label }}",nice_name="{{ $value.NiceName | label }}"} {{ $value.Value }}
+{{- end }}
+{{- end }}
+{{- if gt (len .PeerResetFailures) 0 }}
+# HELP tunnelguard_peers_reset_failures_total Number of failed resets of a peer.
+# TYPE tunnelguard_peers_reset_failures_total counter
+{{- range $key, $value := .PeerResetFailures }}
+tunnelguard_peers_reset_failures_total{interface="{{ $key.Interface | label }}",pub_key="{{ $key.PublicKey | label }}",nice_name="{{ $value.NiceName | label }}"} {{ $value.Value }}
+{{- end }}
+{{- end }}
+{{- if gt (len .ResetDuration) 0 }}
+# HELP tunnelguard_peers_reset_duration_seconds the duration of a peer's most recent reset
+# TYPE tunnelguard_peers_reset_duration_seconds gauge
+{{- range $key, $value := .ResetDuration }}
+tunnelguard_peers_reset_duration_seconds{interface="{{ $key.Interface | label }}",pub_key="{{ $key.PublicKey | label }}",nice_name="{{ $value.NiceName | label }}"} {{ $value.Seconds }}
+{{- end }}
+{{- end }}
+{{- if gt (len .PeerLastReset) 0 }}
+# HELP tunnelguard_peers_last_reset_timestamp_seconds the timestamp of a peer's most recent reset
+# TYPE tunnelguard_peers_last_reset_timestamp_seconds gauge
+{{- range $key, $value := .PeerLastReset }}
+tunnelguard_peers_last_reset_timestamp_seconds{interface="{{ $key.Interface | label }}",pub_key="{{ $key.PublicKey | label }}",nice_name="{{ $value.NiceName | label }}"} {{ $value.Value }}
+{{- end }}
+{{- end }}
+{{- if gt (len .PeerResetsSkipped) 0 }}
+# HELP tunnelguard_peers_resets_skipped_total Number of resets skipped because the endpoint's address did not change.
+# TYPE tunnelguard_peers_resets_skipped_total counter
+{{- range $key, $value := .PeerResetsSkipped }}
+tunnelguard_peers_resets_skipped_total{interface="{{ $key.Interface | label }}",pub_key="{{ $key.PublicKey | label }}",nice_name="{{ $value.NiceName | label }}"} {{ $value.Value }}
+{{- end }}
+{{- end }}
+{{- if gt (len .DnsResolutionFailures) 0 }}
+# HELP tunnelguard_peers_dns_resolution_failures_total Number of failed DNS resolutions of a peer's endpoint.
+# TYPE tunnelguard_peers_dns_resolution_failures_total counter
+{{- range $key, $value := .DnsResolutionFailures }}
+tunnelguard_peers_dns_resolution_failures_total{interface="{{ $key.Interface | label }}",pub_key="{{ $key.PublicKey | label }}",nice_name="{{ $value.NiceName | label }}"} {{ $value.Value }}
+{{- end }}
+{{- end }}
+{{- if gt (len .PeerResetsRateLimited) 0 }}
+# HELP tunnelguard_peers_resets_rate_limited_total Number of resets skipped because the global reset rate limit was reached.
+# TYPE tunnelguard_peers_resets_rate_limited_total counter
+{{- range $key, $value := .PeerResetsRateLimited }}
+tunnelguard_peers_resets_rate_limited_total{interface="{{ $key.Interface | label }}",pub_key="{{ $key.PublicKey | label }}",nice_name="{{ $value.NiceName | label }}"} {{ $value.Value }}
+{{- end }}
+{{- end }}
+{{- if gt (len .Remediations) 0 }}
+# HELP tunnelguard_peers_remediations_total Number of remediation attempts by escalation step and result.
+# TYPE tunnelguard_peers_remediations_total counter
+{{- range $key, $value := .Remediations }}
+tunnelguard_peers_remediations_total{interface="{{ $key.Interface | label }}",pub_key="{{ $key.PublicKey | label }}",nice_name="{{ $key.NiceName | label }}",action="{{ $key.Action | label }}",result="{{ $key.Result | label }}"} {{ $value }}
+{{- end }}
+{{- end }}
+{{- if gt (len .EscalationStep) 0 }}
+# HELP tunnelguard_peers_escalation_step the index of the escalation step that is tried next for a peer
+# TYPE tunnelguard_peers_escalation_step gauge
+{{- range $key, $value := .EscalationStep }}
+tunnelguard_peers_escalation_step{interface="{{ $key.Interface | label }}",pub_key="{{ $key.PublicKey | label }}",nice_name="{{ $value.NiceName | label }}"} {{ $value.Value }}
+{{- end }}
+{{- end }}
+{{- if gt (len .ActiveEndpoint) 0 }}
+# HELP tunnelguard_peers_active_endpoint_index the index of the failover endpoint a peer is currently using, 0 is the primary
+# TYPE tunnelguard_peers_active_endpoint_index gauge
+{{- range $key, $value := .ActiveEndpoint }}
+tunnelguard_peers_active_endpoint_index{interface="{{ $key.Interface | label }}",pub_key="{{ $key.PublicKey | label }}",nice_name="{{ $value.NiceName | label }}"} {{ $value.Value }}
+{{- end }}
+{{- end }}
+{{- if gt (len .ResetBackoffSeconds) 0 }}
+# HELP tunnelguard_peers_reset_backoff_seconds the current minimum duration between two resets of a peer
+# TYPE tunnelguard_peers_reset_backoff_seconds gauge
+{{- range $key, $value := .ResetBackoffSeconds }}
+tunnelguard_peers_reset_backoff_seconds{interface="{{ $key.Interface | label }}",pub_key="{{ $key.PublicKey | label }}",nice_name="{{ $value.NiceName | label }}"} {{ $value.Value }}
+{{- end }}
+{{- end }}
+{{- if gt (len .PeerStates) 0 }}
+# HELP tunnelguard_peers_state the current health state of a peer
+# TYPE tunnelguard_peers_state gauge
+{{- range $key, $value := .PeerStates }}
+{{- range $state := $.StateNames }}
+tunnelguard_peers_state{interface="{{ $key.Interface | label }}",pub_key="{{ $key.PublicKey | label }}",nice_name="{{ $value.NiceName | label }}",state="{{ $state | label }}"} {{ if eq $state $value.State }}1{{ else }}0{{ end }}
+{{- end }}
+{{- end }}
+# HELP tunnelguard_peers_last_state_change_timestamp_seconds the timestamp of a peer's most recent state change
+# TYPE tunnelguard_peers_last_state_change_timestamp_seconds gauge
+{{- range $key, $value := .PeerStates }}
+tunnelguard_peers_last_state_change_timestamp_seconds{interface="{{ $key.Interface | label }}",pub_key="{{ $key.PublicKey | label }}",nice_name="{{ $value.NiceName | label }}"} {{ $value.Since }}
+{{- end }}
+{{- end }}
+{{- if gt (len .ProbeResults) 0 }}
+# HELP tunnelguard_peers_probe_success whether the most recent probe of a peer succeeded
+# TYPE tunnelguard_peers_probe_success gauge
+{{- range $key, $value := .ProbeResults }}
+tunnelguard_peers_probe_success{interface="{{ $key.Interface | label }}",pub_key="{{ $key.PublicKey | label }}",nice_name="{{ $value.NiceName | label }}"} {{ $value.Success }}
+{{- end }}
+# HELP tunnelguard_peers_probe_duration_seconds the round-trip time of a peer's most recent successful probe
+# TYPE tunnelguard_peers_probe_duration_seconds gauge
+{{- range $key, $value := .ProbeResults }}
+tunnelguard_peers_probe_duration_seconds{interface="{{ $key.Interface | label }}",pub_key="{{ $key.PublicKey | label }}",nice_name="{{ $value.NiceName | label }}"} {{ $value.DurationSeconds }}
+{{- end }}
+# HELP tunnelguard_peers_probes_total Number of probes by result.
+# TYPE tunnelguard_peers_probes_total counter
+{{- range $key, $value := .ProbeResults }}
+tunnelguard_peers_probes_total{interface="{{ $key.Interface | label }}",pub_key="{{ $key.PublicKey | label }}",nice_name="{{ $value.NiceName | label }}",result="success"} {{ $value.Successes }}
+tunnelguard_peers_probes_total{interface="{{ $key.Interface | label }}",pub_key="{{ $key.PublicKey | label }}",nice_name="{{ $value.NiceName | label }}",result="failure"} {{ $value.Failures }}
+{{- end }}
+{{- end }}
+{{- if gt (len .SinkPushes) 0 }}
+# HELP tunnelguard_metrics_sink_pushes_total Number of pushes to a metrics sink by result.
+# TYPE tunnelguard_metrics_sink_pushes_total counter
+{{- range $key, $value := .SinkPushes }}
+tunnelguard_metrics_sink_pushes_total{sink="{{ $key.Sink | label }}",result="{{ $key.Result | label }}"} {{ $value }}
+{{- end }}
+{{- end }}
+{{- if gt (len .SinkLastSuccess) 0 }}
+# HELP tunnelguard_metrics_sink_last_success_timestamp_seconds the timestamp of the most recent successful push to a metrics sink
+# TYPE tunnelguard_metrics_sink_last_success_timestamp_seconds gauge
+{{- range $key, $value := .SinkLastSuccess }}
+tunnelguard_metrics_sink_last_success_timestamp_seconds{sink="{{ $key | label }}"} {{ $value }}
+{{- end }}
+{{- end }}
+{{- if gt (len .Notifications) 0 }}
+# HELP tunnelguard_notifications_total Number of notifications by notifier, event and status.
+# TYPE tunnelguard_notifications_total counter
+{{- range $key, $value := .Notifications }}
+tunnelguard_notifications_total{notifier="{{ $key.Notifier | label }}",event="{{ $key.Event | label }}",status="{{ $key.Status | label }}"} {{ $value }}
+{{- end }}
+{{- end }}
+{{- if gt (len .DryRunActions) 0 }}
+# HELP tunnelguard_dry_run_actions_total Number of actions that would have been taken if dry-run was disabled.
+# TYPE tunnelguard_dry_run_actions_total counter
+{{- range $key, $value := .DryRunActions }}
+tunnelguard_dry_run_actions_total{interface="{{ $key.Interface | label }}",action="{{ $key.Action | label }}",reason="{{ $key.Reason | label }}",pub_key="{{ $key.PublicKey | label }}",nice_name="{{ $key.NiceName | label }}"} {{ $value }}
+{{- end }}
+{{- end }}
+{{- if gt (len .HandshakeAge) 0 }}
+# HELP tunnelguard_peers_handshake_age_seconds the age of a peer's most recent handshake
+# TYPE tunnelguard_peers_handshake_age_seconds gauge
+{{- range $key, $value := .HandshakeAge }}
+tunnelguard_peers_handshake_age_seconds{interface="{{ $key.Interface | label }}",pub_key="{{ $key.PublicKey | label }}",nice_name="{{ $value.NiceName | label }}"} {{ $value.Value }}
+{{- end }}
+{{- end }}
+{{- if gt (len .PeerStale) 0 }}
+# HELP tunnelguard_peers_stale whether a peer is considered stale
+# TYPE tunnelguard_peers_stale gauge
+{{- range $key, $value := .PeerStale }}
+tunnelguard_peers_stale{interface="{{ $key.Interface | label }}",pub_key="{{ $key.PublicKey | label }}",nice_name="{{ $value.NiceName | label }}"} {{ $value.Value }}
+{{- end }}
+{{- end }}
+{{- if gt (len .EndpointType) 0 }}
+# HELP tunnelguard_peers_endpoint_type the type of a peer's configured endpoint
+# TYPE tunnelguard_peers_endpoint_type gauge
+{{- range $key, $value := .EndpointType }}
+{{- range $type := $.EndpointTypes }}
+tunnelguard_peers_endpoint_type{interface="{{ $key.Interface | label }}",pub_key="{{ $key.PublicKey | label }}",nice_name="{{ $value.NiceName | label }}",type="{{ $type | label }}"} {{ if eq $type $value.Type }}1{{ else }}0{{ end }}
+{{- end }}
+{{- end }}
+{{- end }}
+{{- if gt (len .RxBytes) 0 }}
+# HELP tunnelguard_peers_receive_bytes_total Number of bytes received from a peer.
+# TYPE tunnelguard_peers_receive_bytes_total counter
+{{- range $key, $value := .RxBytes }}
+tunnelguard_peers_receive_bytes_total{interface="{{ $key.Interface | label }}",pub_key="{{ $key.PublicKey | label }}",nice_name="{{ $value.NiceName | label }}"} {{ $value.Value }}
+{{- end }}
+# HELP tunnelguard_peers_transmit_bytes_total Number of bytes sent to a peer.
+# TYPE tunnelguard_peers_transmit_bytes_total counter
+{{- range $key, $value := .TxBytes }}
+tunnelguard_peers_transmit_bytes_total{interface="{{ $key.Interface | label }}",pub_key="{{ $key.PublicKey | label }}",nice_name="{{ $value.NiceName | label }}"} {{ $value.Value }}
 {{- end }}
 {{- end }}
 {{- if gt (len .LatestHandshakeTimestamp) 0 }}
 # HELP tunnelguard_peers_latest_handshake_timestap_seconds the timestamp of a peer's most recent handshake
 # TYPE tunnelguard_peers_latest_handshake_timestap_seconds gauge
 {{- range $key, $value := .LatestHandshakeTimestamp }}
-tunnelguard_peers_latest_handshake_timestap_seconds{pub_key="{{ $key }}",nice_name="{{ $value.NiceName }}"} {{ $value.Value }}
+tunnelguard_peers_latest_handshake_timestap_seconds{interface="{{ $key.Interface | label }}",pub_key="{{ $key.PublicKey | label }}",nice_name="{{ $value.NiceName | label }}"} {{ $value.Value }}
 {{- end }}
 {{- end }}
 `
@@ -42,10 +270,98 @@ var metrics = Metrics{
 		"go":  GoVersion,
 		"app": BuildVersion,
 	},
-	Heartbeat:                time.Now().Unix(),
-	ErrorsTotal:              make(map[string]int64),
-	PeerResets:               make(map[string]*peerMetricValue),
-	LatestHandshakeTimestamp: make(map[string]*peerMetricValue),
+	Heartbeat:                make(map[string]int64),
+	ErrorsTotal:              make(map[errorKey]int64),
+	PeerResets:               make(map[peerKey]*peerMetricValue),
+	PeerLastReset:            make(map[peerKey]*peerMetricValue),
+	PeerResetsSkipped:        make(map[peerKey]*peerMetricValue),
+	DnsResolutionFailures:    make(map[peerKey]*peerMetricValue),
+	LatestHandshakeTimestamp: make(map[peerKey]*peerMetricValue),
+	DryRunActions:            make(map[dryRunKey]int64),
+	PeerResetsRateLimited:    make(map[peerKey]*peerMetricValue),
+	ResetBackoffSeconds:      make(map[peerKey]*peerMetricValue),
+	Remediations:             make(map[remediationKey]int64),
+	EscalationStep:           make(map[peerKey]*peerMetricValue),
+	ActiveEndpoint:           make(map[peerKey]*peerMetricValue),
+	PeerStates:               make(map[peerKey]*peerStateValue),
+	Notifications:            make(map[notificationKey]int64),
+	ProbeResults:             make(map[peerKey]*peerProbeValue),
+	ConfigReloads:            make(map[string]int64),
+	TunnelUp:                 make(map[string]int64),
+	PeerCount:                make(map[string]int64),
+	GetPeersDuration:         make(map[string]float64),
+	PeerResetFailures:        make(map[peerKey]*peerMetricValue),
+	ResetDuration:            make(map[peerKey]*peerDurationValue),
+	HandshakeAge:             make(map[peerKey]*peerMetricValue),
+	PeerStale:                make(map[peerKey]*peerMetricValue),
+	EndpointType:             make(map[peerKey]*peerEndpointTypeValue),
+	RxBytes:                  make(map[peerKey]*peerMetricValue),
+	TxBytes:                  make(map[peerKey]*peerMetricValue),
+	SinkPushes:               make(map[sinkKey]int64),
+	SinkLastSuccess:          make(map[string]int64),
+}
+
+type peerKey struct {
+	Interface string
+	PublicKey string
+}
+
+type errorKey struct {
+	Interface string
+	Error     string
+}
+
+type dryRunKey struct {
+	Interface string
+	Action    string
+	Reason    string
+	PublicKey string
+	NiceName  string
+}
+
+const (
+	reloadSuccess = "success"
+	reloadFailure = "failure"
+)
+
+const (
+	notificationSent       = "sent"
+	notificationFailed     = "failed"
+	notificationSuppressed = "suppressed"
+	notificationDropped    = "dropped"
+)
+
+type remediationKey struct {
+	Interface string
+	PublicKey string
+	NiceName  string
+	Action    string
+	Result    string
+}
+
+type sinkKey struct {
+	Sink   string
+	Result string
+}
+
+type notificationKey struct {
+	Notifier string
+	Event    string
+	Status   string
+}
+
+type peerStateValue struct {
+	State    string
+	Since    int64
+	NiceName string
+}
+
+type peerProbeValue struct {
+	Success         int64
+	DurationSeconds float64
+	Successes       int64
+	Failures        int64
+	NiceName        string
 }
 
 type peerMetricValue struct {
@@ -53,22 +369,418 @@ type peerMetricValue struct {
 	NiceName string
 }
 
+type peerDurationValue struct {
+	Seconds  float64
+	NiceName string
+}
+
+type peerEndpointTypeValue struct {
+	Type     string
+	NiceName string
+}
+
+const (
+	// endpointTypeStatic is an endpoint configured with an IP address
+	endpointTypeStatic = "static"
+	// endpointTypeDns is an endpoint configured with a hostname
+	endpointTypeDns = "dns"
+	// endpointTypeDynamic is an endpoint that is not configured but learned from the peer's handshakes
+	endpointTypeDynamic = "dynamic"
+)
+
+var endpointTypes = []string{endpointTypeStatic, endpointTypeDns, endpointTypeDynamic}
+
 type Metrics struct {
+	mutex sync.Mutex
+
 	Version                  map[string]string
-	Heartbeat                int64
+	Heartbeat                map[string]int64
 	LastStatusChange         int64
-	ErrorsTotal              map[string]int64
-	PeerResets               map[string]*peerMetricValue
-	LatestHandshakeTimestamp map[string]*peerMetricValue
+	ErrorsTotal              map[errorKey]int64
+	PeerResets               map[peerKey]*peerMetricValue
+	PeerLastReset            map[peerKey]*peerMetricValue
+	PeerResetsSkipped        map[peerKey]*peerMetricValue
+	DnsResolutionFailures    map[peerKey]*peerMetricValue
+	LatestHandshakeTimestamp map[peerKey]*peerMetricValue
+	DryRunActions            map[dryRunKey]int64
+	PeerResetsRateLimited    map[peerKey]*peerMetricValue
+	ResetBackoffSeconds      map[peerKey]*peerMetricValue
+	Remediations             map[remediationKey]int64
+	EscalationStep           map[peerKey]*peerMetricValue
+	ActiveEndpoint           map[peerKey]*peerMetricValue
+	PeerStates               map[peerKey]*peerStateValue
+	Notifications            map[notificationKey]int64
+	ProbeResults             map[peerKey]*peerProbeValue
+
+	TunnelUp          map[string]int64
+	PeerCount         map[string]int64
+	GetPeersDuration  map[string]float64
+	PeerResetFailures map[peerKey]*peerMetricValue
+	ResetDuration     map[peerKey]*peerDurationValue
+	HandshakeAge      map[peerKey]*peerMetricValue
+	PeerStale         map[peerKey]*peerMetricValue
+	EndpointType      map[peerKey]*peerEndpointTypeValue
+	RxBytes           map[peerKey]*peerMetricValue
+	TxBytes           map[peerKey]*peerMetricValue
+
+	SinkPushes      map[sinkKey]int64
+	SinkLastSuccess map[string]int64
+
+	ConfigReloads              map[string]int64
+	ConfigLastReload           int64
+	ConfigLastReloadSuccess    int64
+	ConfigLastReloadSuccessful int64
+
+	ShutdownTimestamp int64
+	ShutdownClean     int64
+}
+
+// StateNames returns all possible health states of a peer.
+func (m *Metrics) StateNames() []string {
+	return peerStates
+}
+
+// EndpointTypes returns all possible types of a peer's endpoint.
+func (m *Metrics) EndpointTypes() []string {
+	return endpointTypes
+}
+
+// Render executes the template on a consistent snapshot of the metrics.
+func (m *Metrics) Render(tmpl *template.Template, w io.Writer) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return tmpl.Execute(w, m)
+}
+
+func (m *Metrics) SetHeartbeat(iface string, timestamp int64) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.Heartbeat[iface] = timestamp
+}
+
+func (m *Metrics) GetHeartbeat(iface string) (int64, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	heartbeat, found := m.Heartbeat[iface]
+	return heartbeat, found
+}
+
+func (m *Metrics) IncError(iface string, err string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.ErrorsTotal[errorKey{Interface: iface, Error: err}]++
+}
+
+// SetErrors sets the total number of errors, used to restore the persisted state.
+func (m *Metrics) SetErrors(iface string, err string, total int64) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.ErrorsTotal[errorKey{Interface: iface, Error: err}] = total
+}
+
+// GetErrors returns the total number of errors of the interface keyed by error.
+func (m *Metrics) GetErrors(iface string) map[string]int64 {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	ret := map[string]int64{}
+	for key, value := range m.ErrorsTotal {
+		if key.Interface == iface {
+			ret[key.Error] = value
+		}
+	}
+	return ret
+}
+
+func (m *Metrics) IncPeerResets(iface string, publicKey string, niceName string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	value := getPeerMetricValue(m.PeerResets, iface, publicKey, niceName)
+	value.Value++
+}
+
+// SetPeerResets sets the total number of resets of a peer, used to restore the persisted state.
+func (m *Metrics) SetPeerResets(iface string, publicKey string, niceName string, total int64) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	value := getPeerMetricValue(m.PeerResets, iface, publicKey, niceName)
+	value.Value = total
+}
+
+// GetPeerResets returns the total number of resets of all peers of the interface.
+func (m *Metrics) GetPeerResets(iface string) map[string]int64 {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return getPeerMetricValues(m.PeerResets, iface)
+}
+
+func (m *Metrics) SetLastReset(iface string, publicKey string, niceName string, timestamp time.Time) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	value := getPeerMetricValue(m.PeerLastReset, iface, publicKey, niceName)
+	value.Value = timestamp.Unix()
+}
+
+// GetLastResets returns the time of the most recent reset of all peers of the interface.
+func (m *Metrics) GetLastResets(iface string) map[string]time.Time {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	ret := map[string]time.Time{}
+	for publicKey, timestamp := range getPeerMetricValues(m.PeerLastReset, iface) {
+		ret[publicKey] = time.Unix(timestamp, 0)
+	}
+	return ret
+}
+
+func (m *Metrics) IncPeerResetsSkipped(iface string, publicKey string, niceName string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	value := getPeerMetricValue(m.PeerResetsSkipped, iface, publicKey, niceName)
+	value.Value++
+}
+
+func (m *Metrics) IncDnsResolutionFailures(iface string, publicKey string, niceName string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	value := getPeerMetricValue(m.DnsResolutionFailures, iface, publicKey, niceName)
+	value.Value++
+}
+
+func (m *Metrics) IncPeerResetsRateLimited(iface string, publicKey string, niceName string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	value := getPeerMetricValue(m.PeerResetsRateLimited, iface, publicKey, niceName)
+	value.Value++
+}
+
+func (m *Metrics) SetResetBackoff(iface string, publicKey string, niceName string, backoff time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	value := getPeerMetricValue(m.ResetBackoffSeconds, iface, publicKey, niceName)
+	value.Value = int64(backoff.Seconds())
+}
+
+func (m *Metrics) IncRemediation(iface string, publicKey string, niceName string, action string, result string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.Remediations[remediationKey{Interface: iface, PublicKey: publicKey, NiceName: niceName, Action: action, Result: result}]++
 }
 
+func (m *Metrics) SetEscalationStep(iface string, publicKey string, niceName string, step int) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	value := getPeerMetricValue(m.EscalationStep, iface, publicKey, niceName)
+	value.Value = int64(step)
+}
+
+func (m *Metrics) SetActiveEndpoint(iface string, publicKey string, niceName string, index int) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	value := getPeerMetricValue(m.ActiveEndpoint, iface, publicKey, niceName)
+	value.Value = int64(index)
+}
+
+// GetPeerStates returns the health state of all peers of the interface.
+func (m *Metrics) GetPeerStates(iface string) map[string]string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	ret := map[string]string{}
+	for key, value := range m.PeerStates {
+		if key.Interface == iface {
+			ret[key.PublicKey] = value.State
+		}
+	}
+	return ret
+}
+
+func (m *Metrics) SetPeerState(iface string, publicKey string, niceName string, state string, since time.Time) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.PeerStates[peerKey{Interface: iface, PublicKey: publicKey}] = &peerStateValue{
+		State:    state,
+		Since:    since.Unix(),
+		NiceName: niceName,
+	}
+	m.LastStatusChange = since.Unix()
+}
+
+// SetProbeResult records the result of a probe. The duration is kept from the last successful probe.
+func (m *Metrics) SetProbeResult(iface string, publicKey string, niceName string, success bool, rtt time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	key := peerKey{Interface: iface, PublicKey: publicKey}
+	if m.ProbeResults[key] == nil {
+		m.ProbeResults[key] = &peerProbeValue{}
+	}
+	value := m.ProbeResults[key]
+	value.NiceName = niceName
+	if success {
+		value.Success = 1
+		value.DurationSeconds = rtt.Seconds()
+		value.Successes++
+	} else {
+		value.Success = 0
+		value.Failures++
+	}
+}
+
+func (m *Metrics) SetConfigReload(success bool, timestamp time.Time) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.ConfigLastReload = timestamp.Unix()
+	if success {
+		m.ConfigReloads[reloadSuccess]++
+		m.ConfigLastReloadSuccess = timestamp.Unix()
+		m.ConfigLastReloadSuccessful = 1
+	} else {
+		m.ConfigReloads[reloadFailure]++
+		m.ConfigLastReloadSuccessful = 0
+	}
+}
+
+func (m *Metrics) SetShutdown(clean bool, timestamp time.Time) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.ShutdownTimestamp = timestamp.Unix()
+	m.ShutdownClean = 0
+	if clean {
+		m.ShutdownClean = 1
+	}
+}
+
+func (m *Metrics) SetSinkPush(sink string, success bool, timestamp time.Time) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if success {
+		m.SinkPushes[sinkKey{Sink: sink, Result: sinkPushSuccess}]++
+		m.SinkLastSuccess[sink] = timestamp.Unix()
+	} else {
+		m.SinkPushes[sinkKey{Sink: sink, Result: sinkPushFailure}]++
+	}
+}
+
+func (m *Metrics) IncNotifications(notifier string, event string, status string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.Notifications[notificationKey{Notifier: notifier, Event: event, Status: status}]++
+}
+
+func (m *Metrics) IncDryRunAction(key dryRunKey) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.DryRunActions[key]++
+}
+
+func (m *Metrics) SetTunnelUp(iface string, up bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.TunnelUp[iface] = 0
+	if up {
+		m.TunnelUp[iface] = 1
+	}
+}
+
+// SetPeers records the number of peers of the interface and the duration it took to query them.
+func (m *Metrics) SetPeers(iface string, count int, duration time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.PeerCount[iface] = int64(count)
+	m.GetPeersDuration[iface] = duration.Seconds()
+}
+
+func (m *Metrics) SetGetPeersDuration(iface string, duration time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.GetPeersDuration[iface] = duration.Seconds()
+}
+
+func (m *Metrics) IncPeerResetFailures(iface string, publicKey string, niceName string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	value := getPeerMetricValue(m.PeerResetFailures, iface, publicKey, niceName)
+	value.Value++
+}
+
+func (m *Metrics) SetResetDuration(iface string, publicKey string, niceName string, duration time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.ResetDuration[peerKey{Interface: iface, PublicKey: publicKey}] = &peerDurationValue{
+		Seconds:  duration.Seconds(),
+		NiceName: niceName,
+	}
+}
+
+// SetPeerHealth records the age of the peer's latest handshake and whether it is considered stale.
+func (m *Metrics) SetPeerHealth(iface string, publicKey string, niceName string, handshakeAge time.Duration, stale bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	getPeerMetricValue(m.HandshakeAge, iface, publicKey, niceName).Value = int64(handshakeAge.Seconds())
+	value := getPeerMetricValue(m.PeerStale, iface, publicKey, niceName)
+	value.Value = 0
+	if stale {
+		value.Value = 1
+	}
+}
+
+func (m *Metrics) SetEndpointType(iface string, publicKey string, niceName string, endpointType string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.EndpointType[peerKey{Interface: iface, PublicKey: publicKey}] = &peerEndpointTypeValue{
+		Type:     endpointType,
+		NiceName: niceName,
+	}
+}
+
+// SetTransfer records the number of bytes received from and sent to the peer as reported by WireGuard.
+func (m *Metrics) SetTransfer(iface string, publicKey string, niceName string, rx int64, tx int64) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	getPeerMetricValue(m.RxBytes, iface, publicKey, niceName).Value = rx
+	getPeerMetricValue(m.TxBytes, iface, publicKey, niceName).Value = tx
+}
+
+func (m *Metrics) SetLatestHandshake(iface string, publicKey string, niceName string, timestamp int64) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	value := getPeerMetricValue(m.LatestHandshakeTimestamp, iface, publicKey, niceName)
+	value.Value = timestamp
+}
+
+// getPeerMetricValue returns the value for the given peer, creating it if necessary. The caller must hold the lock.
+func getPeerMetricValue(values map[peerKey]*peerMetricValue, iface, publicKey, niceName string) *peerMetricValue {
+	key := peerKey{Interface: iface, PublicKey: publicKey}
+	if values[key] == nil {
+		values[key] = &peerMetricValue{}
+	}
+	values[key].NiceName = niceName
+	return values[key]
+}
+
+// labelEscaper escapes label values as required by the text exposition format.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func newMetricsTemplate() (*template.Template, error) {
+	return template.New("metrics").Funcs(template.FuncMap{"label": labelEscaper.Replace}).Parse(templateData)
+}
+
+// MetricsWriter writes the metrics to the metrics file on every Dump and periodically pushes them to its sinks.
 type MetricsWriter struct {
-	tmpl        *template.Template
+	mutex sync.Mutex
+	tmpl  *template.Template
+	// metricsFile is the path of the textfile, empty if disabled
 	metricsFile string
+	sinks       []*namedSink
+}
+
+type namedSink struct {
+	name     string
+	interval time.Duration
+	sink     MetricsSink
+	// failing suppresses repeated warnings while the sink keeps failing
+	failing atomic.Bool
 }
 
 func NewMetricsWriter(metricsFile string) (*MetricsWriter, error) {
-	tmpl, err := template.New("metrics").Parse(templateData)
+	tmpl, err := newMetricsTemplate()
 	if err != nil {
 		return nil, err
 	}
@@ -79,17 +791,80 @@ func NewMetricsWriter(metricsFile string) (*MetricsWriter, error) {
 	}, nil
 }
 
+// AddSink registers a sink that receives the metrics every interval once Run has been called.
+func (m *MetricsWriter) AddSink(name string, sink MetricsSink, interval time.Duration) {
+	m.sinks = append(m.sinks, &namedSink{name: name, interval: interval, sink: sink})
+}
+
+// Run pushes the metrics to each sink in its own interval until the context is cancelled.
+func (m *MetricsWriter) Run(ctx context.Context) {
+	for _, sink := range m.sinks {
+		go m.runSink(ctx, sink)
+	}
+}
+
+// Flush writes the metrics file and pushes the metrics to all sinks immediately.
+func (m *MetricsWriter) Flush() error {
+	errs := m.Dump()
+	for _, sink := range m.sinks {
+		if err := m.push(sink); err != nil {
+			errs = errors.Join(errs, fmt.Errorf("sink %s: %w", sink.name, err))
+		}
+	}
+	return errs
+}
+
+// push renders the metrics and pushes them to the sink, the result is recorded in the metrics of the next push.
+func (m *MetricsWriter) push(sink *namedSink) error {
+	var buf bytes.Buffer
+	err := metrics.Render(m.tmpl, &buf)
+	if err == nil {
+		err = sink.sink.Push(buf.Bytes())
+	}
+	metrics.SetSinkPush(sink.name, err == nil, time.Now())
+
+	if err != nil {
+		if !sink.failing.Swap(true) {
+			slog.Warn("can not push metrics", "sink", sink.name, "err", err)
+		}
+		return err
+	}
+	if sink.failing.Swap(false) {
+		slog.Info("pushing metrics succeeded again", "sink", sink.name)
+	}
+	return nil
+}
+
 func (m *MetricsWriter) Dump() error {
+	if len(m.metricsFile) == 0 {
+		return nil
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
 	tmpFile := fmt.Sprintf("%s.tmp", m.metricsFile)
 	file, err := os.Create(tmpFile)
 	if err != nil {
-		log.Fatalf("Error creating file: %v", err)
+		return fmt.Errorf("could not create file: %w", err)
 	}
 	defer file.Close()
 
-	if err := m.tmpl.Execute(file, metrics); err != nil {
+	if err := metrics.Render(m.tmpl, file); err != nil {
 		return fmt.Errorf("could not execute template: %w", err)
 	}
 
 	return os.Rename(tmpFile, m.metricsFile)
 }
+
+// getPeerMetricValues returns the values of all peers of the interface keyed by public key. The caller must hold the
+// lock.
+func getPeerMetricValues(values map[peerKey]*peerMetricValue, iface string) map[string]int64 {
+	ret := map[string]int64{}
+	for key, value := range values {
+		if key.Interface == iface {
+			ret[key.PublicKey] = value.Value
+		}
+	}
+	return ret
+}
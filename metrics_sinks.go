@@ -0,0 +1,443 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	sinkPushgateway = "pushgateway"
+	sinkInfluxDb    = "influxdb"
+	sinkStatsd      = "statsd"
+	sinkGraphite    = "graphite"
+
+	sinkPushSuccess = "success"
+	sinkPushFailure = "failure"
+
+	defaultSinkIntervalSeconds = 60
+	defaultPushgatewayJob      = "tunnelguard"
+	sinkTimeout                = 10 * time.Second
+	// statsdMaxPacketSize keeps datagrams below the usual MTU
+	statsdMaxPacketSize = 1432
+)
+
+// MetricsSink receives the metrics in the Prometheus exposition format and pushes them to a monitoring system.
+type MetricsSink interface {
+	Push(exposition []byte) error
+}
+
+// MetricsSinkConfig configures a sink that periodically pushes the metrics.
+type MetricsSinkConfig struct {
+	// Type is one of pushgateway, influxdb, statsd or graphite.
+	Type string `json:"type"`
+	// Name identifies the sink in logs and metrics, defaults to the type.
+	Name string `json:"name"`
+	// Address is the url of the Pushgateway or of InfluxDB's write endpoint including its query parameters, or the
+	// host:port of the StatsD or Graphite server.
+	Address         string `json:"address"`
+	IntervalSeconds int    `json:"interval_seconds"`
+	// Job is the job label used for the Pushgateway, defaults to tunnelguard.
+	Job string `json:"job"`
+	// Token is sent as authorization header to InfluxDB.
+	Token string `json:"token"`
+	// Prefix is prepended to the metric names for StatsD and Graphite.
+	Prefix string `json:"prefix"`
+}
+
+func (c *MetricsSinkConfig) name() string {
+	if len(c.Name) > 0 {
+		return c.Name
+	}
+	return c.Type
+}
+
+func (c *MetricsSinkConfig) interval() time.Duration {
+	if c.IntervalSeconds > 0 {
+		return time.Duration(c.IntervalSeconds) * time.Second
+	}
+	return defaultSinkIntervalSeconds * time.Second
+}
+
+func (c *MetricsSinkConfig) validate() error {
+	if len(c.Address) == 0 {
+		return errors.New("empty address")
+	}
+	if c.IntervalSeconds < 0 {
+		return fmt.Errorf("invalid interval %d", c.IntervalSeconds)
+	}
+
+	switch c.Type {
+	case sinkPushgateway, sinkInfluxDb:
+		parsed, err := url.Parse(c.Address)
+		if err != nil {
+			return err
+		}
+		if parsed.Scheme != "http" && parsed.Scheme != "https" {
+			return fmt.Errorf("address %q is not a http url", c.Address)
+		}
+	case sinkStatsd, sinkGraphite:
+		if _, _, err := net.SplitHostPort(c.Address); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown type %q", c.Type)
+	}
+	return nil
+}
+
+// BuildMetricsSink builds the sink described by the config.
+func BuildMetricsSink(conf MetricsSinkConfig) (MetricsSink, error) {
+	if err := conf.validate(); err != nil {
+		return nil, fmt.Errorf("sink %s: %w", conf.name(), err)
+	}
+
+	client := &http.Client{Timeout: sinkTimeout}
+	switch conf.Type {
+	case sinkPushgateway:
+		job := conf.Job
+		if len(job) == 0 {
+			job = defaultPushgatewayJob
+		}
+		return &PushgatewaySink{client: client, url: strings.TrimSuffix(conf.Address, "/") + "/metrics/job/" + url.PathEscape(job)}, nil
+	case sinkInfluxDb:
+		return &InfluxDbSink{client: client, url: conf.Address, token: conf.Token}, nil
+	case sinkStatsd:
+		return &StatsdSink{address: conf.Address, prefix: conf.Prefix}, nil
+	default:
+		return &GraphiteSink{address: conf.Address, prefix: conf.Prefix}, nil
+	}
+}
+
+// PushgatewaySink replaces the metrics of its job on a Prometheus Pushgateway.
+type PushgatewaySink struct {
+	client *http.Client
+	url    string
+}
+
+func (s *PushgatewaySink) Push(exposition []byte) error {
+	req, err := http.NewRequest(http.MethodPut, s.url, bytes.NewReader(exposition))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	return doSinkRequest(s.client, req)
+}
+
+// InfluxDbSink writes the metrics using the line protocol. Each metric is a measurement with a single field named
+// value and its labels as tags.
+type InfluxDbSink struct {
+	client *http.Client
+	url    string
+	token  string
+}
+
+func (s *InfluxDbSink) Push(exposition []byte) error {
+	samples, err := parseExposition(exposition)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	for _, sample := range samples {
+		buf.WriteString(influxEscaper.Replace(sample.Name))
+		for _, label := range sample.Labels {
+			// empty tag values are not allowed
+			if len(label.Value) > 0 {
+				fmt.Fprintf(&buf, ",%s=%s", influxEscaper.Replace(label.Name), influxEscaper.Replace(label.Value))
+			}
+		}
+		fmt.Fprintf(&buf, " value=%s\n", sample.Value)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.url, &buf)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if len(s.token) > 0 {
+		req.Header.Set("Authorization", "Token "+s.token)
+	}
+	return doSinkRequest(s.client, req)
+}
+
+var influxEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+
+func doSinkRequest(client *http.Client, req *http.Request) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// StatsdSink sends the metrics over UDP. As StatsD has no labels, the label values are appended to the metric name,
+// e.g. tunnelguard_peers_resets_total.wg0.<pub_key>.<nice_name>. Counters are sent as StatsD counters with their
+// increase since the previous push, all other metrics as gauges.
+type StatsdSink struct {
+	address string
+	prefix  string
+
+	mutex sync.Mutex
+	// counters holds the values of the counters that have been sent by the previous push
+	counters map[string]float64
+}
+
+func (s *StatsdSink) Push(exposition []byte) error {
+	samples, err := parseExposition(exposition)
+	if err != nil {
+		return err
+	}
+
+	conn, err := net.DialTimeout("udp", s.address, sinkTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.counters == nil {
+		s.counters = map[string]float64{}
+	}
+
+	var packet bytes.Buffer
+	for _, sample := range samples {
+		name := flatMetricName(s.prefix, sample)
+		line := fmt.Sprintf("%s:%s|g\n", name, sample.Value)
+		if sample.Type == "counter" {
+			increase, ok := s.counterIncrease(name, sample.Value)
+			if !ok {
+				continue
+			}
+			line = fmt.Sprintf("%s:%s|c\n", name, strconv.FormatFloat(increase, 'f', -1, 64))
+		}
+		if packet.Len()+len(line) > statsdMaxPacketSize && packet.Len() > 0 {
+			if _, err := conn.Write(packet.Bytes()); err != nil {
+				return err
+			}
+			packet.Reset()
+		}
+		packet.WriteString(line)
+	}
+	if packet.Len() > 0 {
+		_, err = conn.Write(packet.Bytes())
+	}
+	return err
+}
+
+// counterIncrease returns the increase of the counter since the previous push and records its value. The first
+// push sends the whole value. It returns false if the counter did not increase or its value is invalid.
+func (s *StatsdSink) counterIncrease(name string, value string) (float64, bool) {
+	current, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, false
+	}
+	previous := s.counters[name]
+	s.counters[name] = current
+	if current < previous {
+		// the counter has been reset
+		previous = 0
+	}
+	return current - previous, current > previous
+}
+
+// GraphiteSink sends the metrics using the plaintext protocol over TCP. Labels are sent as Graphite tags.
+type GraphiteSink struct {
+	address string
+	prefix  string
+}
+
+func (s *GraphiteSink) Push(exposition []byte) error {
+	samples, err := parseExposition(exposition)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	timestamp := time.Now().Unix()
+	for _, sample := range samples {
+		name := sample.Name
+		if len(s.prefix) > 0 {
+			name = s.prefix + "." + name
+		}
+		buf.WriteString(name)
+		for _, label := range sample.Labels {
+			// empty tag values are not allowed
+			if len(label.Value) > 0 {
+				fmt.Fprintf(&buf, ";%s=%s", label.Name, graphiteTagValue(label.Value))
+			}
+		}
+		fmt.Fprintf(&buf, " %s %d\n", sample.Value, timestamp)
+	}
+
+	conn, err := net.DialTimeout("tcp", s.address, sinkTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_ = conn.SetWriteDeadline(time.Now().Add(sinkTimeout))
+	_, err = conn.Write(buf.Bytes())
+	return err
+}
+
+// graphiteTagValue replaces the characters that are not allowed in tag values.
+func graphiteTagValue(value string) string {
+	value = strings.NewReplacer(";", "_", " ", "_", "\n", "_").Replace(value)
+	if strings.HasPrefix(value, "~") {
+		value = "_" + value[1:]
+	}
+	return value
+}
+
+// flatMetricName appends the sanitised label values to the metric name.
+func flatMetricName(prefix string, sample metricSample) string {
+	parts := []string{sample.Name}
+	if len(prefix) > 0 {
+		parts = []string{prefix, sample.Name}
+	}
+	for _, label := range sample.Labels {
+		if len(label.Value) == 0 {
+			continue
+		}
+		parts = append(parts, strings.Map(func(r rune) rune {
+			if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-' {
+				return r
+			}
+			return '_'
+		}, label.Value))
+	}
+	return strings.Join(parts, ".")
+}
+
+type metricLabel struct {
+	Name  string
+	Value string
+}
+
+type metricSample struct {
+	Name   string
+	Labels []metricLabel
+	// Value is kept as rendered by the template
+	Value string
+	// Type is the type declared by the metric's TYPE comment, e.g. counter
+	Type string
+}
+
+// parseExposition parses the samples of the Prometheus exposition format, comments are skipped.
+func parseExposition(data []byte) ([]metricSample, error) {
+	var samples []metricSample
+	types := map[string]string{}
+	for idx, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if fields := strings.Fields(line); len(fields) == 4 && fields[0] == "#" && fields[1] == "TYPE" {
+			types[fields[2]] = fields[3]
+		}
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		sample, err := parseSample(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", idx+1, err)
+		}
+		sample.Type = types[sample.Name]
+		samples = append(samples, sample)
+	}
+	return samples, nil
+}
+
+func parseSample(line string) (metricSample, error) {
+	var sample metricSample
+	end := strings.IndexAny(line, "{ ")
+	if end <= 0 {
+		return sample, fmt.Errorf("invalid sample %q", line)
+	}
+	sample.Name = line[:end]
+	rest := line[end:]
+
+	if rest[0] == '{' {
+		rest = rest[1:]
+		for {
+			rest = strings.TrimLeft(rest, " ,")
+			if strings.HasPrefix(rest, "}") {
+				rest = rest[1:]
+				break
+			}
+
+			eq := strings.Index(rest, `="`)
+			if eq <= 0 {
+				return sample, fmt.Errorf("invalid labels in %q", line)
+			}
+			name := rest[:eq]
+			value, remaining, err := parseLabelValue(rest[eq+2:])
+			if err != nil {
+				return sample, fmt.Errorf("label %s: %w", name, err)
+			}
+			sample.Labels = append(sample.Labels, metricLabel{Name: name, Value: value})
+			rest = remaining
+		}
+	}
+
+	fields := strings.Fields(rest)
+	if len(fields) == 0 {
+		return sample, fmt.Errorf("missing value in %q", line)
+	}
+	sample.Value = fields[0]
+	return sample, nil
+}
+
+// parseLabelValue parses a quoted label value without its opening quote and returns the remaining input.
+func parseLabelValue(input string) (string, string, error) {
+	var value strings.Builder
+	for idx := 0; idx < len(input); idx++ {
+		switch input[idx] {
+		case '"':
+			return value.String(), input[idx+1:], nil
+		case '\\':
+			idx++
+			if idx >= len(input) {
+				return "", "", errors.New("unterminated value")
+			}
+			if input[idx] == 'n' {
+				value.WriteByte('\n')
+			} else {
+				value.WriteByte(input[idx])
+			}
+		default:
+			value.WriteByte(input[idx])
+		}
+	}
+	return "", "", errors.New("unterminated value")
+}
+
+// runSink pushes the metrics to the sink every interval until the context is cancelled.
+func (m *MetricsWriter) runSink(ctx context.Context, sink *namedSink) {
+	ticker := time.NewTicker(sink.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_ = m.push(sink)
+		}
+	}
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testExposition = `# HELP tunnelguard_peers_resets_total Number of resets.
+# TYPE tunnelguard_peers_resets_total counter
+tunnelguard_peers_resets_total{interface="wg0",pub_key="a/b+c=",nice_name="home office"} 3
+tunnelguard_peers_resets_total{interface="wg0",pub_key="d",nice_name=""} 1
+tunnelguard_heartbeat_timestamp_seconds{interface="wg0"} 1725551118
+tunnelguard_shutdown_clean 1
+`
+
+func Test_parseExposition(t *testing.T) {
+	got, err := parseExposition([]byte(testExposition + `tunnelguard_quoted{nice_name="say \"hi\"\\"} 0.25` + "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []metricSample{
+		{Name: "tunnelguard_peers_resets_total", Labels: []metricLabel{{"interface", "wg0"}, {"pub_key", "a/b+c="}, {"nice_name", "home office"}}, Value: "3", Type: "counter"},
+		{Name: "tunnelguard_peers_resets_total", Labels: []metricLabel{{"interface", "wg0"}, {"pub_key", "d"}, {"nice_name", ""}}, Value: "1", Type: "counter"},
+		{Name: "tunnelguard_heartbeat_timestamp_seconds", Labels: []metricLabel{{"interface", "wg0"}}, Value: "1725551118"},
+		{Name: "tunnelguard_shutdown_clean", Value: "1"},
+		{Name: "tunnelguard_quoted", Labels: []metricLabel{{"nice_name", `say "hi"\`}}, Value: "0.25"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseExposition() = %v, want %v", got, want)
+	}
+
+	for _, invalid := range []string{`metric{label="unterminated} 1`, `metric{label} 1`, `metric`, `{label="a"} 1`} {
+		if _, err := parseExposition([]byte(invalid)); err == nil {
+			t.Errorf("parseExposition(%q) expected error", invalid)
+		}
+	}
+}
+
+func Test_parseExposition_renderedLabels(t *testing.T) {
+	// sorted, as the order of the rendered peers is not defined
+	niceNames := []string{`Bob's "home"`, `C:\temp`, "two\nlines"}
+	for idx, niceName := range niceNames {
+		metrics.IncPeerResets("wg-escape", strconv.Itoa(idx), niceName)
+	}
+
+	tmpl, err := newMetricsTemplate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := metrics.Render(tmpl, &buf); err != nil {
+		t.Fatal(err)
+	}
+	samples, err := parseExposition(buf.Bytes())
+	if err != nil {
+		t.Fatalf("parseExposition() error = %v", err)
+	}
+
+	var got []string
+	for _, sample := range samples {
+		if sample.Name == "tunnelguard_peers_resets_total" && sample.Labels[0].Value == "wg-escape" {
+			got = append(got, sample.Labels[2].Value)
+		}
+	}
+	slices.Sort(got)
+	if !reflect.DeepEqual(got, niceNames) {
+		t.Errorf("nice names = %q, want %q", got, niceNames)
+	}
+}
+
+// captureHttp returns a server that records the method, path, authorization header and body of the last request.
+func captureHttp(t *testing.T, status int) (*httptest.Server, chan [4]string) {
+	requests := make(chan [4]string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- [4]string{r.Method, r.URL.RequestURI(), r.Header.Get("Authorization"), string(body)}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server, requests
+}
+
+func TestPushgatewaySink(t *testing.T) {
+	server, requests := captureHttp(t, http.StatusOK)
+	sink, err := BuildMetricsSink(MetricsSinkConfig{Type: sinkPushgateway, Address: server.URL + "/", Job: "tunnel guard"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sink.Push([]byte(testExposition)); err != nil {
+		t.Fatal(err)
+	}
+	got := <-requests
+	want := [4]string{http.MethodPut, "/metrics/job/tunnel%20guard", "", testExposition}
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestInfluxDbSink(t *testing.T) {
+	server, requests := captureHttp(t, http.StatusNoContent)
+	sink, err := BuildMetricsSink(MetricsSinkConfig{Type: sinkInfluxDb, Address: server.URL + "/api/v2/write?bucket=wg&precision=s", Token: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sink.Push([]byte(testExposition)); err != nil {
+		t.Fatal(err)
+	}
+	got := <-requests
+	want := [4]string{http.MethodPost, "/api/v2/write?bucket=wg&precision=s", "Token secret", `tunnelguard_peers_resets_total,interface=wg0,pub_key=a/b+c\=,nice_name=home\ office value=3
+tunnelguard_peers_resets_total,interface=wg0,pub_key=d value=1
+tunnelguard_heartbeat_timestamp_seconds,interface=wg0 value=1725551118
+tunnelguard_shutdown_clean value=1
+`}
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	failing, _ := captureHttp(t, http.StatusBadRequest)
+	sink, _ = BuildMetricsSink(MetricsSinkConfig{Type: sinkInfluxDb, Address: failing.URL})
+	if err := sink.Push([]byte(testExposition)); err == nil {
+		t.Error("expected error for bad request")
+	}
+}
+
+func TestStatsdSink(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	sink, err := BuildMetricsSink(MetricsSinkConfig{Type: sinkStatsd, Address: conn.LocalAddr().String(), Prefix: "site1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	receive := func() string {
+		buf := make([]byte, 65536)
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(buf[:n])
+	}
+
+	if err := sink.Push([]byte(testExposition)); err != nil {
+		t.Fatal(err)
+	}
+	want := `site1.tunnelguard_peers_resets_total.wg0.a_b_c_.home_office:3|c
+site1.tunnelguard_peers_resets_total.wg0.d:1|c
+site1.tunnelguard_heartbeat_timestamp_seconds.wg0:1725551118|g
+site1.tunnelguard_shutdown_clean:1|g
+`
+	if got := receive(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// counters are sent with their increase, unchanged counters are left out
+	if err := sink.Push([]byte(strings.Replace(testExposition, "home office\"} 3", "home office\"} 5", 1))); err != nil {
+		t.Fatal(err)
+	}
+	want = `site1.tunnelguard_peers_resets_total.wg0.a_b_c_.home_office:2|c
+site1.tunnelguard_heartbeat_timestamp_seconds.wg0:1725551118|g
+site1.tunnelguard_shutdown_clean:1|g
+`
+	if got := receive(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// the resets of the rendered metrics are typed as a counter
+	metrics.IncPeerResets("wg-statsd", "x", "")
+	tmpl, err := newMetricsTemplate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := metrics.Render(tmpl, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Push(buf.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	var got string
+	for got == "" {
+		for _, line := range strings.Split(receive(), "\n") {
+			if strings.HasPrefix(line, "site1.tunnelguard_peers_resets_total.wg-statsd.x:") {
+				got = line
+			}
+		}
+	}
+	if want := "site1.tunnelguard_peers_resets_total.wg-statsd.x:1|c"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestGraphiteSink(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	lines := make(chan []string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var received []string
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			received = append(received, scanner.Text())
+		}
+		lines <- received
+	}()
+
+	sink, err := BuildMetricsSink(MetricsSinkConfig{Type: sinkGraphite, Address: listener.Addr().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Push([]byte(testExposition)); err != nil {
+		t.Fatal(err)
+	}
+
+	got := <-lines
+	want := []string{
+		"tunnelguard_peers_resets_total;interface=wg0;pub_key=a/b+c=;nice_name=home_office 3",
+		"tunnelguard_peers_resets_total;interface=wg0;pub_key=d 1",
+		"tunnelguard_heartbeat_timestamp_seconds;interface=wg0 1725551118",
+		"tunnelguard_shutdown_clean 1",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	for idx := range want {
+		// strip the timestamp
+		if line := got[idx][:strings.LastIndex(got[idx], " ")]; line != want[idx] {
+			t.Errorf("got %q, want %q", line, want[idx])
+		}
+	}
+}
+
+func TestMetricsSinkConfig_validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		conf    MetricsSinkConfig
+		wantErr bool
+	}{
+		{name: "pushgateway", conf: MetricsSinkConfig{Type: sinkPushgateway, Address: "http://localhost:9091"}},
+		{name: "statsd", conf: MetricsSinkConfig{Type: sinkStatsd, Address: "localhost:8125", IntervalSeconds: 10}},
+		{name: "unknown type", conf: MetricsSinkConfig{Type: "opentsdb", Address: "localhost:4242"}, wantErr: true},
+		{name: "missing address", conf: MetricsSinkConfig{Type: sinkGraphite}, wantErr: true},
+		{name: "influxdb without scheme", conf: MetricsSinkConfig{Type: sinkInfluxDb, Address: "localhost:8086"}, wantErr: true},
+		{name: "graphite without port", conf: MetricsSinkConfig{Type: sinkGraphite, Address: "localhost"}, wantErr: true},
+		{name: "negative interval", conf: MetricsSinkConfig{Type: sinkStatsd, Address: "localhost:8125", IntervalSeconds: -1}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.conf.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+type fakeSink struct {
+	pushes chan []byte
+	err    error
+}
+
+func (s *fakeSink) Push(exposition []byte) error {
+	s.pushes <- exposition
+	return s.err
+}
+
+func TestMetricsWriter_sinks(t *testing.T) {
+	writer, err := NewMetricsWriter("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	working := &fakeSink{pushes: make(chan []byte, 10)}
+	broken := &fakeSink{pushes: make(chan []byte, 10), err: io.ErrUnexpectedEOF}
+	writer.AddSink("test-working", working, 10*time.Millisecond)
+	writer.AddSink("test-broken", broken, time.Hour)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	writer.Run(ctx)
+
+	// the working sink is pushed to in its own interval
+	for range 2 {
+		select {
+		case <-working.pushes:
+		case <-time.After(5 * time.Second):
+			t.Fatal("no periodic push")
+		}
+	}
+	select {
+	case <-broken.pushes:
+		t.Fatal("unexpected push before interval")
+	default:
+	}
+
+	if err := writer.Flush(); err == nil || !strings.Contains(err.Error(), "test-broken") {
+		t.Errorf("Flush() error = %v", err)
+	}
+	cancel()
+
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+	if metrics.SinkPushes[sinkKey{Sink: "test-broken", Result: sinkPushFailure}] != 1 {
+		t.Errorf("expected a single failure, got %v", metrics.SinkPushes)
+	}
+	if metrics.SinkPushes[sinkKey{Sink: "test-working", Result: sinkPushSuccess}] < 3 {
+		t.Errorf("expected at least 3 successful pushes, got %v", metrics.SinkPushes)
+	}
+	if _, found := metrics.SinkLastSuccess["test-broken"]; found {
+		t.Error("expected no successful push of the broken sink")
+	}
+}
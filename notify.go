@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+const (
+	eventPeerReset       = "peer_reset"
+	eventPeerResetFailed = "peer_reset_failed"
+	eventTunnelStarted   = "tunnel_started"
+
+	defaultDedupWindowSeconds = 3600
+	defaultNotifyTimeout      = 10 * time.Second
+	// defaultNotifyQueueSize is the number of notifications that may wait for slow notifiers before events are dropped
+	defaultNotifyQueueSize = 100
+)
+
+var defaultNotificationTemplates = map[string]NotificationTemplate{
+	eventPeerReset: {
+		Title:   `Peer {{ .DisplayName }} reset`,
+		Message: `Reset peer {{ .DisplayName }} on {{ .Interface }}{{ if .Endpoint }} to endpoint {{ .Endpoint }}{{ end }}{{ if and .Action (ne .Action "reset_peer") }} using {{ .Action }}{{ end }} ({{ .Reason }}).`,
+	},
+	eventPeerResetFailed: {
+		Title:   `Reset of peer {{ .DisplayName }} failed`,
+		Message: `Could not reset peer {{ .DisplayName }} on {{ .Interface }}{{ if .Endpoint }} to endpoint {{ .Endpoint }}{{ end }}{{ if and .Action (ne .Action "reset_peer") }} using {{ .Action }}{{ end }}: {{ .Error }}`,
+	},
+	eventTunnelStarted: {
+		Title:   `Tunnel {{ .Interface }} started`,
+		Message: `Tunnel {{ .Interface }} appeared to be down and has been started ({{ .Reason }}).{{ if .Error }} Error: {{ .Error }}{{ end }}`,
+	},
+}
+
+// Event describes an action taken by tunnelguard.
+type Event struct {
+	Type      string    `json:"type"`
+	Interface string    `json:"interface"`
+	PublicKey string    `json:"pub_key,omitempty"`
+	NiceName  string    `json:"nice_name,omitempty"`
+	Endpoint  string    `json:"endpoint,omitempty"`
+	Action    string    `json:"action,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// DisplayName returns the nice name of the peer, falling back to its public key.
+func (e Event) DisplayName() string {
+	if len(e.NiceName) > 0 {
+		return e.NiceName
+	}
+	return e.PublicKey
+}
+
+// EventHandler consumes events emitted by tunnelguard. Handlers are invoked synchronously from the loop and must not
+// block.
+type EventHandler func(Event)
+
+// Notification is the rendered representation of an event that is sent by a Notifier.
+type Notification struct {
+	Title   string
+	Message string
+	Event   Event
+}
+
+type Notifier interface {
+	Name() string
+	Notify(ctx context.Context, notification Notification) error
+}
+
+type NotificationTemplate struct {
+	Title   string `json:"title"`
+	Message string `json:"message"`
+}
+
+type NotificationsConfig struct {
+	// DedupWindowSeconds suppresses repeated notifications of the same event for the same peer within the window.
+	DedupWindowSeconds int `json:"dedup_window_seconds"`
+	// Templates overrides the default templates, keyed by event type.
+	Templates map[string]NotificationTemplate `json:"templates"`
+
+	Webhooks []WebhookConfig `json:"webhooks"`
+	Ntfy     []NtfyConfig    `json:"ntfy"`
+	Gotify   []GotifyConfig  `json:"gotify"`
+	Smtp     []SmtpConfig    `json:"smtp"`
+}
+
+func (c *NotificationsConfig) IsEnabled() bool {
+	return len(c.Webhooks)+len(c.Ntfy)+len(c.Gotify)+len(c.Smtp) > 0
+}
+
+type compiledTemplate struct {
+	title   *template.Template
+	message *template.Template
+}
+
+// NotificationDispatcher renders events and sends them to all configured notifiers. Notifications are sent by a
+// worker, so slow notifiers do not delay the loops.
+type NotificationDispatcher struct {
+	notifiers   []Notifier
+	templates   map[string]compiledTemplate
+	dedupWindow time.Duration
+	timeout     time.Duration
+
+	mutex    sync.Mutex
+	lastSent map[string]time.Time
+
+	queue chan Notification
+	// pending counts the notifications that are queued or being sent
+	pending sync.WaitGroup
+}
+
+func NewNotificationDispatcher(conf NotificationsConfig, notifiers ...Notifier) (*NotificationDispatcher, error) {
+	if len(notifiers) == 0 {
+		return nil, errors.New("no notifiers provided")
+	}
+
+	if conf.DedupWindowSeconds < 0 {
+		return nil, fmt.Errorf("invalid dedup window %d", conf.DedupWindowSeconds)
+	}
+
+	dedupWindow := time.Duration(defaultDedupWindowSeconds) * time.Second
+	if conf.DedupWindowSeconds > 0 {
+		dedupWindow = time.Duration(conf.DedupWindowSeconds) * time.Second
+	}
+
+	templates := map[string]compiledTemplate{}
+	for event, defaultTmpl := range defaultNotificationTemplates {
+		tmpl := defaultTmpl
+		if override, found := conf.Templates[event]; found {
+			if len(override.Title) > 0 {
+				tmpl.Title = override.Title
+			}
+			if len(override.Message) > 0 {
+				tmpl.Message = override.Message
+			}
+		}
+
+		title, err := template.New(event + "_title").Parse(tmpl.Title)
+		if err != nil {
+			return nil, fmt.Errorf("invalid title template for %s: %w", event, err)
+		}
+		message, err := template.New(event + "_message").Parse(tmpl.Message)
+		if err != nil {
+			return nil, fmt.Errorf("invalid message template for %s: %w", event, err)
+		}
+		templates[event] = compiledTemplate{title: title, message: message}
+	}
+
+	for event := range conf.Templates {
+		if _, found := defaultNotificationTemplates[event]; !found {
+			return nil, fmt.Errorf("template for unknown event %q", event)
+		}
+	}
+
+	dispatcher := &NotificationDispatcher{
+		notifiers:   notifiers,
+		templates:   templates,
+		dedupWindow: dedupWindow,
+		timeout:     defaultNotifyTimeout,
+		lastSent:    map[string]time.Time{},
+		queue:       make(chan Notification, defaultNotifyQueueSize),
+	}
+	go dispatcher.work()
+	return dispatcher, nil
+}
+
+// BuildNotifiers returns all notifiers that are defined in the config.
+func BuildNotifiers(conf NotificationsConfig) ([]Notifier, error) {
+	var notifiers []Notifier
+	var errs error
+
+	for _, c := range conf.Webhooks {
+		notifier, err := NewWebhookNotifier(c)
+		errs = errors.Join(errs, err)
+		notifiers = append(notifiers, notifier)
+	}
+	for _, c := range conf.Ntfy {
+		notifier, err := NewNtfyNotifier(c)
+		errs = errors.Join(errs, err)
+		notifiers = append(notifiers, notifier)
+	}
+	for _, c := range conf.Gotify {
+		notifier, err := NewGotifyNotifier(c)
+		errs = errors.Join(errs, err)
+		notifiers = append(notifiers, notifier)
+	}
+	for _, c := range conf.Smtp {
+		notifier, err := NewSmtpNotifier(c)
+		errs = errors.Join(errs, err)
+		notifiers = append(notifiers, notifier)
+	}
+
+	if errs != nil {
+		return nil, errs
+	}
+	return notifiers, nil
+}
+
+// Dispatch renders the event and queues it for all notifiers unless the same event has been sent within the dedup
+// window. If the queue is full, the event is dropped. It satisfies the EventHandler signature.
+func (d *NotificationDispatcher) Dispatch(event Event) {
+	tmpl, found := d.templates[event.Type]
+	if !found {
+		slog.Warn("no template for event", "event", event.Type)
+		return
+	}
+
+	if d.isDuplicate(event) {
+		slog.Debug("suppressing duplicate notification", "event", event.Type, "interface", event.Interface, peerLogAttrs(event.PublicKey, event.NiceName))
+		metrics.IncNotifications("", event.Type, notificationSuppressed)
+		return
+	}
+
+	notification, err := renderNotification(tmpl, event)
+	if err != nil {
+		slog.Error("could not render notification", "event", event.Type, "err", err)
+		return
+	}
+
+	d.pending.Add(1)
+	select {
+	case d.queue <- notification:
+	default:
+		d.pending.Done()
+		slog.Warn("dropping notification, queue is full", "event", event.Type, "interface", event.Interface, peerLogAttrs(event.PublicKey, event.NiceName))
+		metrics.IncNotifications("", event.Type, notificationDropped)
+	}
+}
+
+// Flush waits until all queued notifications have been sent and reports whether that happened within the timeout.
+func (d *NotificationDispatcher) Flush(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		d.pending.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+func (d *NotificationDispatcher) work() {
+	for notification := range d.queue {
+		d.send(notification)
+		d.pending.Done()
+	}
+}
+
+func (d *NotificationDispatcher) send(notification Notification) {
+	for _, notifier := range d.notifiers {
+		d.sendTo(notifier, notification)
+	}
+}
+
+// sendTo sends the notification to a single notifier, each notifier gets the full timeout so a slow one does not
+// cut short the others.
+func (d *NotificationDispatcher) sendTo(notifier Notifier, notification Notification) {
+	event := notification.Event
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+
+	if err := notifier.Notify(ctx, notification); err != nil {
+		slog.Error("could not send notification", "notifier", notifier.Name(), "event", event.Type, "err", err)
+		metrics.IncNotifications(notifier.Name(), event.Type, notificationFailed)
+	} else {
+		metrics.IncNotifications(notifier.Name(), event.Type, notificationSent)
+	}
+}
+
+func (d *NotificationDispatcher) isDuplicate(event Event) bool {
+	key := strings.Join([]string{event.Type, event.Interface, event.PublicKey}, "|")
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if lastSent, found := d.lastSent[key]; found && event.Timestamp.Sub(lastSent) < d.dedupWindow {
+		return true
+	}
+	d.lastSent[key] = event.Timestamp
+	return false
+}
+
+func renderNotification(tmpl compiledTemplate, event Event) (Notification, error) {
+	var title, message bytes.Buffer
+	if err := tmpl.title.Execute(&title, event); err != nil {
+		return Notification{}, err
+	}
+	if err := tmpl.message.Execute(&message, event); err != nil {
+		return Notification{}, err
+	}
+
+	return Notification{
+		Title:   title.String(),
+		Message: message.String(),
+		Event:   event,
+	}, nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+type GotifyConfig struct {
+	Url      string `json:"url"`
+	Token    string `json:"token"`
+	Priority int    `json:"priority"`
+}
+
+// GotifyNotifier sends notifications to a Gotify server using an application token.
+type GotifyNotifier struct {
+	url      string
+	token    string
+	priority int
+	client   *http.Client
+}
+
+type gotifyMessage struct {
+	Title    string `json:"title"`
+	Message  string `json:"message"`
+	Priority int    `json:"priority"`
+}
+
+func NewGotifyNotifier(conf GotifyConfig) (*GotifyNotifier, error) {
+	if len(conf.Token) == 0 {
+		return nil, errors.New("empty gotify token provided")
+	}
+
+	messageUrl, err := url.JoinPath(conf.Url, "message")
+	if err != nil {
+		return nil, fmt.Errorf("invalid gotify url: %w", err)
+	}
+	if _, err := url.ParseRequestURI(messageUrl); err != nil {
+		return nil, fmt.Errorf("invalid gotify url: %w", err)
+	}
+
+	return &GotifyNotifier{
+		url:      messageUrl,
+		token:    conf.Token,
+		priority: conf.Priority,
+		client:   &http.Client{Timeout: defaultNotifyTimeout},
+	}, nil
+}
+
+func (n *GotifyNotifier) Name() string {
+	return "gotify"
+}
+
+func (n *GotifyNotifier) Notify(ctx context.Context, notification Notification) error {
+	payload, err := json.Marshal(gotifyMessage{
+		Title:    notification.Title,
+		Message:  notification.Message,
+		Priority: n.priority,
+	})
+	if err != nil {
+		return err
+	}
+
+	headers := map[string]string{
+		"Content-Type": "application/json",
+		"X-Gotify-Key": n.token,
+	}
+	return postNotification(ctx, n.client, n.url, headers, payload)
+}
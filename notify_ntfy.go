@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+type NtfyConfig struct {
+	Url      string   `json:"url"`
+	Topic    string   `json:"topic"`
+	Token    string   `json:"token"`
+	Priority int      `json:"priority"`
+	Tags     []string `json:"tags"`
+}
+
+// NtfyNotifier publishes notifications to a topic of a ntfy server, see https://docs.ntfy.sh/publish/
+type NtfyNotifier struct {
+	url      string
+	token    string
+	priority int
+	tags     []string
+	client   *http.Client
+}
+
+func NewNtfyNotifier(conf NtfyConfig) (*NtfyNotifier, error) {
+	if len(conf.Topic) == 0 {
+		return nil, errors.New("empty ntfy topic provided")
+	}
+
+	if conf.Priority < 0 || conf.Priority > 5 {
+		return nil, fmt.Errorf("invalid ntfy priority %d", conf.Priority)
+	}
+
+	topicUrl, err := url.JoinPath(conf.Url, conf.Topic)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ntfy url: %w", err)
+	}
+	if _, err := url.ParseRequestURI(topicUrl); err != nil {
+		return nil, fmt.Errorf("invalid ntfy url: %w", err)
+	}
+
+	return &NtfyNotifier{
+		url:      topicUrl,
+		token:    conf.Token,
+		priority: conf.Priority,
+		tags:     conf.Tags,
+		client:   &http.Client{Timeout: defaultNotifyTimeout},
+	}, nil
+}
+
+func (n *NtfyNotifier) Name() string {
+	return "ntfy"
+}
+
+func (n *NtfyNotifier) Notify(ctx context.Context, notification Notification) error {
+	headers := map[string]string{
+		"Title": notification.Title,
+	}
+	if n.priority > 0 {
+		headers["Priority"] = strconv.Itoa(n.priority)
+	}
+	if len(n.tags) > 0 {
+		headers["Tags"] = strings.Join(n.tags, ",")
+	}
+	if len(n.token) > 0 {
+		headers["Authorization"] = "Bearer " + n.token
+	}
+
+	return postNotification(ctx, n.client, n.url, headers, []byte(notification.Message))
+}
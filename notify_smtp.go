@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type SmtpConfig struct {
+	Host     string   `json:"host"`
+	Port     int      `json:"port"`
+	Username string   `json:"username"`
+	Password string   `json:"password"`
+	From     string   `json:"from"`
+	To       []string `json:"to"`
+}
+
+// SmtpNotifier sends notifications as plain text mails. STARTTLS is used if the server supports it.
+type SmtpNotifier struct {
+	host    string
+	address string
+	auth    smtp.Auth
+	from    string
+	to      []string
+}
+
+func NewSmtpNotifier(conf SmtpConfig) (*SmtpNotifier, error) {
+	if len(conf.Host) == 0 {
+		return nil, errors.New("empty smtp host provided")
+	}
+
+	if len(conf.From) == 0 || len(conf.To) == 0 {
+		return nil, errors.New("smtp sender and recipients must be provided")
+	}
+
+	port := conf.Port
+	if port == 0 {
+		port = 587
+	}
+
+	var auth smtp.Auth
+	if len(conf.Username) > 0 {
+		auth = smtp.PlainAuth("", conf.Username, conf.Password, conf.Host)
+	}
+
+	return &SmtpNotifier{
+		host:    conf.Host,
+		address: net.JoinHostPort(conf.Host, strconv.Itoa(port)),
+		auth:    auth,
+		from:    conf.From,
+		to:      conf.To,
+	}, nil
+}
+
+func (n *SmtpNotifier) Name() string {
+	return "smtp"
+}
+
+func (n *SmtpNotifier) Notify(ctx context.Context, notification Notification) error {
+	dialer := net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "tcp", n.address)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			_ = conn.Close()
+			return err
+		}
+	}
+
+	client, err := smtp.NewClient(conn, n.host)
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: n.host, MinVersion: tls.VersionTLS12}); err != nil {
+			return fmt.Errorf("starttls failed: %w", err)
+		}
+	}
+
+	if n.auth != nil {
+		if err := client.Auth(n.auth); err != nil {
+			return fmt.Errorf("authentication failed: %w", err)
+		}
+	}
+
+	if err := client.Mail(n.from); err != nil {
+		return err
+	}
+	for _, rcpt := range n.to {
+		if err := client.Rcpt(rcpt); err != nil {
+			return err
+		}
+	}
+
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := writer.Write(n.buildMessage(notification)); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
+
+func (n *SmtpNotifier) buildMessage(notification Notification) []byte {
+	var sb strings.Builder
+	sb.WriteString("From: " + n.from + "\r\n")
+	sb.WriteString("To: " + strings.Join(n.to, ", ") + "\r\n")
+	sb.WriteString("Subject: " + sanitizeHeader(notification.Title) + "\r\n")
+	sb.WriteString("Date: " + notification.Event.Timestamp.Format(time.RFC1123Z) + "\r\n")
+	sb.WriteString("MIME-Version: 1.0\r\n")
+	sb.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	sb.WriteString("\r\n")
+	sb.WriteString(strings.ReplaceAll(notification.Message, "\n", "\r\n"))
+	sb.WriteString("\r\n")
+	return []byte(sb.String())
+}
+
+func sanitizeHeader(value string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(value)
+}
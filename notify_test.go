@@ -0,0 +1,424 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type capturedRequest struct {
+	Path    string
+	Headers http.Header
+	Body    string
+}
+
+func newCapturingServer(t *testing.T) (*httptest.Server, chan capturedRequest) {
+	t.Helper()
+
+	requests := make(chan capturedRequest, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- capturedRequest{Path: r.URL.Path, Headers: r.Header, Body: string(body)}
+	}))
+	t.Cleanup(server.Close)
+
+	return server, requests
+}
+
+func testNotification() Notification {
+	return Notification{
+		Title:   "Peer Home Router reset",
+		Message: "Reset peer Home Router",
+		Event: Event{
+			Type:      eventPeerReset,
+			Interface: "wg0",
+			PublicKey: "pub",
+			NiceName:  "Home Router",
+			Timestamp: time.Now(),
+		},
+	}
+}
+
+func TestWebhookNotifier_Notify(t *testing.T) {
+	server, requests := newCapturingServer(t)
+	notifier, err := NewWebhookNotifier(WebhookConfig{Url: server.URL + "/hook", Headers: map[string]string{"X-Token": "secret"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := notifier.Notify(context.Background(), testNotification()); err != nil {
+		t.Fatalf("Notify() error = %v", err)
+	}
+
+	req := <-requests
+	var payload webhookPayload
+	if err := json.Unmarshal([]byte(req.Body), &payload); err != nil {
+		t.Fatalf("could not parse payload: %v", err)
+	}
+	if payload.Title != "Peer Home Router reset" || payload.Event.NiceName != "Home Router" || payload.Event.Type != eventPeerReset {
+		t.Errorf("unexpected payload %+v", payload)
+	}
+	if req.Headers.Get("X-Token") != "secret" || req.Headers.Get("Content-Type") != "application/json" {
+		t.Errorf("unexpected headers %v", req.Headers)
+	}
+}
+
+func TestNtfyNotifier_Notify(t *testing.T) {
+	server, requests := newCapturingServer(t)
+	notifier, err := NewNtfyNotifier(NtfyConfig{Url: server.URL, Topic: "tunnelguard", Token: "tk", Priority: 4, Tags: []string{"warning"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := notifier.Notify(context.Background(), testNotification()); err != nil {
+		t.Fatalf("Notify() error = %v", err)
+	}
+
+	req := <-requests
+	if req.Path != "/tunnelguard" {
+		t.Errorf("path = %s, want /tunnelguard", req.Path)
+	}
+	if req.Body != "Reset peer Home Router" {
+		t.Errorf("body = %q", req.Body)
+	}
+	if req.Headers.Get("Title") != "Peer Home Router reset" || req.Headers.Get("Priority") != "4" || req.Headers.Get("Tags") != "warning" || req.Headers.Get("Authorization") != "Bearer tk" {
+		t.Errorf("unexpected headers %v", req.Headers)
+	}
+}
+
+func TestGotifyNotifier_Notify(t *testing.T) {
+	server, requests := newCapturingServer(t)
+	notifier, err := NewGotifyNotifier(GotifyConfig{Url: server.URL, Token: "app-token", Priority: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := notifier.Notify(context.Background(), testNotification()); err != nil {
+		t.Fatalf("Notify() error = %v", err)
+	}
+
+	req := <-requests
+	if req.Path != "/message" || req.Headers.Get("X-Gotify-Key") != "app-token" {
+		t.Errorf("unexpected request %v", req)
+	}
+	var msg gotifyMessage
+	if err := json.Unmarshal([]byte(req.Body), &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Title != "Peer Home Router reset" || msg.Priority != 8 {
+		t.Errorf("unexpected message %+v", msg)
+	}
+}
+
+func TestWebhookNotifier_errorStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	notifier, err := NewWebhookNotifier(WebhookConfig{Url: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := notifier.Notify(context.Background(), testNotification()); err == nil {
+		t.Error("Notify() expected error for non-2xx status")
+	}
+}
+
+// smtpStandIn is a minimal SMTP server that records the data of received mails.
+type smtpStandIn struct {
+	listener net.Listener
+	mutex    sync.Mutex
+	mails    []string
+}
+
+func newSmtpStandIn(t *testing.T) *smtpStandIn {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &smtpStandIn{listener: listener}
+	go server.serve()
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+	return server
+}
+
+func (s *smtpStandIn) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *smtpStandIn) handle(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	reply := func(line string) {
+		_, _ = conn.Write([]byte(line + "\r\n"))
+	}
+
+	reply("220 localhost ESMTP")
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(cmd, "DATA"):
+			reply("354 go ahead")
+			var data strings.Builder
+			for {
+				dataLine, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if dataLine == ".\r\n" {
+					break
+				}
+				data.WriteString(dataLine)
+			}
+			s.mutex.Lock()
+			s.mails = append(s.mails, data.String())
+			s.mutex.Unlock()
+			reply("250 ok")
+		case strings.HasPrefix(cmd, "QUIT"):
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+func TestSmtpNotifier_Notify(t *testing.T) {
+	server := newSmtpStandIn(t)
+	host, portStr, _ := net.SplitHostPort(server.listener.Addr().String())
+	port, _ := strconv.Atoi(portStr)
+
+	notifier, err := NewSmtpNotifier(SmtpConfig{Host: host, Port: port, From: "tunnelguard@example.com", To: []string{"ops@example.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := notifier.Notify(ctx, testNotification()); err != nil {
+		t.Fatalf("Notify() error = %v", err)
+	}
+
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	if len(server.mails) != 1 {
+		t.Fatalf("got %d mails, want 1", len(server.mails))
+	}
+	if !strings.Contains(server.mails[0], "Subject: Peer Home Router reset") || !strings.Contains(server.mails[0], "Reset peer Home Router") {
+		t.Errorf("unexpected mail %q", server.mails[0])
+	}
+}
+
+type recordingNotifier struct {
+	notifications []Notification
+	// ctxErrs holds the error of the context at the time of each notification
+	ctxErrs []error
+}
+
+func (r *recordingNotifier) Name() string {
+	return "recording"
+}
+
+func (r *recordingNotifier) Notify(ctx context.Context, notification Notification) error {
+	r.notifications = append(r.notifications, notification)
+	r.ctxErrs = append(r.ctxErrs, ctx.Err())
+	return nil
+}
+
+func TestNotificationDispatcher_Dispatch(t *testing.T) {
+	recorder := &recordingNotifier{}
+	conf := NotificationsConfig{
+		DedupWindowSeconds: 60,
+		Templates: map[string]NotificationTemplate{
+			eventPeerReset: {Title: "{{ .Interface }}: {{ .DisplayName }} reset"},
+		},
+	}
+	dispatcher, err := NewNotificationDispatcher(conf, recorder)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	event := Event{Type: eventPeerReset, Interface: "wg0", PublicKey: "pub", NiceName: "Home Router", Endpoint: "host:51820", Timestamp: now}
+	dispatcher.Dispatch(event)
+
+	// duplicate within the window
+	event.Timestamp = now.Add(30 * time.Second)
+	dispatcher.Dispatch(event)
+
+	// different event for the same peer
+	dispatcher.Dispatch(Event{Type: eventPeerResetFailed, Interface: "wg0", PublicKey: "pub", Error: "boom", Timestamp: now})
+
+	// same event after the window
+	event.Timestamp = now.Add(2 * time.Minute)
+	dispatcher.Dispatch(event)
+
+	if !dispatcher.Flush(5 * time.Second) {
+		t.Fatal("notifications were not sent in time")
+	}
+	if len(recorder.notifications) != 3 {
+		t.Fatalf("got %d notifications, want 3", len(recorder.notifications))
+	}
+	if got := recorder.notifications[0].Title; got != "wg0: Home Router reset" {
+		t.Errorf("title = %q", got)
+	}
+	if got := recorder.notifications[0].Message; got != "Reset peer Home Router on wg0 to endpoint host:51820 ()." {
+		t.Errorf("message = %q", got)
+	}
+	if got := recorder.notifications[1].Title; got != "Reset of peer pub failed" {
+		t.Errorf("title = %q", got)
+	}
+}
+
+// blockingNotifier blocks until release is closed.
+type blockingNotifier struct {
+	release chan struct{}
+}
+
+func (b *blockingNotifier) Name() string {
+	return "blocking"
+}
+
+func (b *blockingNotifier) Notify(ctx context.Context, _ Notification) error {
+	select {
+	case <-b.release:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func TestNotificationDispatcher_queueFull(t *testing.T) {
+	notifier := &blockingNotifier{release: make(chan struct{})}
+	dispatcher, err := NewNotificationDispatcher(NotificationsConfig{}, notifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	getCount := func(notifier string, status string) int64 {
+		metrics.mutex.Lock()
+		defer metrics.mutex.Unlock()
+		return metrics.Notifications[notificationKey{Notifier: notifier, Event: eventTunnelStarted, Status: status}]
+	}
+	dropped := getCount("", notificationDropped)
+
+	// dispatching must not wait for the notifier, one notification is in flight and the queue holds the rest
+	start := time.Now()
+	for idx := range defaultNotifyQueueSize + 3 {
+		dispatcher.Dispatch(Event{Type: eventTunnelStarted, Interface: fmt.Sprintf("wg-queue%d", idx), Timestamp: start})
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Dispatch() blocked for %v", elapsed)
+	}
+	if got := getCount("", notificationDropped) - dropped; got < 2 || got > 3 {
+		t.Errorf("dropped %d notifications, want 2 or 3", got)
+	}
+
+	sent := getCount(notifier.Name(), notificationSent)
+	close(notifier.release)
+	if !dispatcher.Flush(5 * time.Second) {
+		t.Fatal("notifications were not sent in time")
+	}
+	if got := getCount(notifier.Name(), notificationSent) - sent; got < defaultNotifyQueueSize {
+		t.Errorf("sent %d notifications, want at least %d", got, defaultNotifyQueueSize)
+	}
+}
+
+func TestNotificationDispatcher_timeoutPerNotifier(t *testing.T) {
+	slow := &blockingNotifier{release: make(chan struct{})}
+	recorder := &recordingNotifier{}
+	dispatcher, err := NewNotificationDispatcher(NotificationsConfig{}, slow, recorder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dispatcher.timeout = 50 * time.Millisecond
+
+	// the slow notifier uses up its timeout, the next one still gets a live context
+	dispatcher.Dispatch(Event{Type: eventTunnelStarted, Interface: "wg-timeout", Timestamp: time.Now()})
+	if !dispatcher.Flush(5 * time.Second) {
+		t.Fatal("notifications were not sent in time")
+	}
+	if len(recorder.ctxErrs) != 1 || recorder.ctxErrs[0] != nil {
+		t.Errorf("context errors = %v, want [<nil>]", recorder.ctxErrs)
+	}
+}
+
+func TestNewNotificationDispatcher_invalidTemplate(t *testing.T) {
+	conf := NotificationsConfig{
+		Templates: map[string]NotificationTemplate{
+			"unknown_event": {Title: "x"},
+		},
+	}
+	if _, err := NewNotificationDispatcher(conf, &recordingNotifier{}); err == nil {
+		t.Error("expected error for unknown event")
+	}
+
+	conf.Templates = map[string]NotificationTemplate{
+		eventPeerReset: {Title: "{{ .Broken "},
+	}
+	if _, err := NewNotificationDispatcher(conf, &recordingNotifier{}); err == nil {
+		t.Error("expected error for invalid template")
+	}
+}
+
+func TestTunnelguard_events(t *testing.T) {
+	driver := &fakeDriver{
+		peers:     []Peer{{PublicKey: "stale", HandshakeLastSeen: handshakeAgo(time.Hour)}},
+		endpoints: map[string]string{"stale": "host.example:51820"},
+	}
+
+	var events []Event
+	conf := InterfaceConfig{
+		Interface:               "wg-events",
+		HandshakeTimeoutSeconds: 180,
+		WaitSeconds:             30,
+		PublicKeyDict:           map[string]string{"stale": "Stale Peer"},
+	}
+	tg, err := NewTunnelguard(driver, nil, conf, WithEventHandler(func(event Event) {
+		events = append(events, event)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tg.conditionallyResetPeers()
+	driver.peersErr = io.EOF
+	tg.conditionallyResetPeers()
+
+	if len(events) != 2 {
+		t.Fatalf("got %d events, want 2: %v", len(events), events)
+	}
+	if events[0].Type != eventPeerReset || events[0].NiceName != "Stale Peer" || events[0].Endpoint != "host.example:51820" {
+		t.Errorf("unexpected reset event %+v", events[0])
+	}
+	if events[1].Type != eventTunnelStarted || events[1].Reason != reasonGetPeersFailed {
+		t.Errorf("unexpected tunnel event %+v", events[1])
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+type WebhookConfig struct {
+	Url     string            `json:"url"`
+	Headers map[string]string `json:"headers"`
+}
+
+// WebhookNotifier posts a JSON document containing the rendered notification and the event to an url.
+type WebhookNotifier struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+type webhookPayload struct {
+	Title   string `json:"title"`
+	Message string `json:"message"`
+	Event   Event  `json:"event"`
+}
+
+func NewWebhookNotifier(conf WebhookConfig) (*WebhookNotifier, error) {
+	if _, err := url.ParseRequestURI(conf.Url); err != nil {
+		return nil, fmt.Errorf("invalid webhook url: %w", err)
+	}
+
+	return &WebhookNotifier{
+		url:     conf.Url,
+		headers: conf.Headers,
+		client:  &http.Client{Timeout: defaultNotifyTimeout},
+	}, nil
+}
+
+func (n *WebhookNotifier) Name() string {
+	return "webhook"
+}
+
+func (n *WebhookNotifier) Notify(ctx context.Context, notification Notification) error {
+	payload, err := json.Marshal(webhookPayload{
+		Title:   notification.Title,
+		Message: notification.Message,
+		Event:   notification.Event,
+	})
+	if err != nil {
+		return err
+	}
+
+	headers := map[string]string{"Content-Type": "application/json"}
+	for key, val := range n.headers {
+		headers[key] = val
+	}
+
+	return postNotification(ctx, n.client, n.url, headers, payload)
+}
+
+// postNotification sends the body to the url and expects a 2xx response.
+func postNotification(ctx context.Context, client *http.Client, url string, headers map[string]string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	for key, val := range headers {
+		req.Header.Set(key, val)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.New("unexpected status code " + resp.Status)
+	}
+
+	return nil
+}
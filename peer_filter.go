@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"slices"
+)
+
+// PeerFilterConfig selects the peers that are monitored. Peers that are not monitored are never reset and do not
+// show up in reports, state transitions or notifications.
+type PeerFilterConfig struct {
+	// Include lists public keys and glob patterns of nice names of the peers to monitor. If empty, all peers are
+	// monitored.
+	Include []string `json:"include"`
+	// Exclude lists public keys and glob patterns of nice names of peers that are not monitored. It takes precedence
+	// over Include.
+	Exclude []string `json:"exclude"`
+	// ExportExcluded keeps exporting the latest handshake of peers that are not monitored.
+	ExportExcluded bool `json:"export_excluded"`
+}
+
+func (c *PeerFilterConfig) validate() error {
+	for _, pattern := range slices.Concat(c.Include, c.Exclude) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// merge returns the filter with the rules of the override added.
+func (c PeerFilterConfig) merge(override PeerFilterConfig) PeerFilterConfig {
+	return PeerFilterConfig{
+		Include:        slices.Concat(c.Include, override.Include),
+		Exclude:        slices.Concat(c.Exclude, override.Exclude),
+		ExportExcluded: c.ExportExcluded || override.ExportExcluded,
+	}
+}
+
+// matchesPeer returns whether the rule equals the public key or the pattern matches the nice name.
+func matchesPeer(rule, publicKey, niceName string) bool {
+	if rule == publicKey {
+		return true
+	}
+	if len(niceName) == 0 {
+		return false
+	}
+	matched, _ := path.Match(rule, niceName)
+	return matched
+}
+
+// isMonitored returns whether the peer is monitored according to its 'monitor' flag and the include and exclude
+// rules.
+func (t *Tunnelguard) isMonitored(publicKey string) bool {
+	if peer, found := t.peers[publicKey]; found && peer.Monitor != nil && !*peer.Monitor {
+		return false
+	}
+
+	niceName := t.niceNames[publicKey]
+	for _, rule := range t.filter.Exclude {
+		if matchesPeer(rule, publicKey, niceName) {
+			return false
+		}
+	}
+
+	if len(t.filter.Include) == 0 {
+		return true
+	}
+	for _, rule := range t.filter.Include {
+		if matchesPeer(rule, publicKey, niceName) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTunnelguard_isMonitored(t *testing.T) {
+	disabled := false
+	enabled := true
+	niceNames := map[string]string{"laptop": "Laptop Alice", "router": "Home Router", "phone": "Phone Bob"}
+
+	tests := []struct {
+		name   string
+		filter PeerFilterConfig
+		peers  map[string]PeerConfig
+		want   map[string]bool
+	}{
+		{
+			name: "no rules",
+			want: map[string]bool{"laptop": true, "router": true, "unnamed": true},
+		},
+		{
+			name:   "exclude by nice name glob",
+			filter: PeerFilterConfig{Exclude: []string{"Laptop *", "Phone*"}},
+			want:   map[string]bool{"laptop": false, "router": true, "phone": false, "unnamed": true},
+		},
+		{
+			name:   "include by public key and glob",
+			filter: PeerFilterConfig{Include: []string{"unnamed", "Home*"}},
+			want:   map[string]bool{"laptop": false, "router": true, "unnamed": true},
+		},
+		{
+			name:   "exclude takes precedence",
+			filter: PeerFilterConfig{Include: []string{"*"}, Exclude: []string{"router"}},
+			want:   map[string]bool{"laptop": true, "router": false},
+		},
+		{
+			name:  "monitor flag",
+			peers: map[string]PeerConfig{"laptop": {Monitor: &disabled}, "router": {Monitor: &enabled}},
+			want:  map[string]bool{"laptop": false, "router": true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tg := &Tunnelguard{niceNames: niceNames, peers: tt.peers, filter: tt.filter}
+			for publicKey, want := range tt.want {
+				if got := tg.isMonitored(publicKey); got != want {
+					t.Errorf("isMonitored(%s) = %v, want %v", publicKey, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestPeerFilterConfig_validate(t *testing.T) {
+	valid := PeerFilterConfig{Include: []string{"Home*", "HUB2HTmOU08ceEe2fQMpzXsBEJoxK+UjV+60rTFZfk8="}}
+	if err := valid.validate(); err != nil {
+		t.Errorf("validate() error = %v", err)
+	}
+
+	invalid := PeerFilterConfig{Exclude: []string{"Laptop ["}}
+	if err := invalid.validate(); err == nil {
+		t.Error("validate() expected error for invalid pattern")
+	}
+}
+
+func TestTunnelguard_conditionallyResetPeers_excluded(t *testing.T) {
+	driver := &fakeDriver{
+		peers: []Peer{
+			{PublicKey: "laptop", HandshakeLastSeen: handshakeAgo(time.Hour)},
+			{PublicKey: "router", HandshakeLastSeen: handshakeAgo(time.Hour)},
+		},
+		endpoints: map[string]string{"laptop": "laptop.example:51820", "router": "router.example:51820"},
+	}
+	conf := InterfaceConfig{
+		Interface:               "wg-filter",
+		HandshakeTimeoutSeconds: 180,
+		WaitSeconds:             30,
+		PublicKeyDict:           map[string]string{"laptop": "Laptop Alice"},
+		PeerFilter:              PeerFilterConfig{Exclude: []string{"Laptop*"}, ExportExcluded: true},
+	}
+	tg, err := NewTunnelguard(driver, nil, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report := tg.conditionallyResetPeers()
+	if !reflect.DeepEqual(driver.resets, []string{"router"}) {
+		t.Errorf("resets = %v, want [router]", driver.resets)
+	}
+	if len(report.Peers) != 1 || report.Peers[0].PublicKey != "router" {
+		t.Errorf("report contains excluded peer: %+v", report.Peers)
+	}
+	if _, found := tg.states["laptop"]; found {
+		t.Error("state of excluded peer is tracked")
+	}
+
+	metrics.mutex.Lock()
+	_, exported := metrics.LatestHandshakeTimestamp[peerKey{Interface: "wg-filter", PublicKey: "laptop"}]
+	metrics.mutex.Unlock()
+	if !exported {
+		t.Error("handshake of excluded peer has not been exported")
+	}
+}
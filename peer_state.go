@@ -0,0 +1,87 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+)
+
+const (
+	stateUnknown        = "unknown"
+	stateNeverConnected = "never_connected"
+	stateHealthy        = "healthy"
+	stateStale          = "stale"
+	stateResetting      = "resetting"
+)
+
+var peerStates = []string{stateUnknown, stateNeverConnected, stateHealthy, stateStale, stateResetting}
+
+// PeerTransition describes the change of a peer's health state.
+type PeerTransition struct {
+	Interface string    `json:"interface"`
+	PublicKey string    `json:"pub_key"`
+	NiceName  string    `json:"nice_name,omitempty"`
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// TransitionHandler consumes state transitions of peers. Handlers are invoked synchronously from the loop and
+// should return quickly.
+type TransitionHandler func(PeerTransition)
+
+type peerState struct {
+	State string
+	Since time.Time
+}
+
+// stateForDecision maps the decision taken for a peer to its health state.
+func stateForDecision(decision string) string {
+	switch decision {
+	case decisionHealthy:
+		return stateHealthy
+	case decisionNeverConnected:
+		return stateNeverConnected
+	case decisionReset, decisionResetFailed, decisionDryRunReset, decisionSkippedBackoff, decisionSkippedRestartWindow:
+		return stateResetting
+	default:
+		return stateStale
+	}
+}
+
+// updatePeerState records the new state of a peer and notifies all handlers if the state changed.
+func (t *Tunnelguard) updatePeerState(publicKey string, state string) {
+	current, found := t.states[publicKey]
+	if found && current.State == state {
+		return
+	}
+
+	from := stateUnknown
+	if found {
+		from = current.State
+	}
+
+	now := time.Now()
+	t.states[publicKey] = &peerState{
+		State: state,
+		Since: now,
+	}
+
+	transition := PeerTransition{
+		Interface: t.iface,
+		PublicKey: publicKey,
+		NiceName:  t.niceNames[publicKey],
+		From:      from,
+		To:        state,
+		Timestamp: now,
+	}
+	metrics.SetPeerState(t.iface, publicKey, t.niceNames[publicKey], state, now)
+
+	for _, handler := range t.transitionHandlers {
+		handler(transition)
+	}
+}
+
+// LogTransition is a TransitionHandler that logs each transition.
+func LogTransition(transition PeerTransition) {
+	slog.Info("peer changed state", "interface", transition.Interface, "from", transition.From, "to", transition.To, peerLogAttrs(transition.PublicKey, transition.NiceName))
+}
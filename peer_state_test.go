@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+)
+
+func TestTunnelguard_transitions(t *testing.T) {
+	driver := &fakeDriver{
+		peers:     []Peer{{PublicKey: "state"}},
+		endpoints: map[string]string{"state": "1.1.1.1:51820"},
+	}
+
+	var transitions []PeerTransition
+	handler := func(transition PeerTransition) {
+		transitions = append(transitions, transition)
+	}
+
+	conf := InterfaceConfig{
+		Interface:               "wg-state",
+		HandshakeTimeoutSeconds: 180,
+		WaitSeconds:             30,
+		PublicKeyDict:           map[string]string{"state": "State Peer"},
+	}
+	tg, err := NewTunnelguard(driver, nil, conf, WithTransitionHandler(handler))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		handshake *time.Time
+		endpoint  string
+	}{
+		{handshake: nil},
+		{handshake: handshakeAgo(time.Minute)},
+		{handshake: handshakeAgo(time.Minute)},
+		// static endpoints are not reset
+		{handshake: handshakeAgo(time.Hour)},
+		{handshake: handshakeAgo(time.Hour), endpoint: "host.example:51820"},
+		{handshake: handshakeAgo(time.Second)},
+	}
+	for _, step := range steps {
+		driver.peers[0].HandshakeLastSeen = step.handshake
+		if step.endpoint != "" {
+			driver.endpoints["state"] = step.endpoint
+		}
+		tg.conditionallyResetPeers()
+	}
+
+	want := []string{
+		stateUnknown + ">" + stateNeverConnected,
+		stateNeverConnected + ">" + stateHealthy,
+		stateHealthy + ">" + stateStale,
+		stateStale + ">" + stateResetting,
+		stateResetting + ">" + stateHealthy,
+	}
+	if len(transitions) != len(want) {
+		t.Fatalf("got %d transitions %v, want %d", len(transitions), transitions, len(want))
+	}
+	for idx, transition := range transitions {
+		if got := transition.From + ">" + transition.To; got != want[idx] {
+			t.Errorf("transition %d = %s, want %s", idx, got, want[idx])
+		}
+		if transition.NiceName != "State Peer" || transition.Interface != "wg-state" || transition.Timestamp.IsZero() {
+			t.Errorf("transition %d incomplete: %v", idx, transition)
+		}
+	}
+
+	tmpl := template.Must(newMetricsTemplate())
+	var buf bytes.Buffer
+	if err := metrics.Render(tmpl, &buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		`tunnelguard_peers_state{interface="wg-state",pub_key="state",nice_name="State Peer",state="healthy"} 1`,
+		`tunnelguard_peers_state{interface="wg-state",pub_key="state",nice_name="State Peer",state="stale"} 0`,
+		`tunnelguard_peers_last_state_change_timestamp_seconds{interface="wg-state",pub_key="state",nice_name="State Peer"}`,
+	} {
+		if !strings.Contains(buf.String(), line) {
+			t.Errorf("metrics do not contain %q", line)
+		}
+	}
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"slices"
+	"time"
+)
+
+const (
+	probeIcmp = "icmp"
+	probeTcp  = "tcp"
+	probeUdp  = "udp"
+
+	defaultProbeIntervalSeconds  = 30
+	defaultProbeTimeoutSeconds   = 5
+	defaultProbeFailureThreshold = 3
+
+	icmpv4EchoRequest = 8
+	icmpv4EchoReply   = 0
+	icmpv6EchoRequest = 128
+	icmpv6EchoReply   = 129
+)
+
+// ProbeConfig defines an active reachability check of a target inside the tunnel.
+type ProbeConfig struct {
+	// Type is one of icmp, tcp or udp.
+	Type string `json:"type"`
+	// Target is an address for icmp probes and an address with port for tcp and udp probes.
+	Target           string `json:"target"`
+	IntervalSeconds  int    `json:"interval_seconds"`
+	TimeoutSeconds   int    `json:"timeout_seconds"`
+	FailureThreshold int    `json:"failure_threshold"`
+}
+
+func (c *ProbeConfig) validate() error {
+	switch c.Type {
+	case probeIcmp:
+		if _, err := netip.ParseAddr(c.Target); err != nil {
+			return fmt.Errorf("invalid icmp target %q: %w", c.Target, err)
+		}
+	case probeTcp, probeUdp:
+		if _, err := netip.ParseAddrPort(c.Target); err != nil {
+			return fmt.Errorf("invalid %s target %q: %w", c.Type, c.Target, err)
+		}
+	default:
+		return fmt.Errorf("unknown probe type %q", c.Type)
+	}
+
+	if c.IntervalSeconds < 0 {
+		return fmt.Errorf("invalid interval %d", c.IntervalSeconds)
+	}
+	if c.TimeoutSeconds < 0 {
+		return fmt.Errorf("invalid timeout %d", c.TimeoutSeconds)
+	}
+	if c.FailureThreshold < 0 {
+		return fmt.Errorf("invalid failure threshold %d", c.FailureThreshold)
+	}
+	return nil
+}
+
+// targetAddr returns the address of the target.
+func (c *ProbeConfig) targetAddr() (netip.Addr, error) {
+	if c.Type == probeIcmp {
+		return netip.ParseAddr(c.Target)
+	}
+	addrPort, err := netip.ParseAddrPort(c.Target)
+	return addrPort.Addr(), err
+}
+
+// validateProbeTargets checks that the target of each probe lies inside the AllowedIPs of its peer in the WireGuard
+// config file. Otherwise, the probe would be routed outside the tunnel and could not detect a broken tunnel.
+func validateProbeTargets(peers map[string]PeerConfig, configFile string) error {
+	var config *WgQuickConfig
+	var errs error
+	for publicKey, peer := range peers {
+		if peer.Probe == nil {
+			continue
+		}
+		if config == nil {
+			var err error
+			if config, _, err = parseWgQuickConfig(configFile); err != nil {
+				return err
+			}
+		}
+
+		target, err := peer.Probe.targetAddr()
+		if err != nil {
+			errs = errors.Join(errs, fmt.Errorf("peer %s: invalid probe target %q: %w", publicKey, peer.Probe.Target, err))
+			continue
+		}
+		wgPeer, err := config.findPeer(publicKey)
+		if err != nil {
+			errs = errors.Join(errs, fmt.Errorf("peer %s: %w in %s", publicKey, err, configFile))
+			continue
+		}
+		if !slices.ContainsFunc(wgPeer.AllowedIPs, func(prefix netip.Prefix) bool { return prefix.Contains(target.Unmap()) }) {
+			errs = errors.Join(errs, fmt.Errorf("peer %s: probe target %s is not inside the peer's AllowedIPs", publicKey, peer.Probe.Target))
+		}
+	}
+	return errs
+}
+
+// ReachabilityChecker checks whether a target inside the tunnel can be reached and returns the round-trip time.
+type ReachabilityChecker interface {
+	Check(ctx context.Context, target string) (time.Duration, error)
+}
+
+func NewReachabilityChecker(probeType string) (ReachabilityChecker, error) {
+	switch probeType {
+	case probeIcmp:
+		return &IcmpChecker{}, nil
+	case probeTcp:
+		return &TcpChecker{}, nil
+	case probeUdp:
+		return &UdpChecker{}, nil
+	default:
+		return nil, fmt.Errorf("unknown probe type %q", probeType)
+	}
+}
+
+// TcpChecker succeeds if a TCP connection to the target can be established.
+type TcpChecker struct{}
+
+func (c *TcpChecker) Check(ctx context.Context, target string) (time.Duration, error) {
+	var dialer net.Dialer
+	start := time.Now()
+	conn, err := dialer.DialContext(ctx, "tcp", target)
+	if err != nil {
+		return 0, err
+	}
+	rtt := time.Since(start)
+	_ = conn.Close()
+	return rtt, nil
+}
+
+// UdpChecker sends a random payload to the target and succeeds if the same payload is echoed back.
+type UdpChecker struct{}
+
+func (c *UdpChecker) Check(ctx context.Context, target string) (time.Duration, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", target)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	payload := make([]byte, 16)
+	if _, err := rand.Read(payload); err != nil {
+		return 0, err
+	}
+
+	start := time.Now()
+	if _, err := conn.Write(payload); err != nil {
+		return 0, err
+	}
+
+	buf := make([]byte, 512)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return 0, err
+		}
+		if bytes.Equal(buf[:n], payload) {
+			return time.Since(start), nil
+		}
+	}
+}
+
+// IcmpChecker sends an ICMP echo request to the target and waits for the matching reply. It needs a raw socket and
+// therefore CAP_NET_RAW.
+type IcmpChecker struct {
+	seq uint16
+}
+
+func (c *IcmpChecker) Check(ctx context.Context, target string) (time.Duration, error) {
+	addr, err := netip.ParseAddr(target)
+	if err != nil {
+		return 0, err
+	}
+	addr = addr.Unmap()
+
+	network, requestType, replyType := "ip4:icmp", icmpv4EchoRequest, icmpv4EchoReply
+	if addr.Is6() {
+		network, requestType, replyType = "ip6:ipv6-icmp", icmpv6EchoRequest, icmpv6EchoReply
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, addr.String())
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	c.seq++
+	id := uint16(os.Getpid() & 0xffff)
+	request := buildIcmpEcho(byte(requestType), id, c.seq, []byte("tunnelguard"))
+
+	start := time.Now()
+	if _, err := conn.Write(request); err != nil {
+		return 0, err
+	}
+
+	packetConn, ok := conn.(net.PacketConn)
+	if !ok {
+		return 0, errors.New("unexpected connection type")
+	}
+
+	buf := make([]byte, 1500)
+	for {
+		// ReadFrom strips the IPv4 header that is included when reading from raw sockets
+		n, _, err := packetConn.ReadFrom(buf)
+		if err != nil {
+			return 0, err
+		}
+		if isIcmpEchoReply(buf[:n], byte(replyType), id, c.seq) {
+			return time.Since(start), nil
+		}
+	}
+}
+
+// buildIcmpEcho returns an ICMP echo message. The checksum is only required for ICMPv4, the kernel computes it for
+// ICMPv6 but it does not hurt either.
+func buildIcmpEcho(msgType byte, id, seq uint16, payload []byte) []byte {
+	msg := make([]byte, 8+len(payload))
+	msg[0] = msgType
+	binary.BigEndian.PutUint16(msg[4:], id)
+	binary.BigEndian.PutUint16(msg[6:], seq)
+	copy(msg[8:], payload)
+	binary.BigEndian.PutUint16(msg[2:], icmpChecksum(msg))
+	return msg
+}
+
+func isIcmpEchoReply(msg []byte, replyType byte, id, seq uint16) bool {
+	if len(msg) < 8 || msg[0] != replyType {
+		return false
+	}
+	return binary.BigEndian.Uint16(msg[4:]) == id && binary.BigEndian.Uint16(msg[6:]) == seq
+}
+
+func icmpChecksum(msg []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(msg); i += 2 {
+		sum += uint32(msg[i])<<8 | uint32(msg[i+1])
+	}
+	if len(msg)%2 == 1 {
+		sum += uint32(msg[len(msg)-1]) << 8
+	}
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
+
+// peerProbe tracks the probe results of a single peer.
+type peerProbe struct {
+	conf                ProbeConfig
+	checker             ReachabilityChecker
+	target              string
+	interval            time.Duration
+	timeout             time.Duration
+	failureThreshold    int
+	lastRun             time.Time
+	consecutiveFailures int
+}
+
+func newPeerProbe(conf ProbeConfig) (*peerProbe, error) {
+	checker, err := NewReachabilityChecker(conf.Type)
+	if err != nil {
+		return nil, err
+	}
+
+	probe := &peerProbe{
+		conf:             conf,
+		checker:          checker,
+		target:           conf.Target,
+		interval:         defaultProbeIntervalSeconds * time.Second,
+		timeout:          defaultProbeTimeoutSeconds * time.Second,
+		failureThreshold: defaultProbeFailureThreshold,
+	}
+	if conf.IntervalSeconds > 0 {
+		probe.interval = time.Duration(conf.IntervalSeconds) * time.Second
+	}
+	if conf.TimeoutSeconds > 0 {
+		probe.timeout = time.Duration(conf.TimeoutSeconds) * time.Second
+	}
+	if conf.FailureThreshold > 0 {
+		probe.failureThreshold = conf.FailureThreshold
+	}
+	return probe, nil
+}
+
+// untilDue returns the duration until the probe has to run again.
+func (p *peerProbe) untilDue() time.Duration {
+	if p.lastRun.IsZero() {
+		return 0
+	}
+	return max(0, p.interval-time.Since(p.lastRun))
+}
+
+// failing reports whether the probe failed often enough to consider the peer stale.
+func (p *peerProbe) failing() bool {
+	return p.consecutiveFailures >= p.failureThreshold
+}
+
+// run checks the target and records the result.
+func (p *peerProbe) run() (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+
+	p.lastRun = time.Now()
+	rtt, err := p.checker.Check(ctx, p.target)
+	if err != nil {
+		p.consecutiveFailures++
+		return 0, err
+	}
+	p.consecutiveFailures = 0
+	return rtt, nil
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestProbeConfig_validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		conf    ProbeConfig
+		wantErr bool
+	}{
+		{
+			name: "icmp",
+			conf: ProbeConfig{Type: probeIcmp, Target: "10.0.0.1"},
+		},
+		{
+			name: "icmp ipv6",
+			conf: ProbeConfig{Type: probeIcmp, Target: "fd00::1"},
+		},
+		{
+			name:    "icmp with port",
+			conf:    ProbeConfig{Type: probeIcmp, Target: "10.0.0.1:22"},
+			wantErr: true,
+		},
+		{
+			name: "tcp",
+			conf: ProbeConfig{Type: probeTcp, Target: "10.0.0.1:22", IntervalSeconds: 10},
+		},
+		{
+			name:    "tcp without port",
+			conf:    ProbeConfig{Type: probeTcp, Target: "10.0.0.1"},
+			wantErr: true,
+		},
+		{
+			name:    "udp with hostname",
+			conf:    ProbeConfig{Type: probeUdp, Target: "host.example:7"},
+			wantErr: true,
+		},
+		{
+			name:    "unknown type",
+			conf:    ProbeConfig{Type: "http", Target: "10.0.0.1:80"},
+			wantErr: true,
+		},
+		{
+			name:    "negative interval",
+			conf:    ProbeConfig{Type: probeTcp, Target: "10.0.0.1:22", IntervalSeconds: -1},
+			wantErr: true,
+		},
+		{
+			name:    "negative threshold",
+			conf:    ProbeConfig{Type: probeTcp, Target: "10.0.0.1:22", FailureThreshold: -1},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.conf.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_validateProbeTargets(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "wg0.conf")
+	wgConfig := `[Interface]
+PrivateKey = yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk=
+
+[Peer]
+PublicKey = site
+AllowedIPs = 10.0.0.0/24, fd00::/64
+`
+	if err := os.WriteFile(configFile, []byte(wgConfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		peers   map[string]PeerConfig
+		wantErr bool
+	}{
+		{name: "no probes", peers: map[string]PeerConfig{"unknown": {}}},
+		{name: "icmp inside", peers: map[string]PeerConfig{"site": {Probe: &ProbeConfig{Type: probeIcmp, Target: "10.0.0.1"}}}},
+		{name: "tcp inside v6", peers: map[string]PeerConfig{"site": {Probe: &ProbeConfig{Type: probeTcp, Target: "[fd00::1]:22"}}}},
+		{name: "outside", peers: map[string]PeerConfig{"site": {Probe: &ProbeConfig{Type: probeTcp, Target: "192.0.2.1:22"}}}, wantErr: true},
+		{name: "unknown peer", peers: map[string]PeerConfig{"unknown": {Probe: &ProbeConfig{Type: probeIcmp, Target: "10.0.0.1"}}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateProbeTargets(tt.peers, configFile); (err != nil) != tt.wantErr {
+				t.Errorf("validateProbeTargets() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	if err := validateProbeTargets(map[string]PeerConfig{"site": {Probe: &ProbeConfig{Type: probeIcmp, Target: "10.0.0.1"}}}, filepath.Join(t.TempDir(), "missing.conf")); err == nil {
+		t.Error("expected error for missing config file")
+	}
+}
+
+// closedTcpAddress returns an address that refuses connections.
+func closedTcpAddress(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	_ = listener.Close()
+	return addr
+}
+
+func TestTcpChecker_Check(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.Close()
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	checker := &TcpChecker{}
+	if _, err := checker.Check(ctx, listener.Addr().String()); err != nil {
+		t.Errorf("Check() open port error = %v", err)
+	}
+	if _, err := checker.Check(ctx, closedTcpAddress(t)); err == nil {
+		t.Error("Check() closed port expected error")
+	}
+}
+
+func TestUdpChecker_Check(t *testing.T) {
+	echo, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := echo.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			_, _ = echo.WriteTo(buf[:n], addr)
+		}
+	}()
+
+	silent, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+
+	checker := &UdpChecker{}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if _, err := checker.Check(ctx, echo.LocalAddr().String()); err != nil {
+		t.Errorf("Check() echo error = %v", err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if _, err := checker.Check(ctx, silent.LocalAddr().String()); err == nil {
+		t.Error("Check() silent target expected error")
+	}
+}
+
+func Test_buildIcmpEcho(t *testing.T) {
+	msg := buildIcmpEcho(icmpv4EchoRequest, 0x1234, 7, []byte("tunnelguard"))
+	if icmpChecksum(msg) != 0 {
+		t.Errorf("checksum of message does not verify")
+	}
+
+	reply := append([]byte{}, msg...)
+	reply[0] = icmpv4EchoReply
+	if !isIcmpEchoReply(reply, icmpv4EchoReply, 0x1234, 7) {
+		t.Errorf("isIcmpEchoReply() = false, want true")
+	}
+	if isIcmpEchoReply(reply, icmpv4EchoReply, 0x1234, 8) {
+		t.Errorf("isIcmpEchoReply() matched wrong sequence")
+	}
+	if isIcmpEchoReply(msg, icmpv4EchoReply, 0x1234, 7) {
+		t.Errorf("isIcmpEchoReply() matched echo request")
+	}
+}
+
+func TestTunnelguard_probeFailure(t *testing.T) {
+	driver := &fakeDriver{
+		peers:     []Peer{{PublicKey: "probed", HandshakeLastSeen: handshakeAgo(10 * time.Second)}},
+		endpoints: map[string]string{"probed": "host.example:51820"},
+	}
+
+	conf := InterfaceConfig{
+		Interface:               "wg-probe",
+		HandshakeTimeoutSeconds: 180,
+		WaitSeconds:             30,
+		ResetBackoffMaxSeconds:  1800,
+		Peers: map[string]PeerConfig{
+			"probed": {Probe: &ProbeConfig{Type: probeTcp, Target: closedTcpAddress(t), TimeoutSeconds: 1, FailureThreshold: 2}},
+		},
+	}
+	tg, err := NewTunnelguard(driver, nil, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report := tg.conditionallyResetPeers()
+	if got := report.Peers[0].Decision; got != decisionHealthy {
+		t.Fatalf("first failure: decision = %s, want %s", got, decisionHealthy)
+	}
+	if report.NextCheck > defaultProbeIntervalSeconds*time.Second {
+		t.Errorf("NextCheck = %v, want at most the probe interval", report.NextCheck)
+	}
+
+	tg.probes["probed"].lastRun = time.Time{}
+	report = tg.conditionallyResetPeers()
+	if got := report.Peers[0]; got.Decision != decisionReset || got.Reason != reasonProbeFailed {
+		t.Fatalf("second failure: decision = %s (%s), want %s (%s)", got.Decision, got.Reason, decisionReset, reasonProbeFailed)
+	}
+	if len(driver.resets) != 1 {
+		t.Errorf("got %d resets, want 1", len(driver.resets))
+	}
+
+	// a new handshake does not clear the backoff while the probe keeps failing
+	driver.peers[0].HandshakeLastSeen = handshakeAgo(time.Second)
+	tg.probes["probed"].lastRun = time.Time{}
+	report = tg.conditionallyResetPeers()
+	if got := report.Peers[0].Decision; got != decisionSkippedBackoff {
+		t.Errorf("third failure: decision = %s, want %s", got, decisionSkippedBackoff)
+	}
+
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+	result := metrics.ProbeResults[peerKey{Interface: "wg-probe", PublicKey: "probed"}]
+	if result == nil || result.Failures != 3 || result.Success != 0 {
+		t.Errorf("unexpected probe metrics %+v", result)
+	}
+}
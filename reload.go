@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// tunnelguardSettings holds the settings of a Tunnelguard that can be replaced at runtime.
+type tunnelguardSettings struct {
+	niceNames        map[string]string
+	handshakeTimeout time.Duration
+	waitInterval     time.Duration
+	peers            map[string]PeerConfig
+	backoffMax       time.Duration
+	probes           map[string]*peerProbe
+	filter           PeerFilterConfig
+	escalation       []EscalationStep
+	restartWindow    time.Duration
+	configFile       string
+	// wgConfig is the parsed WireGuard config file, nil if the driver should read the file itself.
+	wgConfig *WgConfig
+	// applied is invoked by the loop once it applied the settings, it may be nil.
+	applied func()
+}
+
+func newTunnelguardSettings(conf InterfaceConfig) (*tunnelguardSettings, error) {
+	if conf.HandshakeTimeoutSeconds <= 0 {
+		return nil, errors.New("handshake timeout must be positive")
+	}
+
+	if conf.WaitSeconds <= 0 {
+		return nil, errors.New("wait seconds must be positive")
+	}
+
+	settings := &tunnelguardSettings{
+		niceNames:        conf.PublicKeyDict,
+		handshakeTimeout: time.Duration(conf.HandshakeTimeoutSeconds) * time.Second,
+		waitInterval:     time.Duration(conf.WaitSeconds) * time.Second,
+		peers:            conf.Peers,
+		backoffMax:       time.Duration(conf.ResetBackoffMaxSeconds) * time.Second,
+		probes:           map[string]*peerProbe{},
+		filter:           conf.PeerFilter,
+		escalation:       conf.Escalation,
+		restartWindow:    time.Duration(conf.InterfaceRestartWindowSeconds) * time.Second,
+		configFile:       conf.ConfigFile,
+	}
+
+	var errs error
+	for publicKey, peer := range conf.Peers {
+		if peer.Probe == nil {
+			continue
+		}
+		probe, err := newPeerProbe(*peer.Probe)
+		if err != nil {
+			errs = errors.Join(errs, fmt.Errorf("peer %s: %w", publicKey, err))
+			continue
+		}
+		settings.probes[publicKey] = probe
+	}
+
+	if errs != nil {
+		return nil, errs
+	}
+	return settings, nil
+}
+
+// wgConfigReceiver is implemented by drivers that cache the WireGuard config file.
+type wgConfigReceiver interface {
+	SetWgConfig(config *WgConfig)
+}
+
+// applySettings replaces the settings. Probes whose config did not change keep their state.
+func (t *Tunnelguard) applySettings(settings *tunnelguardSettings) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.niceNames = settings.niceNames
+	t.handshakeTimeout = settings.handshakeTimeout
+	t.waitInterval = settings.waitInterval
+	t.peers = settings.peers
+	t.backoffMax = settings.backoffMax
+	t.filter = settings.filter
+	t.escalation = settings.escalation
+	t.restartWindow = settings.restartWindow
+	t.configFile = settings.configFile
+	t.endpointTypes = map[string]string{}
+
+	probes := settings.probes
+	for publicKey, probe := range probes {
+		if current, found := t.probes[publicKey]; found && current.conf == probe.conf {
+			probes[publicKey] = current
+		}
+	}
+	t.probes = probes
+
+	if receiver, ok := t.wg.(wgConfigReceiver); ok && settings.wgConfig != nil {
+		receiver.SetWgConfig(settings.wgConfig)
+	}
+}
+
+// applyReload applies settings that have been queued by a reload and reports it back to the reload.
+func (t *Tunnelguard) applyReload(settings *tunnelguardSettings) {
+	t.applySettings(settings)
+	slog.Info("applied reloaded config", "interface", t.iface)
+	if settings.applied != nil {
+		settings.applied()
+	}
+}
+
+func (t *Tunnelguard) wgConfigFile() string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.configFile
+}
+
+// prepareReload validates the new config of the interface and reads its WireGuard config file.
+func (t *Tunnelguard) prepareReload(conf InterfaceConfig) (*tunnelguardSettings, error) {
+	settings, err := newTunnelguardSettings(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	wgQuickConfig, problems, err := parseWgQuickConfig(conf.ConfigFile)
+	if err != nil {
+		return nil, err
+	}
+	for _, problem := range problems {
+		slog.Warn("problem in wireguard config", "interface", t.iface, "file", conf.ConfigFile, "line", problem.Line, "problem", problem.Message)
+	}
+	settings.wgConfig = wgQuickConfig.WgConfig()
+
+	if err := validateProbeTargets(conf.Peers, conf.ConfigFile); err != nil {
+		return nil, err
+	}
+
+	return settings, nil
+}
+
+// queueReload hands the settings to the loop, which applies them before its next cycle. A pending reload that has
+// not been applied yet is replaced.
+func (t *Tunnelguard) queueReload(settings *tunnelguardSettings) {
+	for {
+		select {
+		case t.reloads <- settings:
+			return
+		default:
+			select {
+			case <-t.reloads:
+			default:
+			}
+		}
+	}
+}
+
+// reloadConfig reads the config file and hands the new settings to all tunnelguards. Nothing is changed if the config
+// or any WireGuard config file is invalid. Settings that are not tied to an interface require a restart. The applied
+// func, if not nil, is invoked once every tunnelguard applied its settings.
+func reloadConfig(configFile string, tunnelguards []*Tunnelguard, discover func() ([]string, error), applied func()) error {
+	config, err := readConfig(configFile)
+	if err != nil {
+		return fmt.Errorf("could not read config: %w", err)
+	}
+
+	interfaces, err := config.GetInterfaces(discover)
+	if err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+
+	confs := map[string]InterfaceConfig{}
+	for _, iface := range interfaces {
+		confs[iface.Interface] = iface
+	}
+
+	var errs error
+	prepared := make([]*tunnelguardSettings, len(tunnelguards))
+	for idx, tunnelguard := range tunnelguards {
+		conf, found := confs[tunnelguard.iface]
+		if !found {
+			errs = errors.Join(errs, fmt.Errorf("interface %s has been removed, restart required", tunnelguard.iface))
+			continue
+		}
+		delete(confs, tunnelguard.iface)
+
+		settings, err := tunnelguard.prepareReload(conf)
+		if err != nil {
+			errs = errors.Join(errs, fmt.Errorf("interface %s: %w", tunnelguard.iface, err))
+			continue
+		}
+		prepared[idx] = settings
+	}
+
+	if errs != nil {
+		return errs
+	}
+
+	for iface := range confs {
+		slog.Warn("new interface is not supervised until restart", "interface", iface)
+	}
+
+	if applied != nil && len(tunnelguards) == 0 {
+		applied()
+	}
+	remaining := &atomic.Int32{}
+	remaining.Store(int32(len(tunnelguards)))
+	for idx, tunnelguard := range tunnelguards {
+		prepared[idx].applied = func() {
+			if remaining.Add(-1) == 0 && applied != nil {
+				applied()
+			}
+		}
+		tunnelguard.queueReload(prepared[idx])
+	}
+	return nil
+}
+
+// Reloader reloads the config on request and records the outcome.
+type Reloader struct {
+	configFile   string
+	tunnelguards []*Tunnelguard
+	discover     func() ([]string, error)
+}
+
+func NewReloader(configFile string, tunnelguards []*Tunnelguard, discover func() ([]string, error)) *Reloader {
+	return &Reloader{
+		configFile:   configFile,
+		tunnelguards: tunnelguards,
+		discover:     discover,
+	}
+}
+
+// Reload reloads the config, the current config is kept if the new one is invalid. A successful reload is recorded
+// once the loops of all interfaces applied the new config.
+func (r *Reloader) Reload() {
+	err := reloadConfig(r.configFile, r.tunnelguards, r.discover, func() {
+		slog.Info("reloaded config")
+		metrics.SetConfigReload(true, time.Now())
+	})
+	if err != nil {
+		slog.Error("could not reload config, keeping current config", "err", err)
+		metrics.SetConfigReload(false, time.Now())
+	}
+}
+
+// files returns the config files and the conf.d directory whose changes trigger a reload.
+func (r *Reloader) files() []string {
+	var files []string
+	if len(r.configFile) > 0 {
+		files = append(files, r.configFile, configDropInDir(r.configFile))
+		dropIns, _ := getConfigDropIns(r.configFile)
+		files = append(files, dropIns...)
+	}
+	for _, tunnelguard := range r.tunnelguards {
+		if file := tunnelguard.wgConfigFile(); len(file) > 0 {
+			files = append(files, file)
+		}
+	}
+	return files
+}
+
+// Watch polls the modification times of the config files and reloads whenever one of them changed.
+func (r *Reloader) Watch(ctx context.Context, interval time.Duration) {
+	modTimes := getModTimes(r.files())
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			current := getModTimes(r.files())
+			if !equalModTimes(modTimes, current) {
+				slog.Info("config file changed, reloading")
+				r.Reload()
+			}
+			modTimes = current
+		}
+	}
+}
+
+func getModTimes(files []string) map[string]time.Time {
+	modTimes := map[string]time.Time{}
+	for _, file := range files {
+		if info, err := os.Stat(file); err == nil {
+			modTimes[file] = info.ModTime()
+		}
+	}
+	return modTimes
+}
+
+func equalModTimes(a, b map[string]time.Time) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for file, modTime := range a {
+		if other, found := b[file]; !found || !other.Equal(modTime) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeReloadConfig(t *testing.T, dir string, content string) string {
+	t.Helper()
+	file := filepath.Join(dir, "tunnelguard.json")
+	if err := os.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func writeWgConfig(t *testing.T, dir string, endpoint string) string {
+	t.Helper()
+	file := filepath.Join(dir, "wg-reload.conf")
+	content := fmt.Sprintf("[Peer]\nPublicKey = %s\nEndpoint = %s\n", testKeyA, endpoint)
+	if err := os.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func newReloadTunnelguard(t *testing.T, wgConfigFile string) *Tunnelguard {
+	t.Helper()
+	driver := &WgCli{interfaceName: "wg-reload", configFile: wgConfigFile}
+	conf := InterfaceConfig{
+		Interface:               "wg-reload",
+		ConfigFile:              wgConfigFile,
+		HandshakeTimeoutSeconds: 180,
+		WaitSeconds:             30,
+		PublicKeyDict:           map[string]string{testKeyA: "Old Name"},
+	}
+	tg, err := NewTunnelguard(driver, nil, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tg
+}
+
+func TestReloadConfig(t *testing.T) {
+	dir := t.TempDir()
+	wgConfigFile := writeWgConfig(t, dir, "old.example:51820")
+	tg := newReloadTunnelguard(t, wgConfigFile)
+
+	if endpoint, err := tg.wg.GetEndpoint(testKeyA); err != nil || endpoint != "old.example:51820" {
+		t.Fatalf("GetEndpoint() = %s, %v", endpoint, err)
+	}
+
+	configFile := writeReloadConfig(t, dir, fmt.Sprintf(`{
+		"wg_interface_name": "wg-reload",
+		"wg_config_file": %q,
+		"handshake_timeout_seconds": 300,
+		"pubkey_dict": {%q: "New Name"}
+	}`, wgConfigFile, testKeyA))
+	writeWgConfig(t, dir, "new.example:51820")
+
+	applied := false
+	if err := reloadConfig(configFile, []*Tunnelguard{tg}, nil, func() { applied = true }); err != nil {
+		t.Fatalf("reloadConfig() error = %v", err)
+	}
+	if applied {
+		t.Fatal("reload reported as applied before the loop applied it")
+	}
+
+	select {
+	case settings := <-tg.reloads:
+		tg.applyReload(settings)
+	default:
+		t.Fatal("no reload queued")
+	}
+	if !applied {
+		t.Error("reload not reported as applied")
+	}
+
+	if got := tg.niceNames[testKeyA]; got != "New Name" {
+		t.Errorf("nice name = %q, want %q", got, "New Name")
+	}
+	if tg.handshakeTimeout != 300*time.Second {
+		t.Errorf("handshake timeout = %v, want 5m", tg.handshakeTimeout)
+	}
+
+	// the driver keeps using the reloaded config even if the file is broken afterwards
+	if err := os.Remove(wgConfigFile); err != nil {
+		t.Fatal(err)
+	}
+	if endpoint, err := tg.wg.GetEndpoint(testKeyA); err != nil || endpoint != "new.example:51820" {
+		t.Errorf("GetEndpoint() = %s, %v, want new.example:51820", endpoint, err)
+	}
+}
+
+func TestReloadConfig_invalid(t *testing.T) {
+	dir := t.TempDir()
+	wgConfigFile := writeWgConfig(t, dir, "old.example:51820")
+
+	tests := []struct {
+		name   string
+		config string
+	}{
+		{
+			name:   "malformed json",
+			config: `{"wg_interface_name": `,
+		},
+		{
+			name:   "invalid timeout",
+			config: fmt.Sprintf(`{"wg_interface_name": "wg-reload", "wg_config_file": %q, "handshake_timeout_seconds": -1}`, wgConfigFile),
+		},
+		{
+			name:   "removed interface",
+			config: fmt.Sprintf(`{"wg_interface_name": "wg-other", "wg_config_file": %q}`, wgConfigFile),
+		},
+		{
+			name:   "missing wireguard config",
+			config: fmt.Sprintf(`{"wg_interface_name": "wg-reload", "wg_config_file": %q}`, filepath.Join(dir, "missing.conf")),
+		},
+		{
+			name:   "invalid probe",
+			config: fmt.Sprintf(`{"wg_interface_name": "wg-reload", "wg_config_file": %q, "peers": {%q: {"probe": {"type": "http"}}}}`, wgConfigFile, testKeyA),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tg := newReloadTunnelguard(t, wgConfigFile)
+			configFile := writeReloadConfig(t, t.TempDir(), tt.config)
+
+			if err := reloadConfig(configFile, []*Tunnelguard{tg}, nil, nil); err == nil {
+				t.Fatal("reloadConfig() expected error")
+			}
+			if len(tg.reloads) != 0 {
+				t.Errorf("reload has been queued for invalid config")
+			}
+			if got := tg.niceNames[testKeyA]; got != "Old Name" {
+				t.Errorf("nice name = %q, want %q", got, "Old Name")
+			}
+		})
+	}
+}
+
+func TestTunnelguard_applySettings_keepsProbeState(t *testing.T) {
+	probe := &ProbeConfig{Type: probeTcp, Target: "10.0.0.1:22"}
+	conf := InterfaceConfig{
+		Interface:               "wg-reload",
+		HandshakeTimeoutSeconds: 180,
+		WaitSeconds:             30,
+		Peers:                   map[string]PeerConfig{"a": {Probe: probe}, "b": {Probe: probe}},
+	}
+	tg, err := NewTunnelguard(&fakeDriver{}, nil, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tg.probes["a"].consecutiveFailures = 2
+	tg.probes["b"].consecutiveFailures = 2
+
+	conf.Peers = map[string]PeerConfig{"a": {Probe: probe}, "b": {Probe: &ProbeConfig{Type: probeTcp, Target: "10.0.0.2:22"}}}
+	settings, err := newTunnelguardSettings(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tg.applySettings(settings)
+
+	if got := tg.probes["a"].consecutiveFailures; got != 2 {
+		t.Errorf("unchanged probe lost its state, failures = %d", got)
+	}
+	if got := tg.probes["b"].consecutiveFailures; got != 0 {
+		t.Errorf("changed probe kept its state, failures = %d", got)
+	}
+}
+
+func TestReloader_Watch(t *testing.T) {
+	dir := t.TempDir()
+	wgConfigFile := writeWgConfig(t, dir, "old.example:51820")
+	tg := newReloadTunnelguard(t, wgConfigFile)
+	configFile := writeReloadConfig(t, dir, fmt.Sprintf(`{"wg_interface_name": "wg-reload", "wg_config_file": %q}`, wgConfigFile))
+
+	metrics.mutex.Lock()
+	before := metrics.ConfigReloads[reloadSuccess]
+	metrics.mutex.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reloader := NewReloader(configFile, []*Tunnelguard{tg}, nil)
+	go reloader.Watch(ctx, 10*time.Millisecond)
+
+	time.Sleep(50 * time.Millisecond)
+	future := time.Now().Add(time.Minute)
+	if err := os.Chtimes(wgConfigFile, future, future); err != nil {
+		t.Fatal(err)
+	}
+
+	var settings *tunnelguardSettings
+	select {
+	case settings = <-tg.reloads:
+	case <-time.After(2 * time.Second):
+		t.Fatal("config has not been reloaded after file change")
+	}
+
+	// success is only recorded once the loop applied the settings
+	metrics.mutex.Lock()
+	pending := metrics.ConfigReloads[reloadSuccess]
+	metrics.mutex.Unlock()
+	if pending != before {
+		t.Fatalf("reload recorded as successful before it was applied")
+	}
+
+	tg.applyReload(settings)
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+	if metrics.ConfigReloads[reloadSuccess] != before+1 || metrics.ConfigLastReloadSuccessful != 1 {
+		t.Errorf("successful reload not recorded, reloads = %v", metrics.ConfigReloads)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	decisionHealthy                 = "healthy"
+	decisionNeverConnected          = "never_connected"
+	decisionReset                   = "reset"
+	decisionResetFailed             = "reset_failed"
+	decisionDryRunReset             = "dry_run_reset"
+	decisionSkippedNoEndpoint       = "skipped_no_endpoint"
+	decisionSkippedStaticEndpoint   = "skipped_static_endpoint"
+	decisionSkippedAddressUnchanged = "skipped_address_unchanged"
+	decisionSkippedBackoff          = "skipped_backoff"
+	decisionSkippedRateLimit        = "skipped_rate_limit"
+	decisionSkippedRestartWindow    = "skipped_restart_window"
+	decisionError                   = "error"
+)
+
+const (
+	exitCodeHealthy       = 0
+	exitCodePeersReset    = 2
+	exitCodeTunnelStarted = 3
+	exitCodeErrors        = 4
+)
+
+// CycleReport summarises the decisions of a single pass over all peers of an interface.
+type CycleReport struct {
+	Interface     string         `json:"interface"`
+	Peers         []PeerDecision `json:"peers"`
+	TunnelStarted bool           `json:"tunnel_started"`
+	Errors        []string       `json:"errors,omitempty"`
+
+	// NextCheck is the duration to wait until the next peer can become stale.
+	NextCheck time.Duration `json:"-"`
+	// peersRead is set if the peers of the interface could be read
+	peersRead bool
+}
+
+// CycleHandler is invoked after each cycle of the loop.
+type CycleHandler func(*CycleReport)
+
+type PeerDecision struct {
+	PublicKey           string   `json:"pub_key"`
+	NiceName            string   `json:"nice_name,omitempty"`
+	HandshakeAgeSeconds *float64 `json:"handshake_age_seconds"`
+	Decision            string   `json:"decision"`
+	// Action is the remediation step that has been chosen for a stale peer.
+	Action string `json:"action,omitempty"`
+	Reason string `json:"reason,omitempty"`
+	State  string `json:"state"`
+}
+
+func (r *CycleReport) addError(op string, err error) {
+	r.Errors = append(r.Errors, fmt.Sprintf("%s: %v", op, err))
+}
+
+// ExitCode returns the exit code describing the most severe outcome of the cycle.
+func (r *CycleReport) ExitCode() int {
+	if len(r.Errors) > 0 {
+		return exitCodeErrors
+	}
+
+	if r.TunnelStarted {
+		return exitCodeTunnelStarted
+	}
+
+	// restarting the interface brings the tunnel down and up again, just like starting it
+	for _, peer := range r.Peers {
+		if peer.Decision == decisionReset && peer.Action == actionRestartInterface {
+			return exitCodeTunnelStarted
+		}
+	}
+
+	for _, peer := range r.Peers {
+		if peer.Decision == decisionReset || peer.Decision == decisionDryRunReset {
+			return exitCodePeersReset
+		}
+	}
+
+	return exitCodeHealthy
+}
+
+// combinedExitCode returns the most severe exit code of all reports.
+func combinedExitCode(reports []*CycleReport) int {
+	exitCode := exitCodeHealthy
+	for _, report := range reports {
+		exitCode = max(exitCode, report.ExitCode())
+	}
+	return exitCode
+}
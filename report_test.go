@@ -0,0 +1,108 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCycleReport_ExitCode(t *testing.T) {
+	tests := []struct {
+		name   string
+		report CycleReport
+		want   int
+	}{
+		{
+			name: "healthy",
+			report: CycleReport{
+				Peers: []PeerDecision{
+					{Decision: decisionHealthy},
+					{Decision: decisionNeverConnected},
+					{Decision: decisionSkippedStaticEndpoint},
+				},
+			},
+			want: exitCodeHealthy,
+		},
+		{
+			name: "peers reset",
+			report: CycleReport{
+				Peers: []PeerDecision{
+					{Decision: decisionHealthy},
+					{Decision: decisionReset},
+				},
+			},
+			want: exitCodePeersReset,
+		},
+		{
+			name: "dry-run reset",
+			report: CycleReport{
+				Peers: []PeerDecision{
+					{Decision: decisionDryRunReset},
+				},
+			},
+			want: exitCodePeersReset,
+		},
+		{
+			name: "tunnel started",
+			report: CycleReport{
+				Peers:         []PeerDecision{{Decision: decisionReset}},
+				TunnelStarted: true,
+			},
+			want: exitCodeTunnelStarted,
+		},
+		{
+			name: "interface restarted",
+			report: CycleReport{
+				Peers: []PeerDecision{
+					{Decision: decisionReset, Action: actionReaddPeer},
+					{Decision: decisionReset, Action: actionRestartInterface},
+				},
+			},
+			want: exitCodeTunnelStarted,
+		},
+		{
+			name: "errors",
+			report: CycleReport{
+				TunnelStarted: true,
+				Errors:        []string{"get_peers: wg not found"},
+			},
+			want: exitCodeErrors,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.report.ExitCode(); got != tt.want {
+				t.Errorf("ExitCode() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTunnelguard_RunOnce(t *testing.T) {
+	driver := &fakeDriver{
+		peers: []Peer{
+			{PublicKey: "healthy", HandshakeLastSeen: handshakeAgo(time.Minute)},
+			{PublicKey: "stale", HandshakeLastSeen: handshakeAgo(time.Hour)},
+			{PublicKey: "new"},
+		},
+		endpoints: map[string]string{"stale": "host.example:51820"},
+	}
+	tg, err := NewTunnelguard(driver, nil, InterfaceConfig{Interface: "wg-once", HandshakeTimeoutSeconds: 180, WaitSeconds: 30})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report := tg.RunOnce()
+	want := []string{decisionHealthy, decisionReset, decisionNeverConnected}
+	if len(report.Peers) != len(want) {
+		t.Fatalf("RunOnce() got %d decisions, want %d", len(report.Peers), len(want))
+	}
+	for idx, decision := range report.Peers {
+		if decision.Decision != want[idx] {
+			t.Errorf("RunOnce() peer %s decision = %s, want %s", decision.PublicKey, decision.Decision, want[idx])
+		}
+	}
+
+	if got := combinedExitCode([]*CycleReport{report, {}}); got != exitCodePeersReset {
+		t.Errorf("combinedExitCode() = %d, want %d", got, exitCodePeersReset)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"time"
+)
+
+const (
+	defaultResolverTimeout = 5 * time.Second
+
+	preferIpv4 = "ipv4"
+	preferIpv6 = "ipv6"
+)
+
+type HostResolver interface {
+	Resolve(host string) ([]netip.Addr, error)
+}
+
+// ResolverConfig configures the resolver that is used to check whether an endpoint's address has changed.
+type ResolverConfig struct {
+	// Server is the address of the DNS server to query, the system's resolver is used if empty.
+	Server string `json:"server"`
+	// TimeoutSeconds limits the duration of a single lookup.
+	TimeoutSeconds int `json:"timeout_seconds"`
+	// Prefer sorts the results by address family, either "ipv4" or "ipv6".
+	Prefer string `json:"prefer"`
+}
+
+type Resolver struct {
+	resolver *net.Resolver
+	timeout  time.Duration
+	prefer   string
+}
+
+func NewResolver(conf ResolverConfig) (*Resolver, error) {
+	if conf.TimeoutSeconds < 0 {
+		return nil, fmt.Errorf("invalid resolver timeout %d", conf.TimeoutSeconds)
+	}
+
+	if conf.Prefer != "" && conf.Prefer != preferIpv4 && conf.Prefer != preferIpv6 {
+		return nil, fmt.Errorf("invalid address family preference %q", conf.Prefer)
+	}
+
+	timeout := defaultResolverTimeout
+	if conf.TimeoutSeconds > 0 {
+		timeout = time.Duration(conf.TimeoutSeconds) * time.Second
+	}
+
+	resolver := net.DefaultResolver
+	if len(conf.Server) > 0 {
+		server := conf.Server
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(server, "53")
+		}
+
+		resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				dialer := net.Dialer{Timeout: timeout}
+				return dialer.DialContext(ctx, network, server)
+			},
+		}
+	}
+
+	return &Resolver{
+		resolver: resolver,
+		timeout:  timeout,
+		prefer:   conf.Prefer,
+	}, nil
+}
+
+// Resolve looks up the addresses of the given host, ordered by the configured address family preference.
+func (r *Resolver) Resolve(host string) ([]netip.Addr, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+
+	addrs, err := r.resolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(addrs) == 0 {
+		return nil, errors.New("no addresses found")
+	}
+
+	return sortAddrs(addrs, r.prefer), nil
+}
+
+// sortAddrs returns the addresses ordered by the preferred address family, keeping the order of the resolver
+// within each family.
+func sortAddrs(addrs []netip.Addr, prefer string) []netip.Addr {
+	sorted := make([]netip.Addr, 0, len(addrs))
+	for _, addr := range addrs {
+		sorted = append(sorted, addr.Unmap())
+	}
+
+	if prefer == "" {
+		return sorted
+	}
+
+	rank := func(addr netip.Addr) int {
+		if addr.Is4() == (prefer == preferIpv4) {
+			return 0
+		}
+		return 1
+	}
+	slices.SortStableFunc(sorted, func(a, b netip.Addr) int {
+		return rank(a) - rank(b)
+	})
+
+	return sorted
+}
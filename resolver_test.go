@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+func Test_sortAddrs(t *testing.T) {
+	v4 := netip.MustParseAddr("192.0.2.1")
+	v4Mapped := netip.MustParseAddr("::ffff:192.0.2.2")
+	v6 := netip.MustParseAddr("2001:db8::1")
+
+	tests := []struct {
+		name   string
+		addrs  []netip.Addr
+		prefer string
+		want   []netip.Addr
+	}{
+		{
+			name:  "no preference",
+			addrs: []netip.Addr{v6, v4},
+			want:  []netip.Addr{v6, v4},
+		},
+		{
+			name:   "prefer ipv4",
+			addrs:  []netip.Addr{v6, v4, v4Mapped},
+			prefer: preferIpv4,
+			want:   []netip.Addr{v4, v4Mapped.Unmap(), v6},
+		},
+		{
+			name:   "prefer ipv6",
+			addrs:  []netip.Addr{v4, v6},
+			prefer: preferIpv6,
+			want:   []netip.Addr{v6, v4},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sortAddrs(tt.addrs, tt.prefer); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("sortAddrs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewResolver(t *testing.T) {
+	if _, err := NewResolver(ResolverConfig{Prefer: "ipv5"}); err == nil {
+		t.Error("NewResolver() expected error for invalid preference")
+	}
+	if _, err := NewResolver(ResolverConfig{TimeoutSeconds: -1}); err == nil {
+		t.Error("NewResolver() expected error for invalid timeout")
+	}
+	if _, err := NewResolver(ResolverConfig{Server: "127.0.0.1", Prefer: preferIpv6}); err != nil {
+		t.Errorf("NewResolver() unexpected error %v", err)
+	}
+}
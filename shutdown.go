@@ -0,0 +1,55 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const defaultShutdownTimeoutSeconds = 30
+
+// waitForShutdown waits for all loops to finish their current cycle and reports whether they did so within the
+// timeout.
+func waitForShutdown(wg *sync.WaitGroup, timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// shutdown waits for in-flight cycles and queued notifications and writes a final metrics snapshot that marks whether
+// tunnelguard stopped cleanly.
+func shutdown(wg *sync.WaitGroup, timeout time.Duration, metricsWriter *MetricsWriter, dispatcher *NotificationDispatcher) {
+	if timeout <= 0 {
+		timeout = defaultShutdownTimeoutSeconds * time.Second
+	}
+
+	clean := waitForShutdown(wg, timeout)
+	if !clean {
+		slog.Warn("Not all loops finished in time", "timeout", timeout)
+	}
+	flushNotifications(dispatcher, timeout)
+
+	metrics.SetShutdown(clean, time.Now())
+	if metricsWriter != nil {
+		if err := metricsWriter.Flush(); err != nil {
+			slog.Error("can not write final metrics data", "err", err)
+		}
+	}
+	slog.Info("Stopped tunnelguard", "clean", clean)
+}
+
+// flushNotifications waits for the notifications that are still queued.
+func flushNotifications(dispatcher *NotificationDispatcher, timeout time.Duration) {
+	if dispatcher != nil && !dispatcher.Flush(timeout) {
+		slog.Warn("Not all notifications were sent in time", "timeout", timeout)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_waitForShutdown(t *testing.T) {
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		wg.Done()
+	}()
+	if !waitForShutdown(wg, time.Second) {
+		t.Error("waitForShutdown() = false, want true")
+	}
+
+	stuck := &sync.WaitGroup{}
+	stuck.Add(1)
+	defer stuck.Done()
+	if waitForShutdown(stuck, 10*time.Millisecond) {
+		t.Error("waitForShutdown() = true, want false")
+	}
+}
+
+func Test_shutdown(t *testing.T) {
+	metricsFile := filepath.Join(t.TempDir(), "tunnelguard.prom")
+	metricsWriter, err := NewMetricsWriter(metricsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	shutdown(&sync.WaitGroup{}, time.Second, metricsWriter, nil)
+
+	data, err := os.ReadFile(metricsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "tunnelguard_shutdown_clean 1") {
+		t.Errorf("final metrics do not mark a clean shutdown:\n%s", data)
+	}
+	if !strings.Contains(string(data), "tunnelguard_shutdown_timestamp_seconds ") {
+		t.Errorf("final metrics do not contain the shutdown timestamp:\n%s", data)
+	}
+}
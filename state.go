@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const stateFileVersion = 1
+
+// persistedState is the content of the state file.
+type persistedState struct {
+	Version    int                            `json:"version"`
+	Interfaces map[string]*persistedInterface `json:"interfaces"`
+}
+
+type persistedInterface struct {
+	Peers map[string]*persistedPeer `json:"peers"`
+	// Errors holds the error totals keyed by the operation that failed.
+	Errors map[string]int64 `json:"errors,omitempty"`
+}
+
+type persistedPeer struct {
+	ResetsTotal int64      `json:"resets_total,omitempty"`
+	LastReset   *time.Time `json:"last_reset,omitempty"`
+	// LastGoodEndpoint is the runtime endpoint that has been used during the most recent healthy cycle.
+	LastGoodEndpoint string `json:"last_good_endpoint,omitempty"`
+	// ActiveEndpoint is the candidate endpoint the peer has been moved to by a failover.
+	ActiveEndpoint string     `json:"active_endpoint,omitempty"`
+	State          string     `json:"state,omitempty"`
+	StateSince     *time.Time `json:"state_since,omitempty"`
+
+	ConsecutiveResets int        `json:"consecutive_resets,omitempty"`
+	BackoffLastReset  *time.Time `json:"backoff_last_reset,omitempty"`
+	HandshakeAtReset  *time.Time `json:"handshake_at_reset,omitempty"`
+	// CooldownUntil is the end of the cooldown of the most recent escalation step.
+	CooldownUntil *time.Time `json:"cooldown_until,omitempty"`
+}
+
+// StateStore persists the state of all interfaces to a file, so counters, health states and backoff survive restarts.
+// It is shared by all tunnelguards.
+type StateStore struct {
+	mutex sync.Mutex
+	file  string
+	state persistedState
+	// written is the content of the most recent write, used to skip writes if nothing changed
+	written []byte
+}
+
+// NewStateStore reads the state file. A missing, unreadable or corrupt file results in an empty state.
+func NewStateStore(file string) (*StateStore, error) {
+	if len(file) == 0 {
+		return nil, errors.New("empty state file provided")
+	}
+
+	store := &StateStore{
+		file:  file,
+		state: persistedState{Version: stateFileVersion, Interfaces: map[string]*persistedInterface{}},
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			slog.Warn("could not read state file, starting with empty state", "file", file, "err", err)
+		}
+		return store, nil
+	}
+
+	var state persistedState
+	if err := json.Unmarshal(data, &state); err != nil || state.Version != stateFileVersion || state.Interfaces == nil {
+		slog.Warn("ignoring corrupt state file, starting with empty state", "file", file, "err", err)
+		return store, nil
+	}
+	store.state = state
+	store.written = data
+	return store, nil
+}
+
+// get returns the persisted state of the interface, nil if there is none.
+func (s *StateStore) get(iface string) *persistedInterface {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.state.Interfaces[iface]
+}
+
+// update replaces the state of the interface and writes the file if its content changed.
+func (s *StateStore) update(iface string, state *persistedInterface) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.state.Interfaces[iface] = state
+	data, err := json.MarshalIndent(s.state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(data, s.written) {
+		return nil
+	}
+
+	if err := writeFileAtomically(s.file, data); err != nil {
+		return err
+	}
+	s.written = data
+	return nil
+}
+
+// writeFileAtomically writes the data to a temporary file in the same directory and renames it, so readers never see
+// a partially written file.
+func writeFileAtomically(file string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(file), filepath.Base(file)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+// WithStateStore restores the persisted state of the interface and persists it after every cycle.
+func WithStateStore(store *StateStore) TunnelguardOpt {
+	return func(t *Tunnelguard) error {
+		if store == nil {
+			return errors.New("nil state store provided")
+		}
+		t.stateStore = store
+		if state := store.get(t.iface); state != nil {
+			t.restoreState(state)
+		}
+		return nil
+	}
+}
+
+func (t *Tunnelguard) restoreState(state *persistedInterface) {
+	for op, total := range state.Errors {
+		metrics.SetErrors(t.iface, op, total)
+	}
+
+	for publicKey, peer := range state.Peers {
+		niceName := t.niceNames[publicKey]
+		if peer.ResetsTotal > 0 {
+			metrics.SetPeerResets(t.iface, publicKey, niceName, peer.ResetsTotal)
+		}
+		if peer.LastReset != nil {
+			metrics.SetLastReset(t.iface, publicKey, niceName, *peer.LastReset)
+		}
+		if len(peer.LastGoodEndpoint) > 0 {
+			t.goodEndpoints[publicKey] = peer.LastGoodEndpoint
+		}
+		if len(peer.ActiveEndpoint) > 0 {
+			if failover := t.getFailover(publicKey); failover != nil {
+				failover.activate(peer.ActiveEndpoint)
+			}
+		}
+		if len(peer.State) > 0 && peer.StateSince != nil {
+			t.states[publicKey] = &peerState{State: peer.State, Since: *peer.StateSince}
+			metrics.SetPeerState(t.iface, publicKey, niceName, peer.State, *peer.StateSince)
+		}
+		if peer.ConsecutiveResets > 0 && peer.BackoffLastReset != nil && peer.HandshakeAtReset != nil {
+			backoff := &peerBackoff{
+				consecutiveResets: peer.ConsecutiveResets,
+				lastReset:         *peer.BackoffLastReset,
+				handshakeAtReset:  *peer.HandshakeAtReset,
+			}
+			if peer.CooldownUntil != nil {
+				backoff.cooldownUntil = *peer.CooldownUntil
+			}
+			t.backoff[publicKey] = backoff
+		}
+	}
+	slog.Info("restored state", "interface", t.iface, "peers", len(state.Peers))
+}
+
+// snapshotState returns the state of the interface that is persisted.
+func (t *Tunnelguard) snapshotState() *persistedInterface {
+	state := &persistedInterface{
+		Peers:  map[string]*persistedPeer{},
+		Errors: metrics.GetErrors(t.iface),
+	}
+
+	getPeer := func(publicKey string) *persistedPeer {
+		if state.Peers[publicKey] == nil {
+			state.Peers[publicKey] = &persistedPeer{}
+		}
+		return state.Peers[publicKey]
+	}
+
+	for publicKey, current := range t.states {
+		peer := getPeer(publicKey)
+		peer.State = current.State
+		peer.StateSince = timePtr(current.Since)
+	}
+	for publicKey, backoff := range t.backoff {
+		if backoff.consecutiveResets == 0 {
+			continue
+		}
+		peer := getPeer(publicKey)
+		peer.ConsecutiveResets = backoff.consecutiveResets
+		peer.BackoffLastReset = timePtr(backoff.lastReset)
+		peer.HandshakeAtReset = timePtr(backoff.handshakeAtReset)
+		if time.Now().Before(backoff.cooldownUntil) {
+			peer.CooldownUntil = timePtr(backoff.cooldownUntil)
+		}
+	}
+	for publicKey, endpoint := range t.goodEndpoints {
+		getPeer(publicKey).LastGoodEndpoint = endpoint
+	}
+	for publicKey, failover := range t.failover {
+		if failover.active > 0 {
+			getPeer(publicKey).ActiveEndpoint = failover.endpoints[failover.active]
+		}
+	}
+	for publicKey, total := range metrics.GetPeerResets(t.iface) {
+		getPeer(publicKey).ResetsTotal = total
+	}
+	for publicKey, timestamp := range metrics.GetLastResets(t.iface) {
+		getPeer(publicKey).LastReset = timePtr(timestamp)
+	}
+
+	return state
+}
+
+// saveState persists the state of the interface if a state store is configured.
+func (t *Tunnelguard) saveState() {
+	if t.stateStore == nil {
+		return
+	}
+	if err := t.stateStore.update(t.iface, t.snapshotState()); err != nil {
+		slog.Warn("could not write state file", "interface", t.iface, "err", err)
+	}
+}
+
+// recordGoodEndpoint remembers the address of the endpoint that is used by a healthy peer.
+func (t *Tunnelguard) recordGoodEndpoint(peer Peer) {
+	if peer.Endpoint == nil {
+		return
+	}
+	addrPort, err := netip.ParseAddrPort(*peer.Endpoint)
+	if err != nil {
+		return
+	}
+	t.goodEndpoints[peer.PublicKey] = addrPort.Addr().Unmap().String()
+}
+
+func (c *TunnelguardConfig) validateStateFile() error {
+	if len(c.StateFile) == 0 {
+		return nil
+	}
+	if _, err := os.Stat(filepath.Dir(c.StateFile)); err != nil {
+		return fmt.Errorf("directory of state file: %w", err)
+	}
+	return nil
+}
+
+func timePtr(value time.Time) *time.Time {
+	return &value
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStateStore_restore(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "state.json")
+	conf := InterfaceConfig{
+		Interface:               "wg-persist",
+		HandshakeTimeoutSeconds: 180,
+		WaitSeconds:             30,
+		Peers: map[string]PeerConfig{"stale": {
+			Endpoints:  []string{"host.example:51820", "backup.example:51820"},
+			Escalation: []EscalationStep{{Action: actionResetPeer, CooldownSeconds: 600}},
+		}},
+	}
+
+	driver := &fakeDriver{
+		peers: []Peer{
+			{PublicKey: "healthy", HandshakeLastSeen: handshakeAgo(time.Minute), Endpoint: asPtr("192.0.2.1:51820")},
+			{PublicKey: "stale", HandshakeLastSeen: handshakeAgo(time.Hour)},
+		},
+		endpoints: map[string]string{"stale": "host.example:51820"},
+	}
+
+	store, err := NewStateStore(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tg, err := NewTunnelguard(driver, nil, conf, WithStateStore(store))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tg.RunOnce()
+	if len(driver.resets) != 1 {
+		t.Fatalf("expected a single reset, got %v", driver.resets)
+	}
+
+	var transitions []PeerTransition
+	restored, err := NewStateStore(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tg2, err := NewTunnelguard(&fakeDriver{}, nil, conf, WithStateStore(restored), WithTransitionHandler(func(transition PeerTransition) {
+		transitions = append(transitions, transition)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := tg2.goodEndpoints["healthy"]; got != "192.0.2.1" {
+		t.Errorf("expected good endpoint 192.0.2.1, got %q", got)
+	}
+	if got := tg2.states["healthy"]; got == nil || got.State != stateHealthy {
+		t.Errorf("expected healthy state, got %v", got)
+	}
+	if got := tg2.states["stale"]; got == nil || got.State != stateResetting {
+		t.Errorf("expected resetting state, got %v", got)
+	}
+	if failover := tg2.failover["stale"]; failover == nil || failover.active != 1 {
+		t.Errorf("expected restored failover to the backup endpoint, got %v", failover)
+	}
+	backoff := tg2.backoff["stale"]
+	if backoff == nil || backoff.consecutiveResets != 1 {
+		t.Fatalf("expected restored backoff, got %v", backoff)
+	}
+	if !backoff.handshakeAtReset.Equal(driver.peers[1].HandshakeLastSeen.Truncate(0)) {
+		t.Errorf("expected handshake at reset %v, got %v", driver.peers[1].HandshakeLastSeen, backoff.handshakeAtReset)
+	}
+	// a restart during the cooldown must not allow an immediate reset
+	if remaining := backoff.remaining(tg2.waitInterval, tg2.backoffMax); remaining < 9*time.Minute {
+		t.Errorf("expected restored cooldown of about 10m, remaining %v", remaining)
+	}
+
+	snapshot := tg2.snapshotState()
+	if got := snapshot.Peers["stale"].ResetsTotal; got != 1 {
+		t.Errorf("expected 1 reset, got %d", got)
+	}
+	if snapshot.Peers["stale"].LastReset == nil {
+		t.Error("expected last reset to be restored")
+	}
+
+	// the restored state must not lead to a transition on the first cycle
+	tg2.wg = driver
+	tg2.RunOnce()
+	if len(transitions) != 0 {
+		t.Errorf("expected no transitions, got %v", transitions)
+	}
+}
+
+func TestNewStateStore_invalidFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{name: "corrupt", content: `{"version": 1, "interfaces": {`},
+		{name: "unknown version", content: `{"version": 99, "interfaces": {}}`},
+		{name: "empty", content: ``},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "state.json")
+			if err := os.WriteFile(file, []byte(tt.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			store, err := NewStateStore(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if state := store.get("wg0"); state != nil {
+				t.Errorf("expected empty state, got %v", state)
+			}
+
+			// the corrupt file is replaced on the next write
+			if err := store.update("wg0", &persistedInterface{Peers: map[string]*persistedPeer{}}); err != nil {
+				t.Fatal(err)
+			}
+			restored, err := NewStateStore(file)
+			if err != nil || restored.get("wg0") == nil {
+				t.Errorf("expected valid state file, got %v", err)
+			}
+		})
+	}
+}
+
+func TestStateStore_update(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "state.json")
+	store, err := NewStateStore(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state := &persistedInterface{
+		Peers: map[string]*persistedPeer{"a": {ResetsTotal: 3, State: stateStale}},
+	}
+	if err := store.update("wg0", state); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.update("wg1", &persistedInterface{Peers: map[string]*persistedPeer{}}); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "state.json" {
+		t.Errorf("expected only the state file, got %v", entries)
+	}
+
+	restored, err := NewStateStore(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := restored.get("wg0")
+	if got == nil || got.Peers["a"].ResetsTotal != 3 || got.Peers["a"].State != stateStale {
+		t.Errorf("unexpected restored state %v", got)
+	}
+	if restored.get("wg1") == nil {
+		t.Error("expected state of second interface")
+	}
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	statusFormatTable = "table"
+	statusFormatJson  = "json"
+	statusFormatCsv   = "csv"
+
+	statusSourceDaemon = "daemon"
+	statusSourceLocal  = "local"
+
+	statusDaemonTimeout = 3 * time.Second
+)
+
+// StatusReport holds the status of all peers of all supervised interfaces.
+type StatusReport struct {
+	// Source is either daemon if the status has been fetched from a running daemon or local if it has been computed
+	// from scratch.
+	Source string       `json:"source"`
+	Peers  []PeerStatus `json:"peers"`
+	Errors []string     `json:"errors,omitempty"`
+}
+
+type PeerStatus struct {
+	Interface           string   `json:"interface"`
+	PublicKey           string   `json:"pub_key"`
+	NiceName            string   `json:"nice_name,omitempty"`
+	ConfiguredEndpoint  string   `json:"configured_endpoint,omitempty"`
+	RuntimeEndpoint     string   `json:"runtime_endpoint,omitempty"`
+	HandshakeAgeSeconds *float64 `json:"handshake_age_seconds"`
+	State               string   `json:"state"`
+	ResetsTotal         int64    `json:"resets_total"`
+}
+
+// Status queries WireGuard and returns the status of all monitored peers. The state tracked by the loop is used if
+// available, otherwise it is derived from the age of the latest handshake.
+func (t *Tunnelguard) Status() ([]PeerStatus, error) {
+	peers, err := t.wg.GetPeers()
+	if err != nil {
+		return nil, fmt.Errorf("could not get peers: %w", err)
+	}
+
+	states := metrics.GetPeerStates(t.iface)
+	resets := metrics.GetPeerResets(t.iface)
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	ret := make([]PeerStatus, 0, len(peers))
+	for _, peer := range peers {
+		if !t.isMonitored(peer.PublicKey) {
+			continue
+		}
+
+		status := PeerStatus{
+			Interface:   t.iface,
+			PublicKey:   peer.PublicKey,
+			NiceName:    t.niceNames[peer.PublicKey],
+			State:       states[peer.PublicKey],
+			ResetsTotal: resets[peer.PublicKey],
+		}
+		if endpoint, err := t.wg.GetEndpoint(peer.PublicKey); err == nil {
+			status.ConfiguredEndpoint = endpoint
+		}
+		if peer.Endpoint != nil {
+			status.RuntimeEndpoint = *peer.Endpoint
+		}
+
+		if peer.HandshakeLastSeen != nil {
+			timeSinceHandshake := time.Since(*peer.HandshakeLastSeen)
+			age := timeSinceHandshake.Truncate(time.Second).Seconds()
+			status.HandshakeAgeSeconds = &age
+			if status.State == "" {
+				status.State = stateHealthy
+				if timeSinceHandshake > t.getHandshakeTimeout(peer.PublicKey) {
+					status.State = stateStale
+				}
+			}
+		} else if status.State == "" {
+			status.State = stateNeverConnected
+		}
+
+		ret = append(ret, status)
+	}
+
+	return ret, nil
+}
+
+// collectStatus returns the status of all peers of all interfaces.
+func collectStatus(tunnelguards []*Tunnelguard, source string) StatusReport {
+	report := StatusReport{
+		Source: source,
+		Peers:  []PeerStatus{},
+	}
+	for _, tunnelguard := range tunnelguards {
+		peers, err := tunnelguard.Status()
+		if err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", tunnelguard.iface, err))
+			continue
+		}
+		report.Peers = append(report.Peers, peers...)
+	}
+	return report
+}
+
+func writeStatus(w io.Writer, report StatusReport, format string) error {
+	switch format {
+	case statusFormatJson:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	case statusFormatCsv:
+		return writeStatusCsv(w, report)
+	case statusFormatTable:
+		return writeStatusTable(w, report)
+	default:
+		return fmt.Errorf("unknown format %q", format)
+	}
+}
+
+var statusColumns = []string{"interface", "nice_name", "pub_key", "configured_endpoint", "runtime_endpoint", "handshake_age_seconds", "state", "resets_total"}
+
+func statusRow(peer PeerStatus, formatAge func(*float64) string) []string {
+	return []string{
+		peer.Interface,
+		peer.NiceName,
+		peer.PublicKey,
+		peer.ConfiguredEndpoint,
+		peer.RuntimeEndpoint,
+		formatAge(peer.HandshakeAgeSeconds),
+		peer.State,
+		strconv.FormatInt(peer.ResetsTotal, 10),
+	}
+}
+
+func writeStatusCsv(w io.Writer, report StatusReport) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(statusColumns); err != nil {
+		return err
+	}
+	for _, peer := range report.Peers {
+		row := statusRow(peer, func(age *float64) string {
+			if age == nil {
+				return ""
+			}
+			return strconv.FormatFloat(*age, 'f', -1, 64)
+		})
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func writeStatusTable(w io.Writer, report StatusReport) error {
+	writer := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	header := []string{"INTERFACE", "NAME", "PUBLIC KEY", "CONFIGURED ENDPOINT", "RUNTIME ENDPOINT", "HANDSHAKE AGE", "STATE", "RESETS"}
+	fmt.Fprintln(writer, strings.Join(header, "\t"))
+	for _, peer := range report.Peers {
+		row := statusRow(peer, func(age *float64) string {
+			if age == nil {
+				return "never"
+			}
+			return (time.Duration(*age) * time.Second).String()
+		})
+		for idx, column := range row {
+			if column == "" {
+				row[idx] = "-"
+			}
+		}
+		fmt.Fprintln(writer, strings.Join(row, "\t"))
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+
+	for _, err := range report.Errors {
+		fmt.Fprintf(w, "error: %s\n", err)
+	}
+	return nil
+}
+
+// fetchDaemonStatus requests the status from the http server of a running daemon.
+func fetchDaemonStatus(listenAddress string) (StatusReport, error) {
+	client := &http.Client{Timeout: statusDaemonTimeout}
+	resp, err := client.Get(statusUrl(listenAddress))
+	if err != nil {
+		return StatusReport{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return StatusReport{}, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	var report StatusReport
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		return StatusReport{}, fmt.Errorf("could not decode status: %w", err)
+	}
+	return report, nil
+}
+
+// statusUrl returns the url of the status endpoint for the listen address, wildcard addresses are replaced by
+// localhost.
+func statusUrl(listenAddress string) string {
+	host, port, err := net.SplitHostPort(listenAddress)
+	if err != nil {
+		return "http://" + listenAddress + "/status"
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "localhost"
+	}
+	return "http://" + net.JoinHostPort(host, port) + "/status"
+}
+
+// runStatus implements the status subcommand and returns the exit code.
+func runStatus(args []string) int {
+	flags := flag.NewFlagSet("status", flag.ContinueOnError)
+	configFile := flags.String("config", "", "Path of config file")
+	format := flags.String("format", statusFormatTable, "Output format, one of table, json or csv")
+	local := flags.Bool("local", false, "Compute the status from scratch even if a daemon is running")
+	debug := flags.Bool("debug", false, "Print debug logs")
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+
+	if !slices.Contains([]string{statusFormatTable, statusFormatJson, statusFormatCsv}, *format) {
+		fmt.Fprintf(os.Stderr, "unknown format %q\n", *format)
+		return 1
+	}
+
+	// keep stdout free for the status
+	setupLogger(*debug, os.Stderr)
+
+	report, err := getStatus(*configFile, !*local)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "could not get status: %v\n", err)
+		return 1
+	}
+
+	if err := writeStatus(os.Stdout, report, *format); err != nil {
+		fmt.Fprintf(os.Stderr, "could not print status: %v\n", err)
+		return 1
+	}
+
+	if len(report.Errors) > 0 {
+		return exitCodeErrors
+	}
+	return 0
+}
+
+// getStatus fetches the status from a running daemon if its http server is enabled and reachable, otherwise the
+// status is computed using the same config and drivers as the daemon.
+func getStatus(configFile string, tryDaemon bool) (StatusReport, error) {
+	config, err := readConfig(configFile)
+	if err != nil {
+		return StatusReport{}, fmt.Errorf("could not read config: %w", err)
+	}
+
+	if tryDaemon && len(config.ListenAddress) > 0 {
+		report, err := fetchDaemonStatus(config.ListenAddress)
+		if err == nil {
+			return report, nil
+		}
+		fmt.Fprintf(os.Stderr, "could not fetch status from daemon, computing it locally: %v\n", err)
+	}
+
+	interfaces, err := config.GetInterfaces(discoverWireguardInterfaces)
+	if err != nil {
+		return StatusReport{}, fmt.Errorf("could not determine interfaces: %w", err)
+	}
+
+	var errs error
+	var tunnelguards []*Tunnelguard
+	for _, iface := range interfaces {
+		wgDriver, err := buildWireguardDriver(iface)
+		if err == nil {
+			var tunnelguard *Tunnelguard
+			tunnelguard, err = NewTunnelguard(wgDriver, nil, iface)
+			tunnelguards = append(tunnelguards, tunnelguard)
+		}
+		if err != nil {
+			errs = errors.Join(errs, fmt.Errorf("interface %s: %w", iface.Interface, err))
+		}
+	}
+	if errs != nil {
+		return StatusReport{}, errs
+	}
+
+	return collectStatus(tunnelguards, statusSourceLocal), nil
+}
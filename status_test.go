@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTunnelguard_Status(t *testing.T) {
+	driver := &fakeDriver{
+		peers: []Peer{
+			{PublicKey: "healthy", HandshakeLastSeen: handshakeAgo(time.Minute), Endpoint: asPtr("192.0.2.1:51820")},
+			{PublicKey: "stale", HandshakeLastSeen: handshakeAgo(time.Hour), Endpoint: asPtr("192.0.2.2:51820")},
+			{PublicKey: "never"},
+			{PublicKey: "excluded"},
+		},
+		endpoints: map[string]string{"healthy": "192.0.2.1:51820", "stale": "host.example:51820"},
+	}
+	conf := InterfaceConfig{
+		Interface:               "wg-status",
+		HandshakeTimeoutSeconds: 180,
+		WaitSeconds:             30,
+		PublicKeyDict:           map[string]string{"healthy": "Healthy Peer"},
+		PeerFilter:              PeerFilterConfig{Exclude: []string{"excluded"}},
+	}
+	tg, err := NewTunnelguard(driver, nil, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	getStates := func() ([]PeerStatus, map[string]string) {
+		status, err := tg.Status()
+		if err != nil {
+			t.Fatal(err)
+		}
+		states := map[string]string{}
+		for _, peer := range status {
+			states[peer.PublicKey] = peer.State
+		}
+		return status, states
+	}
+
+	status, states := getStates()
+	want := map[string]string{"healthy": stateHealthy, "stale": stateStale, "never": stateNeverConnected}
+	if !reflect.DeepEqual(states, want) {
+		t.Errorf("Status() computed states = %v, want %v", states, want)
+	}
+	if status[0].NiceName != "Healthy Peer" || status[0].RuntimeEndpoint != "192.0.2.1:51820" || *status[0].HandshakeAgeSeconds != 60 {
+		t.Errorf("Status() unexpected status %+v", status[0])
+	}
+	if status[1].ConfiguredEndpoint != "host.example:51820" || status[1].ResetsTotal != 0 {
+		t.Errorf("Status() unexpected status %+v", status[1])
+	}
+
+	// once the loop ran, its states and counters are used
+	tg.conditionallyResetPeers()
+	status, states = getStates()
+	want["stale"] = stateResetting
+	if !reflect.DeepEqual(states, want) {
+		t.Errorf("Status() tracked states = %v, want %v", states, want)
+	}
+	if status[1].ResetsTotal != 1 {
+		t.Errorf("Status() resets = %d, want 1", status[1].ResetsTotal)
+	}
+}
+
+func TestWriteStatus(t *testing.T) {
+	age := 61.0
+	report := StatusReport{
+		Source: statusSourceLocal,
+		Peers: []PeerStatus{
+			{Interface: "wg0", PublicKey: "a", NiceName: "peer, a", RuntimeEndpoint: "192.0.2.1:51820", HandshakeAgeSeconds: &age, State: stateHealthy, ResetsTotal: 2},
+			{Interface: "wg0", PublicKey: "b", State: stateNeverConnected},
+		},
+		Errors: []string{"wg1: could not get peers"},
+	}
+
+	tests := []struct {
+		format string
+		want   []string
+	}{
+		{
+			format: statusFormatCsv,
+			want: []string{
+				"interface,nice_name,pub_key,configured_endpoint,runtime_endpoint,handshake_age_seconds,state,resets_total",
+				`wg0,"peer, a",a,,192.0.2.1:51820,61,healthy,2`,
+				"wg0,,b,,,,never_connected,0",
+			},
+		},
+		{
+			format: statusFormatTable,
+			want: []string{
+				"INTERFACE  NAME     PUBLIC KEY  CONFIGURED ENDPOINT  RUNTIME ENDPOINT  HANDSHAKE AGE  STATE            RESETS",
+				"wg0        peer, a  a           -                    192.0.2.1:51820   1m1s           healthy          2",
+				"wg0        -        b           -                    -                 never          never_connected  0",
+				"error: wg1: could not get peers",
+			},
+		},
+		{
+			format: statusFormatJson,
+			want:   []string{`"source": "local"`, `"handshake_age_seconds": 61`, `"handshake_age_seconds": null`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := writeStatus(&buf, report, tt.format); err != nil {
+				t.Fatal(err)
+			}
+			for _, line := range tt.want {
+				if !strings.Contains(buf.String(), line) {
+					t.Errorf("writeStatus() output does not contain %q:\n%s", line, buf.String())
+				}
+			}
+		})
+	}
+
+	if err := writeStatus(&bytes.Buffer{}, report, "xml"); err == nil {
+		t.Error("writeStatus() expected error for unknown format")
+	}
+}
+
+func TestFetchDaemonStatus(t *testing.T) {
+	driver := &fakeDriver{peers: []Peer{{PublicKey: "daemon", HandshakeLastSeen: handshakeAgo(time.Minute)}}}
+	tg, err := NewTunnelguard(driver, nil, InterfaceConfig{Interface: "wg-daemon", HandshakeTimeoutSeconds: 180, WaitSeconds: 30})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := NewHttpServer(":0", []*Tunnelguard{tg}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	httpServer := httptest.NewServer(server.handler())
+	defer httpServer.Close()
+
+	report, err := fetchDaemonStatus(strings.TrimPrefix(httpServer.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Source != statusSourceDaemon || len(report.Peers) != 1 || report.Peers[0].PublicKey != "daemon" {
+		t.Errorf("fetchDaemonStatus() = %+v", report)
+	}
+}
+
+func Test_statusUrl(t *testing.T) {
+	tests := []struct {
+		address string
+		want    string
+	}{
+		{address: ":9191", want: "http://localhost:9191/status"},
+		{address: "0.0.0.0:9191", want: "http://localhost:9191/status"},
+		{address: "[::]:9191", want: "http://localhost:9191/status"},
+		{address: "127.0.0.1:9191", want: "http://127.0.0.1:9191/status"},
+		{address: "[::1]:9191", want: "http://[::1]:9191/status"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.address, func(t *testing.T) {
+			if got := statusUrl(tt.address); got != tt.want {
+				t.Errorf("statusUrl() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
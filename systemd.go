@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	sdReady     = "READY=1"
+	sdWatchdog  = "WATCHDOG=1"
+	sdStopping  = "STOPPING=1"
+	sdStatusKey = "STATUS="
+)
+
+// SystemdNotifier sends state changes to systemd using the sd_notify protocol.
+type SystemdNotifier struct {
+	conn     net.Conn
+	watchdog time.Duration
+}
+
+// NewSystemdNotifier connects to the socket in $NOTIFY_SOCKET. It returns nil if the variable is not set, i.e. if
+// tunnelguard is not run as a systemd service of type notify.
+func NewSystemdNotifier() (*SystemdNotifier, error) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if len(socket) == 0 {
+		return nil, nil
+	}
+
+	watchdog, err := watchdogInterval(os.Getenv("WATCHDOG_USEC"), os.Getenv("WATCHDOG_PID"))
+	if err != nil {
+		return nil, err
+	}
+
+	notifier, err := newSystemdNotifier(socket)
+	if err != nil {
+		return nil, err
+	}
+	notifier.watchdog = watchdog
+	return notifier, nil
+}
+
+func newSystemdNotifier(socket string) (*SystemdNotifier, error) {
+	// a leading @ denotes an abstract socket, which is handled by the net package
+	conn, err := net.Dial("unixgram", socket)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to notify socket %q: %w", socket, err)
+	}
+	return &SystemdNotifier{conn: conn}, nil
+}
+
+// watchdogInterval parses the watchdog timeout that systemd passes to the service. It returns zero if the watchdog is
+// disabled or meant for another process.
+func watchdogInterval(usec string, pid string) (time.Duration, error) {
+	if len(usec) == 0 {
+		return 0, nil
+	}
+	if len(pid) > 0 && pid != strconv.Itoa(os.Getpid()) {
+		return 0, nil
+	}
+
+	parsed, err := strconv.ParseInt(usec, 10, 64)
+	if err != nil || parsed <= 0 {
+		return 0, fmt.Errorf("invalid WATCHDOG_USEC %q", usec)
+	}
+	return time.Duration(parsed) * time.Microsecond, nil
+}
+
+// WatchdogInterval returns the watchdog timeout of the service, zero if the watchdog is disabled.
+func (n *SystemdNotifier) WatchdogInterval() time.Duration {
+	return n.watchdog
+}
+
+// Notify sends the given assignments, e.g. READY=1, in a single datagram.
+func (n *SystemdNotifier) Notify(states ...string) error {
+	_, err := n.conn.Write([]byte(strings.Join(states, "\n")))
+	return err
+}
+
+// SystemdSupervisor reports the progress of all loops to systemd. It signals readiness once the peers of every
+// interface have been read and only pets the watchdog while the loops of all interfaces keep completing cycles, so a
+// single hung loop gets the service restarted. Interfaces whose peers can not be read are named in the status.
+type SystemdSupervisor struct {
+	mutex      sync.Mutex
+	notifier   *SystemdNotifier
+	ready      bool
+	interfaces []string
+	// succeeded holds the interfaces whose peers have been read at least once
+	succeeded map[string]bool
+	// cycles and reports hold the completion time and the report of the latest cycle of each interface
+	cycles  map[string]time.Time
+	reports map[string]*CycleReport
+}
+
+func NewSystemdSupervisor(notifier *SystemdNotifier, interfaces []string) *SystemdSupervisor {
+	return &SystemdSupervisor{
+		notifier:   notifier,
+		interfaces: slices.Sorted(slices.Values(interfaces)),
+		succeeded:  map[string]bool{},
+		cycles:     map[string]time.Time{},
+		reports:    map[string]*CycleReport{},
+	}
+}
+
+// CycleCompleted is invoked by the loops after each cycle.
+func (s *SystemdSupervisor) CycleCompleted(report *CycleReport) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	s.cycles[report.Interface] = now
+	s.reports[report.Interface] = report
+	if report.peersRead {
+		s.succeeded[report.Interface] = true
+	}
+
+	var states []string
+	if !s.ready && s.allSucceeded() {
+		s.ready = true
+		states = append(states, sdReady)
+		slog.Info("notified systemd about readiness")
+	}
+	if s.ready && s.notifier.WatchdogInterval() > 0 && s.allCycling(now) {
+		states = append(states, sdWatchdog)
+	}
+	states = append(states, sdStatusKey+s.summary(now))
+
+	if err := s.notifier.Notify(states...); err != nil {
+		slog.Warn("could not notify systemd", "err", err)
+	}
+}
+
+// allSucceeded returns whether the peers of every interface have been read at least once.
+func (s *SystemdSupervisor) allSucceeded() bool {
+	for _, iface := range s.interfaces {
+		if !s.succeeded[iface] {
+			return false
+		}
+	}
+	return true
+}
+
+// allCycling returns whether every interface completed a cycle within the watchdog interval.
+func (s *SystemdSupervisor) allCycling(now time.Time) bool {
+	for _, iface := range s.interfaces {
+		if now.Sub(s.cycles[iface]) >= s.notifier.WatchdogInterval() {
+			return false
+		}
+	}
+	return true
+}
+
+// summary returns a one-line summary such as "12 peers, 1 stale, wg1: get_peers failed, last reset 3m ago".
+func (s *SystemdSupervisor) summary(now time.Time) string {
+	peers, stale := 0, 0
+	var failing []string
+	var lastReset time.Time
+	for _, iface := range s.interfaces {
+		report, found := s.reports[iface]
+		if !found {
+			continue
+		}
+		if !report.peersRead {
+			failing = append(failing, iface+": "+failedOperation(report))
+		}
+		for _, peer := range report.Peers {
+			peers++
+			if peer.State == stateStale || peer.State == stateResetting {
+				stale++
+			}
+		}
+		for _, timestamp := range metrics.GetLastResets(iface) {
+			if timestamp.After(lastReset) {
+				lastReset = timestamp
+			}
+		}
+	}
+
+	summary := fmt.Sprintf("%d peers, %d stale", peers, stale)
+	for _, problem := range failing {
+		summary += ", " + problem
+	}
+	if lastReset.IsZero() {
+		return summary + ", no reset yet"
+	}
+	return summary + ", last reset " + formatAgo(now.Sub(lastReset)) + " ago"
+}
+
+// failedOperation describes the first error of the report by its operation, e.g. "get_peers failed", as the errors
+// may contain lengthy command output.
+func failedOperation(report *CycleReport) string {
+	if len(report.Errors) == 0 {
+		return "failing"
+	}
+	op, _, _ := strings.Cut(report.Errors[0], ":")
+	return op + " failed"
+}
+
+// formatAgo formats the duration using its largest unit, e.g. 3m.
+func formatAgo(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%ds", max(0, int(d.Seconds())))
+	case d < time.Hour:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh", int(d.Hours()))
+	default:
+		return fmt.Sprintf("%dd", int(d.Hours()/24))
+	}
+}
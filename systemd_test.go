@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNewSystemdNotifier(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	notifier, err := NewSystemdNotifier()
+	if notifier != nil || err != nil {
+		t.Fatalf("expected no notifier without NOTIFY_SOCKET, got %v, %v", notifier, err)
+	}
+
+	conn, socket := listenUnixgram(t)
+	t.Setenv("NOTIFY_SOCKET", socket)
+	t.Setenv("WATCHDOG_USEC", "30000000")
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+	notifier, err = NewSystemdNotifier()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := notifier.WatchdogInterval(); got != 30*time.Second {
+		t.Errorf("WatchdogInterval() = %v, want 30s", got)
+	}
+
+	if err := notifier.Notify(sdReady, sdStatusKey+"starting"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := readDatagram(t, conn), "READY=1\nSTATUS=starting"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func Test_watchdogInterval(t *testing.T) {
+	tests := []struct {
+		name    string
+		usec    string
+		pid     string
+		want    time.Duration
+		wantErr bool
+	}{
+		{name: "disabled"},
+		{name: "enabled", usec: "5000000", want: 5 * time.Second},
+		{name: "own pid", usec: "5000000", pid: strconv.Itoa(os.Getpid()), want: 5 * time.Second},
+		{name: "other pid", usec: "5000000", pid: "1"},
+		{name: "invalid", usec: "soon", wantErr: true},
+		{name: "zero", usec: "0", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := watchdogInterval(tt.usec, tt.pid)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("watchdogInterval() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("watchdogInterval() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSystemdSupervisor(t *testing.T) {
+	conn, socket := listenUnixgram(t)
+	notifier, err := newSystemdNotifier(socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	notifier.watchdog = time.Minute
+	supervisor := NewSystemdSupervisor(notifier, []string{"wg-sd0", "wg-sd1"})
+	metrics.SetLastReset("wg-sd1", "b", "", time.Now().Add(-3*time.Minute))
+
+	healthy := &CycleReport{Interface: "wg-sd0", peersRead: true, Peers: []PeerDecision{
+		{PublicKey: "a", State: stateHealthy},
+		{PublicKey: "c", State: stateNeverConnected},
+	}}
+	failing := &CycleReport{Interface: "wg-sd1", Errors: []string{"get_peers: failed"}}
+	recovered := &CycleReport{Interface: "wg-sd1", peersRead: true, Peers: []PeerDecision{{PublicKey: "b", State: stateResetting}}}
+
+	steps := []struct {
+		report *CycleReport
+		want   string
+	}{
+		// not ready before the peers of every interface have been read
+		{report: healthy, want: "STATUS=2 peers, 0 stale, no reset yet"},
+		{report: failing, want: "STATUS=2 peers, 0 stale, wg-sd1: get_peers failed, last reset 3m ago"},
+		{report: recovered, want: "READY=1\nWATCHDOG=1\nSTATUS=3 peers, 1 stale, last reset 3m ago"},
+		{report: healthy, want: "WATCHDOG=1\nSTATUS=3 peers, 1 stale, last reset 3m ago"},
+	}
+	for _, step := range steps {
+		supervisor.CycleCompleted(step.report)
+		if got := readDatagram(t, conn); got != step.want {
+			t.Errorf("got %q, want %q", got, step.want)
+		}
+	}
+
+	// a loop that stopped completing cycles withholds the watchdog
+	supervisor.cycles["wg-sd1"] = time.Now().Add(-2 * time.Minute)
+	supervisor.CycleCompleted(healthy)
+	if got, want := readDatagram(t, conn), "STATUS=3 peers, 1 stale, last reset 3m ago"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func Test_formatAgo(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{d: 42 * time.Second, want: "42s"},
+		{d: 3*time.Minute + 59*time.Second, want: "3m"},
+		{d: 5 * time.Hour, want: "5h"},
+		{d: 50 * time.Hour, want: "2d"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := formatAgo(tt.d); got != tt.want {
+				t.Errorf("formatAgo() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTunnelguard_LoopCycleHandler(t *testing.T) {
+	driver := &fakeDriver{peers: []Peer{{PublicKey: "healthy", HandshakeLastSeen: handshakeAgo(time.Minute)}}}
+	cycles := make(chan *CycleReport, 10)
+	tg, err := NewTunnelguard(driver, nil, InterfaceConfig{Interface: "wg-cycles", HandshakeTimeoutSeconds: 180, WaitSeconds: 30},
+		WithCycleHandler(func(report *CycleReport) { cycles <- report }),
+		WithMaxCycleInterval(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	wait := &sync.WaitGroup{}
+	wait.Add(1)
+	go tg.Loop(ctx, wait)
+	defer func() {
+		cancel()
+		wait.Wait()
+	}()
+
+	// the healthy peer would not be checked for minutes without the max cycle interval
+	for range 3 {
+		select {
+		case report := <-cycles:
+			if !report.peersRead || report.Interface != "wg-cycles" {
+				t.Errorf("unexpected report %+v", report)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("no cycle completed")
+		}
+	}
+}
@@ -3,16 +3,27 @@ package main
 import (
 	"context"
 	"errors"
+	"fmt"
 	"log/slog"
 	"net"
+	"net/netip"
 	"regexp"
+	"strconv"
 	"sync"
 	"time"
 )
 
 const (
-	handshakeTimeout   = 180 * time.Second
-	defaultWaitSeconds = 30
+	actionResetPeer   = "reset_peer"
+	actionStartTunnel = "start_tunnel"
+
+	reasonHandshakeStale      = "handshake_stale"
+	reasonAddressChanged      = "address_changed"
+	reasonGetPeersFailed      = "get_peers_failed"
+	reasonGetEndpointFailed   = "get_endpoint_failed"
+	reasonResetPeerFailed     = "reset_peer_failed"
+	reasonDnsResolutionFailed = "dns_resolution_failed"
+	reasonProbeFailed         = "probe_failed"
 )
 
 var hostnameRegex = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?$`)
@@ -33,130 +44,635 @@ type Peer struct {
 	PublicKey         string
 	HandshakeLastSeen *time.Time
 	Endpoint          *string
+	RxBytes           int64
+	TxBytes           int64
 }
 
 type Tunnelguard struct {
-	wg            WireguardDriver
-	niceNames     map[string]string
-	once          sync.Once
-	metricsWriter *MetricsWriter
+	wg               WireguardDriver
+	iface            string
+	niceNames        map[string]string
+	handshakeTimeout time.Duration
+	waitInterval     time.Duration
+	peers            map[string]PeerConfig
+	resolver         HostResolver
+	dryRun           bool
+	backoffMax       time.Duration
+	backoff          map[string]*peerBackoff
+	limiter          *ResetLimiter
+	probes           map[string]*peerProbe
+	filter           PeerFilterConfig
+	escalation       []EscalationStep
+	restartWindow    time.Duration
+	lastRestart      time.Time
+	configFile       string
+	stateStore       *StateStore
+	// goodEndpoints holds the address of the endpoint each peer used while it was healthy
+	goodEndpoints map[string]string
+	failover      map[string]*peerFailover
+	// endpointTypes caches the type of each peer's configured endpoint until the config is reloaded
+	endpointTypes map[string]string
+
+	// mutex guards the settings that are replaced on reload against readers outside the loop
+	mutex   sync.Mutex
+	reloads chan *tunnelguardSettings
+
+	states             map[string]*peerState
+	transitionHandlers []TransitionHandler
+	eventHandlers      []EventHandler
+	cycleHandlers      []CycleHandler
+	// maxCycleInterval caps the time between two cycles of the loop if set
+	maxCycleInterval time.Duration
+	once             sync.Once
+	metricsWriter    *MetricsWriter
+}
+
+type TunnelguardOpt func(*Tunnelguard) error
+
+// WithResolver enables resetting peers only if the address of their endpoint has changed.
+func WithResolver(resolver HostResolver) TunnelguardOpt {
+	return func(t *Tunnelguard) error {
+		if resolver == nil {
+			return errors.New("nil resolver provided")
+		}
+		t.resolver = resolver
+		return nil
+	}
+}
+
+// WithDryRun only logs and counts the actions that would have been taken without touching the interface.
+func WithDryRun() TunnelguardOpt {
+	return func(t *Tunnelguard) error {
+		t.dryRun = true
+		return nil
+	}
+}
+
+// WithResetLimiter limits the number of resets, the limiter may be shared across interfaces.
+func WithResetLimiter(limiter *ResetLimiter) TunnelguardOpt {
+	return func(t *Tunnelguard) error {
+		if limiter == nil {
+			return errors.New("nil limiter provided")
+		}
+		t.limiter = limiter
+		return nil
+	}
+}
+
+// WithTransitionHandler registers a handler that is invoked whenever a peer changes its health state.
+func WithTransitionHandler(handler TransitionHandler) TunnelguardOpt {
+	return func(t *Tunnelguard) error {
+		if handler == nil {
+			return errors.New("nil transition handler provided")
+		}
+		t.transitionHandlers = append(t.transitionHandlers, handler)
+		return nil
+	}
+}
+
+// WithEventHandler registers a handler that is invoked for each reset and tunnel start.
+func WithEventHandler(handler EventHandler) TunnelguardOpt {
+	return func(t *Tunnelguard) error {
+		if handler == nil {
+			return errors.New("nil event handler provided")
+		}
+		t.eventHandlers = append(t.eventHandlers, handler)
+		return nil
+	}
+}
+
+// WithCycleHandler registers a handler that is invoked after each cycle of the loop.
+func WithCycleHandler(handler CycleHandler) TunnelguardOpt {
+	return func(t *Tunnelguard) error {
+		if handler == nil {
+			return errors.New("nil cycle handler provided")
+		}
+		t.cycleHandlers = append(t.cycleHandlers, handler)
+		return nil
+	}
+}
+
+// WithMaxCycleInterval lets the loop run a cycle at least once per interval, even if no peer can become stale.
+func WithMaxCycleInterval(interval time.Duration) TunnelguardOpt {
+	return func(t *Tunnelguard) error {
+		if interval <= 0 {
+			return fmt.Errorf("invalid max cycle interval %v", interval)
+		}
+		t.maxCycleInterval = interval
+		return nil
+	}
+}
+
+func NewTunnelguard(driver WireguardDriver, metricsWriter *MetricsWriter, conf InterfaceConfig, opts ...TunnelguardOpt) (*Tunnelguard, error) {
+	if driver == nil {
+		return nil, errors.New("empty wg driver provided")
+	}
+
+	if len(conf.Interface) == 0 {
+		return nil, errors.New("empty interface name provided")
+	}
+
+	settings, err := newTunnelguardSettings(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	tunnelguard := &Tunnelguard{
+		wg:            driver,
+		iface:         conf.Interface,
+		backoff:       map[string]*peerBackoff{},
+		probes:        map[string]*peerProbe{},
+		states:        map[string]*peerState{},
+		goodEndpoints: map[string]string{},
+		failover:      map[string]*peerFailover{},
+		reloads:       make(chan *tunnelguardSettings, 1),
+		metricsWriter: metricsWriter,
+	}
+	tunnelguard.applySettings(settings)
+
+	var errs error
+	for _, opt := range opts {
+		if err := opt(tunnelguard); err != nil {
+			errs = errors.Join(errs, err)
+		}
+	}
+
+	if errs != nil {
+		return nil, errs
+	}
+
+	return tunnelguard, nil
 }
 
 func (t *Tunnelguard) Loop(ctx context.Context, wg *sync.WaitGroup) {
 	t.once.Do(func() {
 		defer wg.Done()
 
-		maxHandshakeAge := t.conditionallyResetPeers()
-		delay := time.Second * time.Duration(maxHandshakeAge)
+		delay := t.loopCycle()
 		silenceMetricsWriterWarnLogs := false
 
 		for {
 			select {
 			case <-ctx.Done():
 				return
+			case settings := <-t.reloads:
+				t.applyReload(settings)
 			case <-time.After(delay):
-				maxHandshakeAge := t.conditionallyResetPeers()
-				delay = time.Second * time.Duration(maxHandshakeAge)
-
-				if t.metricsWriter != nil {
-					if err := t.metricsWriter.Dump(); err != nil && !silenceMetricsWriterWarnLogs {
-						silenceMetricsWriterWarnLogs = true
-						slog.Warn("can not write metrics data", "err", err)
-					} else {
-						silenceMetricsWriterWarnLogs = false
-					}
+			}
+
+			delay = t.loopCycle()
+
+			if t.metricsWriter != nil {
+				if err := t.metricsWriter.Dump(); err != nil && !silenceMetricsWriterWarnLogs {
+					silenceMetricsWriterWarnLogs = true
+					slog.Warn("can not write metrics data", "interface", t.iface, "err", err)
+				} else {
+					silenceMetricsWriterWarnLogs = false
 				}
 			}
 		}
 	})
 }
 
-func (t *Tunnelguard) conditionallyFixTunnel() {
+// loopCycle runs a cycle of the loop, passes its report to the cycle handlers and returns the delay until the next
+// cycle.
+func (t *Tunnelguard) loopCycle() time.Duration {
+	report := t.runCycle()
+	for _, handler := range t.cycleHandlers {
+		handler(report)
+	}
+
+	if t.maxCycleInterval > 0 {
+		return min(report.NextCheck, t.maxCycleInterval)
+	}
+	return report.NextCheck
+}
+
+// RunOnce performs a single pass over all peers and returns its report.
+func (t *Tunnelguard) RunOnce() *CycleReport {
+	return t.runCycle()
+}
+
+// runCycle performs a single pass over all peers and persists the resulting state.
+func (t *Tunnelguard) runCycle() *CycleReport {
+	report := t.conditionallyResetPeers()
+	t.saveState()
+	return report
+}
+
+// conditionallyFixTunnel starts the tunnel if it is down. The reason describes the failure that triggered the check.
+func (t *Tunnelguard) conditionallyFixTunnel(report *CycleReport, reason string) {
 	connected, err := t.wg.IsTunnelUp()
 	if err != nil {
-		slog.Error("error while checking if tunnel is up", "error", err)
+		slog.Error("error while checking if tunnel is up", "interface", t.iface, "error", err)
+		report.addError("is_tunnel_up", err)
+	} else {
+		metrics.SetTunnelUp(t.iface, connected)
 	}
 
 	if connected {
 		return
 	}
 
-	slog.Warn("Tunnel appears to be down, trying to start tunnel")
+	if t.dryRun {
+		metrics.IncDryRunAction(dryRunKey{Interface: t.iface, Action: actionStartTunnel, Reason: reason})
+		slog.Warn("Dry-run: tunnel appears to be down, would start tunnel", "interface", t.iface, "reason", reason)
+		return
+	}
+
+	slog.Warn("Tunnel appears to be down, trying to start tunnel", "interface", t.iface, "reason", reason)
+	report.TunnelStarted = true
+	event := Event{
+		Type:      eventTunnelStarted,
+		Interface: t.iface,
+		Reason:    reason,
+	}
 	if err := t.wg.StartTunnel(); err != nil {
-		slog.Error("starting tunnel failed", "error", err)
+		slog.Error("starting tunnel failed", "interface", t.iface, "error", err)
+		report.addError("start_tunnel", err)
+		event.Error = err.Error()
+	}
+	t.emit(event)
+}
+
+// emit passes the event to all registered handlers.
+func (t *Tunnelguard) emit(event Event) {
+	event.Timestamp = time.Now()
+	for _, handler := range t.eventHandlers {
+		handler(event)
 	}
 }
 
-func (t *Tunnelguard) conditionallyResetPeers() float64 {
-	metrics.Heartbeat = time.Now().Unix()
+// conditionallyResetPeers resets all stale peers and returns a report whose NextCheck holds the duration to wait
+// until the next peer can become stale.
+func (t *Tunnelguard) conditionallyResetPeers() *CycleReport {
+	report := &CycleReport{
+		Interface: t.iface,
+		Peers:     []PeerDecision{},
+	}
+
+	metrics.SetHeartbeat(t.iface, time.Now().Unix())
+	start := time.Now()
 	peers, err := t.wg.GetPeers()
 
 	if err != nil {
-		slog.Error("can't get WireGuard peers", "error", err)
-		t.conditionallyFixTunnel()
-		metrics.ErrorsTotal["get_peers"]++
-		return defaultWaitSeconds
+		metrics.SetGetPeersDuration(t.iface, time.Since(start))
+		slog.Error("can't get WireGuard peers", "interface", t.iface, "error", err)
+		report.addError("get_peers", err)
+		t.conditionallyFixTunnel(report, reasonGetPeersFailed)
+		metrics.IncError(t.iface, "get_peers")
+		report.NextCheck = t.waitInterval
+		return report
 	}
 
-	var maxHandshakeAge float64 = 0
+	metrics.SetPeers(t.iface, len(peers), time.Since(start))
+	report.peersRead = true
+	// the peers can only be read from an interface that is up
+	metrics.SetTunnelUp(t.iface, true)
+
+	nextCheck := t.handshakeTimeout + time.Second
 	for _, peer := range peers {
-		hasLastSeen := peer.HandshakeLastSeen != nil
+		if !t.isMonitored(peer.PublicKey) {
+			slog.Debug("peer is not monitored", "interface", t.iface, t.logPeer(peer.PublicKey))
+			if t.filter.ExportExcluded && peer.HandshakeLastSeen != nil {
+				metrics.SetLatestHandshake(t.iface, peer.PublicKey, t.niceNames[peer.PublicKey], peer.HandshakeLastSeen.Unix())
+			}
+			continue
+		}
+
+		decision := PeerDecision{
+			PublicKey: peer.PublicKey,
+			NiceName:  t.niceNames[peer.PublicKey],
+		}
+		t.exportPeerMetrics(peer)
+
+		if peer.HandshakeLastSeen == nil {
+			decision.Decision = decisionNeverConnected
+			decision.State = stateNeverConnected
+			t.updatePeerState(peer.PublicKey, decision.State)
+			report.Peers = append(report.Peers, decision)
+			continue
+		}
+
+		timeout := t.getHandshakeTimeout(peer.PublicKey)
+		timeSinceHandshake := time.Since(*peer.HandshakeLastSeen)
+		age := timeSinceHandshake.Truncate(time.Second).Seconds()
+		decision.HandshakeAgeSeconds = &age
+		slog.Debug("time since latest handshake", "interface", t.iface, "latest_handshake", timeSinceHandshake, "timeout", timeout, t.logPeer(peer.PublicKey))
+		metrics.SetLatestHandshake(t.iface, peer.PublicKey, t.niceNames[peer.PublicKey], peer.HandshakeLastSeen.Unix())
 
-		if hasLastSeen {
-			timeSinceHandshake := time.Since(*peer.HandshakeLastSeen)
-			slog.Debug("time since latest handshake", "latest_handshake", timeSinceHandshake, "peer", peer.PublicKey)
-			if timeSinceHandshake.Seconds() > maxHandshakeAge {
-				maxHandshakeAge = timeSinceHandshake.Seconds()
+		remaining := timeout - timeSinceHandshake
+		staleReason := reasonHandshakeStale
+		if probe, found := t.probes[peer.PublicKey]; found && remaining > 0 {
+			t.probePeer(probe, peer.PublicKey)
+			nextCheck = min(nextCheck, probe.untilDue())
+			if probe.failing() {
+				staleReason = reasonProbeFailed
 			}
-			if metrics.LatestHandshakeTimestamp[peer.PublicKey] == nil {
-				metrics.LatestHandshakeTimestamp[peer.PublicKey] = &peerMetricValue{}
+		}
+
+		stale := remaining <= 0 || staleReason == reasonProbeFailed
+		metrics.SetPeerHealth(t.iface, peer.PublicKey, t.niceNames[peer.PublicKey], timeSinceHandshake, stale)
+		if stale {
+			backoff := t.getBackoff(peer, staleReason)
+			if wait := backoff.remaining(t.waitInterval, t.backoffMax); wait > 0 {
+				slog.Debug("not resetting peer, backing off", "interface", t.iface, "remaining", wait, "resets", backoff.consecutiveResets, t.logPeer(peer.PublicKey))
+				decision.Decision, decision.Reason = decisionSkippedBackoff, staleReason
+				nextCheck = min(nextCheck, wait)
+			} else {
+				stepIdx, step := t.escalationStep(peer.PublicKey, backoff.consecutiveResets)
+				decision.Decision, decision.Reason = t.resetPeer(report, peer, staleReason, step.Action)
+				for isSkippedReset(decision.Decision) {
+					attempts, found := t.nextStepAttempts(peer.PublicKey, stepIdx)
+					if !found {
+						break
+					}
+					backoff.consecutiveResets = attempts
+					stepIdx, step = t.escalationStep(peer.PublicKey, attempts)
+					decision.Decision, decision.Reason = t.resetPeer(report, peer, staleReason, step.Action)
+				}
+				metrics.SetEscalationStep(t.iface, peer.PublicKey, t.niceNames[peer.PublicKey], stepIdx)
+				decision.Action = step.Action
+				if isResetAttempt(decision.Decision) {
+					backoff.consecutiveResets++
+					backoff.lastReset = time.Now()
+					backoff.handshakeAtReset = *peer.HandshakeLastSeen
+					backoff.cooldownUntil = backoff.lastReset.Add(step.cooldown())
+				}
+				nextCheck = min(nextCheck, max(t.waitInterval, backoff.remaining(t.waitInterval, t.backoffMax)))
 			}
-			metrics.LatestHandshakeTimestamp[peer.PublicKey].Value = peer.HandshakeLastSeen.Unix()
-			metrics.LatestHandshakeTimestamp[peer.PublicKey].NiceName = t.niceNames[peer.PublicKey]
+			metrics.SetResetBackoff(t.iface, peer.PublicKey, t.niceNames[peer.PublicKey], backoff.delay(t.waitInterval, t.backoffMax))
+		} else {
+			delete(t.backoff, peer.PublicKey)
+			metrics.SetResetBackoff(t.iface, peer.PublicKey, t.niceNames[peer.PublicKey], 0)
+			metrics.SetEscalationStep(t.iface, peer.PublicKey, t.niceNames[peer.PublicKey], 0)
+			decision.Decision = decisionHealthy
+			t.recordGoodEndpoint(peer)
+			nextCheck = min(nextCheck, remaining+time.Second)
+		}
+		if wait := t.trackFailover(report, peer, stale); wait > 0 {
+			nextCheck = min(nextCheck, wait)
+		}
+
+		decision.State = stateForDecision(decision.Decision)
+		t.updatePeerState(peer.PublicKey, decision.State)
+		report.Peers = append(report.Peers, decision)
+	}
+
+	report.NextCheck = nextCheck
+	return report
+}
+
+// exportPeerMetrics exports the transfer and endpoint type of the peer. The configured endpoint is only looked up
+// the first time a peer is seen after startup or a reload.
+func (t *Tunnelguard) exportPeerMetrics(peer Peer) {
+	niceName := t.niceNames[peer.PublicKey]
+	metrics.SetTransfer(t.iface, peer.PublicKey, niceName, peer.RxBytes, peer.TxBytes)
+
+	endpointType, found := t.endpointTypes[peer.PublicKey]
+	if !found {
+		endpoint, err := t.wg.GetEndpoint(peer.PublicKey)
+		if err != nil {
+			return
+		}
+		endpointType = getEndpointType(endpoint)
+		t.endpointTypes[peer.PublicKey] = endpointType
+	}
+	metrics.SetEndpointType(t.iface, peer.PublicKey, niceName, endpointType)
+}
+
+// getEndpointType returns the type of the configured endpoint.
+func getEndpointType(endpoint string) string {
+	if len(endpoint) == 0 {
+		return endpointTypeDynamic
+	}
+	if static, err := isStaticEndpoint(endpoint); err == nil && static {
+		return endpointTypeStatic
+	}
+	return endpointTypeDns
+}
+
+// getBackoff returns the backoff state of the peer. The state is reset if a new handshake happened since the last
+// reset, unless the peer is stale because of failing probes as a handshake does not prove reachability.
+func (t *Tunnelguard) getBackoff(peer Peer, staleReason string) *peerBackoff {
+	backoff, found := t.backoff[peer.PublicKey]
+	if !found || (backoff.consecutiveResets > 0 && staleReason == reasonHandshakeStale && peer.HandshakeLastSeen.After(backoff.handshakeAtReset)) {
+		backoff = &peerBackoff{}
+		t.backoff[peer.PublicKey] = backoff
+	}
+	return backoff
+}
+
+func isResetAttempt(decision string) bool {
+	return decision == decisionReset || decision == decisionResetFailed || decision == decisionDryRunReset
+}
+
+// getHandshakeTimeout returns the effective handshake timeout for the given peer.
+func (t *Tunnelguard) getHandshakeTimeout(publicKey string) time.Duration {
+	if peer, found := t.peers[publicKey]; found && peer.HandshakeTimeoutSeconds > 0 {
+		return time.Duration(peer.HandshakeTimeoutSeconds) * time.Second
+	}
+	return t.handshakeTimeout
+}
+
+// probePeer runs the peer's probe if it is due.
+func (t *Tunnelguard) probePeer(probe *peerProbe, publicKey string) {
+	if probe.untilDue() > 0 {
+		return
+	}
+
+	rtt, err := probe.run()
+	metrics.SetProbeResult(t.iface, publicKey, t.niceNames[publicKey], err == nil, rtt)
+	if err != nil {
+		slog.Warn("probe failed", "interface", t.iface, "target", probe.target, "failures", probe.consecutiveFailures, t.logPeer(publicKey), "error", err)
+		return
+	}
+	slog.Debug("probe succeeded", "interface", t.iface, "target", probe.target, "rtt", rtt, t.logPeer(publicKey))
+}
+
+// resetPeer performs the action of the escalation step for a stale peer and returns the decision that has been taken
+// and its reason. The staleReason describes why the peer is considered stale.
+func (t *Tunnelguard) resetPeer(report *CycleReport, peer Peer, staleReason string, action string) (string, string) {
+	endpoint := ""
+	reason := staleReason
+	if needsEndpoint(action) {
+		var decision string
+		endpoint, decision, reason = t.getResetEndpoint(report, peer, staleReason, action)
+		if len(decision) > 0 {
+			return decision, reason
 		}
+	}
 
-		if hasLastSeen && time.Since(*peer.HandshakeLastSeen) >= handshakeTimeout {
-			t.resetPeer(peer)
+	if action == actionRestartInterface {
+		if allowed, remaining := t.restartAllowed(); !allowed {
+			metrics.IncRemediation(t.iface, peer.PublicKey, t.niceNames[peer.PublicKey], action, decisionSkippedRestartWindow)
+			slog.Warn("not restarting interface, it has been restarted recently", "interface", t.iface, "remaining", remaining, t.logPeer(peer.PublicKey))
+			return decisionSkippedRestartWindow, reason
 		}
 	}
 
-	if handshakeTimeout.Seconds()-maxHandshakeAge <= 0 {
-		return defaultWaitSeconds
+	if t.dryRun {
+		metrics.IncDryRunAction(dryRunKey{
+			Interface: t.iface,
+			Action:    action,
+			Reason:    reason,
+			PublicKey: peer.PublicKey,
+			NiceName:  t.niceNames[peer.PublicKey],
+		})
+		slog.Info("Dry-run: would reset peer", "interface", t.iface, "action", action, "endpoint", endpoint, "reason", reason, t.logPeer(peer.PublicKey))
+		return decisionDryRunReset, reason
+	}
+
+	if !t.allowReset(peer.PublicKey, action, endpoint) {
+		return decisionSkippedRateLimit, reason
+	}
+
+	if action == actionResetPeer {
+		metrics.IncPeerResets(t.iface, peer.PublicKey, t.niceNames[peer.PublicKey])
 	}
-	return (handshakeTimeout.Seconds() - maxHandshakeAge) + 1
+	if action == actionRestartInterface {
+		t.lastRestart = time.Now()
+	}
+	metrics.SetLastReset(t.iface, peer.PublicKey, t.niceNames[peer.PublicKey], time.Now())
+	slog.Info("resetting peer", "interface", t.iface, "action", action, "endpoint", endpoint, t.logPeer(peer.PublicKey))
+	event := Event{
+		Type:      eventPeerReset,
+		Interface: t.iface,
+		PublicKey: peer.PublicKey,
+		NiceName:  t.niceNames[peer.PublicKey],
+		Endpoint:  endpoint,
+		Action:    action,
+		Reason:    reason,
+	}
+	start := time.Now()
+	err := t.remediate(action, peer.PublicKey, endpoint)
+	metrics.SetResetDuration(t.iface, peer.PublicKey, t.niceNames[peer.PublicKey], time.Since(start))
+	if err != nil {
+		metrics.IncPeerResetFailures(t.iface, peer.PublicKey, t.niceNames[peer.PublicKey])
+		slog.Error("failed to reset peer", "interface", t.iface, "action", action, t.logPeer(peer.PublicKey), "error", err)
+		metrics.IncRemediation(t.iface, peer.PublicKey, t.niceNames[peer.PublicKey], action, remediationFailure)
+		metrics.IncError(t.iface, action)
+		report.addError(action, err)
+		event.Type = eventPeerResetFailed
+		event.Error = err.Error()
+		t.emit(event)
+		t.conditionallyFixTunnel(report, reasonResetPeerFailed)
+		return decisionResetFailed, reason
+	}
+
+	metrics.IncRemediation(t.iface, peer.PublicKey, t.niceNames[peer.PublicKey], action, remediationSuccess)
+	if failover := t.getFailover(peer.PublicKey); failover != nil && needsEndpoint(action) {
+		failover.activate(endpoint)
+	}
+	t.emit(event)
+	return decisionReset, reason
 }
 
-func (t *Tunnelguard) resetPeer(peer Peer) {
+// allowReset consults the global reset limiter before any action is taken on behalf of a peer, including the
+// interface wide escalation steps.
+func (t *Tunnelguard) allowReset(publicKey string, action string, endpoint string) bool {
+	if t.limiter == nil || t.limiter.Allow() {
+		return true
+	}
+	metrics.IncPeerResetsRateLimited(t.iface, publicKey, t.niceNames[publicKey])
+	slog.Warn("not resetting peer, global reset rate limit reached", "interface", t.iface, "action", action, "endpoint", endpoint, t.logPeer(publicKey))
+	return false
+}
+
+// getResetEndpoint returns the endpoint a stale peer should be reset to. If the peer should not be reset, the
+// decision is returned instead. The returned reason replaces the staleReason if the endpoint's address changed. Peers
+// with candidate endpoints are always reset to their next candidate. Only reset_peer is skipped for peers without a
+// dynamic endpoint or with an unchanged address, readd_peer re-adds them with their configured endpoint.
+func (t *Tunnelguard) getResetEndpoint(report *CycleReport, peer Peer, staleReason string, action string) (string, string, string) {
+	if failover := t.getFailover(peer.PublicKey); failover != nil {
+		return failover.next(), "", staleReason
+	}
+
 	endpoint, err := t.wg.GetEndpoint(peer.PublicKey)
 	if err != nil {
-		metrics.ErrorsTotal["get_endpoint"]++
-		slog.Error("could not get endpoint", "pub_key", peer.PublicKey)
+		metrics.IncError(t.iface, "get_endpoint")
+		slog.Error("could not get endpoint", "interface", t.iface, t.logPeer(peer.PublicKey))
+		report.addError("get_endpoint", err)
 
-		t.conditionallyFixTunnel()
-		return
+		t.conditionallyFixTunnel(report, reasonGetEndpointFailed)
+		return "", decisionError, reasonGetEndpointFailed
 	}
 
+	readd := action == actionReaddPeer
 	if len(endpoint) == 0 {
-		return
+		if readd {
+			return "", "", staleReason
+		}
+		return "", decisionSkippedNoEndpoint, staleReason
 	}
 
 	endpointIsStatic, _ := isStaticEndpoint(endpoint)
 	if endpointIsStatic {
-		slog.Debug("not resetting peer, endpoint is static", "endpoint", endpoint, "pub_key", peer.PublicKey)
-		return
+		if readd {
+			return endpoint, "", staleReason
+		}
+		slog.Debug("not resetting peer, endpoint is static", "interface", t.iface, "endpoint", endpoint, t.logPeer(peer.PublicKey))
+		return "", decisionSkippedStaticEndpoint, staleReason
+	}
+
+	if t.resolver == nil {
+		return endpoint, "", staleReason
+	}
+
+	resolved, changed, err := t.resolveEndpoint(endpoint, peer.Endpoint)
+	if err != nil {
+		metrics.IncDnsResolutionFailures(t.iface, peer.PublicKey, t.niceNames[peer.PublicKey])
+		slog.Error("could not resolve endpoint", "interface", t.iface, "endpoint", endpoint, t.logPeer(peer.PublicKey), "error", err)
+		report.addError("resolve_endpoint", err)
+		return "", decisionError, reasonDnsResolutionFailed
+	}
+
+	if !changed && readd {
+		return resolved, "", staleReason
+	}
+	if !changed {
+		metrics.IncPeerResetsSkipped(t.iface, peer.PublicKey, t.niceNames[peer.PublicKey])
+		slog.Info("not resetting peer, address of endpoint did not change", "interface", t.iface, "endpoint", endpoint, "address", resolved, t.logPeer(peer.PublicKey))
+		return "", decisionSkippedAddressUnchanged, staleReason
+	}
+	return resolved, "", reasonAddressChanged
+}
+
+// resolveEndpoint resolves the hostname of the configured endpoint and returns the preferred resolved endpoint and
+// whether the runtime endpoint differs from all resolved addresses.
+func (t *Tunnelguard) resolveEndpoint(endpoint string, runtimeEndpoint *string) (string, bool, error) {
+	host, portStr, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		return "", false, err
+	}
+
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return "", false, fmt.Errorf("invalid port %q: %w", portStr, err)
+	}
+
+	addrs, err := t.resolver.Resolve(host)
+	if err != nil {
+		return "", false, err
+	}
+	resolved := netip.AddrPortFrom(addrs[0], uint16(port)).String()
+
+	if runtimeEndpoint == nil {
+		return resolved, true, nil
 	}
 
-	if metrics.PeerResets[peer.PublicKey] == nil {
-		metrics.PeerResets[peer.PublicKey] = &peerMetricValue{}
+	current, err := netip.ParseAddrPort(*runtimeEndpoint)
+	if err != nil {
+		return resolved, true, nil
 	}
-	metrics.PeerResets[peer.PublicKey].Value = metrics.PeerResets[peer.PublicKey].Value + 1
-	metrics.PeerResets[peer.PublicKey].NiceName = t.niceNames[peer.PublicKey]
-	slog.Info("resetting peer", "endpoint", endpoint, "pub_key", peer.PublicKey)
-	if err := t.wg.ResetPeer(peer.PublicKey, endpoint); err != nil {
-		slog.Error("failed to reset peer", "error", err)
-		metrics.ErrorsTotal["reset_peer"]++
-		t.conditionallyFixTunnel()
+
+	for _, addr := range addrs {
+		if addr == current.Addr().Unmap() {
+			return netip.AddrPortFrom(addr, uint16(port)).String(), false, nil
+		}
 	}
+
+	return resolved, true, nil
 }
 
 func isStaticEndpoint(endpoint string) (bool, error) {
@@ -1,6 +1,15 @@
 package main
 
-import "testing"
+import (
+	"bytes"
+	"errors"
+	"math"
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
 
 func Test_isStaticEndpoint(t *testing.T) {
 	type args struct {
@@ -90,3 +99,293 @@ func Test_isStaticEndpoint(t *testing.T) {
 		})
 	}
 }
+
+type fakeDriver struct {
+	peers     []Peer
+	peersErr  error
+	endpoints map[string]string
+	tunnelUp  bool
+
+	resets          []string
+	resetEndpoints  []string
+	tunnelStarts    int
+	endpointLookups int
+}
+
+func (f *fakeDriver) GetPeers() ([]Peer, error) {
+	return f.peers, f.peersErr
+}
+
+func (f *fakeDriver) ResetPeer(publicKey string, endpoint string) error {
+	f.resets = append(f.resets, publicKey)
+	f.resetEndpoints = append(f.resetEndpoints, endpoint)
+	return nil
+}
+
+func (f *fakeDriver) GetEndpoint(publicKey string) (string, error) {
+	f.endpointLookups++
+	return f.endpoints[publicKey], nil
+}
+
+func (f *fakeDriver) StartTunnel() error {
+	f.tunnelStarts++
+	return nil
+}
+
+func (f *fakeDriver) IsTunnelUp() (bool, error) {
+	return f.tunnelUp, nil
+}
+
+func handshakeAgo(d time.Duration) *time.Time {
+	ts := time.Now().Add(-d)
+	return &ts
+}
+
+func TestTunnelguard_conditionallyResetPeers(t *testing.T) {
+	tests := []struct {
+		name       string
+		conf       InterfaceConfig
+		driver     *fakeDriver
+		wantResets []string
+		wantDelay  float64
+	}{
+		{
+			name: "no peers",
+			conf: InterfaceConfig{
+				HandshakeTimeoutSeconds: 180,
+				WaitSeconds:             30,
+			},
+			driver:    &fakeDriver{},
+			wantDelay: 181,
+		},
+		{
+			name: "get peers fails",
+			conf: InterfaceConfig{
+				HandshakeTimeoutSeconds: 180,
+				WaitSeconds:             15,
+			},
+			driver:    &fakeDriver{peersErr: errors.New("wg not found"), tunnelUp: true},
+			wantDelay: 15,
+		},
+		{
+			name: "healthy peer",
+			conf: InterfaceConfig{
+				HandshakeTimeoutSeconds: 150,
+				WaitSeconds:             30,
+			},
+			driver: &fakeDriver{
+				peers: []Peer{
+					{PublicKey: "a", HandshakeLastSeen: handshakeAgo(100 * time.Second)},
+				},
+			},
+			wantDelay: 51,
+		},
+		{
+			name: "stale peer",
+			conf: InterfaceConfig{
+				HandshakeTimeoutSeconds: 150,
+				WaitSeconds:             30,
+			},
+			driver: &fakeDriver{
+				peers: []Peer{
+					{PublicKey: "a", HandshakeLastSeen: handshakeAgo(160 * time.Second)},
+				},
+				endpoints: map[string]string{"a": "host.example:51820"},
+			},
+			wantResets: []string{"a"},
+			wantDelay:  30,
+		},
+		{
+			name: "per peer timeout",
+			conf: InterfaceConfig{
+				HandshakeTimeoutSeconds: 150,
+				WaitSeconds:             30,
+				Peers: map[string]PeerConfig{
+					"satellite": {HandshakeTimeoutSeconds: 600},
+				},
+			},
+			driver: &fakeDriver{
+				peers: []Peer{
+					{PublicKey: "satellite", HandshakeLastSeen: handshakeAgo(500 * time.Second)},
+					{PublicKey: "lte", HandshakeLastSeen: handshakeAgo(140 * time.Second)},
+				},
+				endpoints: map[string]string{"satellite": "sat.example:51820", "lte": "lte.example:51820"},
+			},
+			wantDelay: 11,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.conf.Interface = "wg0"
+			tg, err := NewTunnelguard(tt.driver, nil, tt.conf)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := tg.conditionallyResetPeers().NextCheck.Seconds()
+			if math.Abs(got-tt.wantDelay) > 1 {
+				t.Errorf("conditionallyResetPeers() got = %v, want %v", got, tt.wantDelay)
+			}
+			if !reflect.DeepEqual(tt.driver.resets, tt.wantResets) {
+				t.Errorf("conditionallyResetPeers() resets = %v, want %v", tt.driver.resets, tt.wantResets)
+			}
+		})
+	}
+}
+
+type fakeResolver struct {
+	addrs []netip.Addr
+	err   error
+}
+
+func (f *fakeResolver) Resolve(host string) ([]netip.Addr, error) {
+	return f.addrs, f.err
+}
+
+func TestTunnelguard_resetPeer_addressChange(t *testing.T) {
+	tests := []struct {
+		name          string
+		runtime       *string
+		resolver      *fakeResolver
+		wantEndpoints []string
+	}{
+		{
+			name:          "address changed",
+			runtime:       asPtr("192.0.2.1:51820"),
+			resolver:      &fakeResolver{addrs: []netip.Addr{netip.MustParseAddr("192.0.2.2")}},
+			wantEndpoints: []string{"192.0.2.2:51820"},
+		},
+		{
+			name:     "address unchanged",
+			runtime:  asPtr("192.0.2.1:51820"),
+			resolver: &fakeResolver{addrs: []netip.Addr{netip.MustParseAddr("2001:db8::1"), netip.MustParseAddr("192.0.2.1")}},
+		},
+		{
+			name:          "no runtime endpoint",
+			resolver:      &fakeResolver{addrs: []netip.Addr{netip.MustParseAddr("2001:db8::1")}},
+			wantEndpoints: []string{"[2001:db8::1]:51820"},
+		},
+		{
+			name:     "resolution fails",
+			runtime:  asPtr("192.0.2.1:51820"),
+			resolver: &fakeResolver{err: errors.New("no such host")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			driver := &fakeDriver{endpoints: map[string]string{"a": "host.example:51820"}}
+			conf := InterfaceConfig{Interface: "wg0", HandshakeTimeoutSeconds: 180, WaitSeconds: 30}
+			tg, err := NewTunnelguard(driver, nil, conf, WithResolver(tt.resolver))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tg.resetPeer(&CycleReport{}, Peer{PublicKey: "a", Endpoint: tt.runtime}, reasonHandshakeStale, actionResetPeer)
+			if !reflect.DeepEqual(driver.resetEndpoints, tt.wantEndpoints) {
+				t.Errorf("resetPeer() endpoints = %v, want %v", driver.resetEndpoints, tt.wantEndpoints)
+			}
+		})
+	}
+}
+
+func TestTunnelguard_dryRun(t *testing.T) {
+	driver := &fakeDriver{
+		peers: []Peer{
+			{PublicKey: "dry", HandshakeLastSeen: handshakeAgo(time.Hour)},
+		},
+		endpoints: map[string]string{"dry": "host.example:51820"},
+	}
+	conf := InterfaceConfig{Interface: "wg-dry", HandshakeTimeoutSeconds: 180, WaitSeconds: 30}
+	tg, err := NewTunnelguard(driver, nil, conf, WithDryRun())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tg.conditionallyResetPeers()
+	if len(driver.resets) > 0 {
+		t.Errorf("dry-run reset peers %v", driver.resets)
+	}
+
+	driver.peersErr = errors.New("wg not found")
+	tg.conditionallyResetPeers()
+	if driver.tunnelStarts > 0 {
+		t.Errorf("dry-run started tunnel %d times", driver.tunnelStarts)
+	}
+
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+	resetKey := dryRunKey{Interface: "wg-dry", Action: actionResetPeer, Reason: reasonHandshakeStale, PublicKey: "dry"}
+	if got := metrics.DryRunActions[resetKey]; got != 1 {
+		t.Errorf("dry-run reset actions = %d, want 1", got)
+	}
+	startKey := dryRunKey{Interface: "wg-dry", Action: actionStartTunnel, Reason: reasonGetPeersFailed}
+	if got := metrics.DryRunActions[startKey]; got != 1 {
+		t.Errorf("dry-run start actions = %d, want 1", got)
+	}
+	if value := metrics.PeerResets[peerKey{Interface: "wg-dry", PublicKey: "dry"}]; value != nil {
+		t.Errorf("dry-run counted reset %d", value.Value)
+	}
+}
+
+func TestTunnelguard_peerMetrics(t *testing.T) {
+	driver := &fakeDriver{
+		peers: []Peer{
+			{PublicKey: "static", HandshakeLastSeen: handshakeAgo(time.Minute), RxBytes: 1024, TxBytes: 2048},
+			{PublicKey: "dns", HandshakeLastSeen: handshakeAgo(time.Hour)},
+			{PublicKey: "roaming", HandshakeLastSeen: handshakeAgo(time.Minute)},
+		},
+		endpoints: map[string]string{"static": "192.0.2.1:51820", "dns": "host.example:51820"},
+	}
+	conf := InterfaceConfig{
+		Interface:               "wg-peer-metrics",
+		HandshakeTimeoutSeconds: 180,
+		WaitSeconds:             30,
+		ResetBackoffMaxSeconds:  1800,
+		PublicKeyDict:           map[string]string{"static": "Static"},
+	}
+	tg, err := NewTunnelguard(driver, nil, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tg.conditionallyResetPeers()
+
+	// the endpoint types are not looked up again, the stale peer is backing off
+	lookups := driver.endpointLookups
+	tg.conditionallyResetPeers()
+	if driver.endpointLookups != lookups {
+		t.Errorf("endpoints looked up again on the next cycle, %d lookups, want %d", driver.endpointLookups, lookups)
+	}
+
+	tmpl, err := newMetricsTemplate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := metrics.Render(tmpl, &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	labels := func(publicKey, niceName string) string {
+		return `{interface="wg-peer-metrics",pub_key="` + publicKey + `",nice_name="` + niceName + `"`
+	}
+	want := []string{
+		`tunnelguard_tunnel_up{interface="wg-peer-metrics"} 1`,
+		`tunnelguard_peers{interface="wg-peer-metrics"} 3`,
+		`tunnelguard_get_peers_duration_seconds{interface="wg-peer-metrics"} `,
+		"tunnelguard_peers_receive_bytes_total" + labels("static", "Static") + "} 1024",
+		"tunnelguard_peers_transmit_bytes_total" + labels("static", "Static") + "} 2048",
+		"tunnelguard_peers_handshake_age_seconds" + labels("static", "Static") + "} 60",
+		"tunnelguard_peers_stale" + labels("static", "Static") + "} 0",
+		"tunnelguard_peers_stale" + labels("dns", "") + "} 1",
+		"tunnelguard_peers_endpoint_type" + labels("static", "Static") + `,type="static"} 1`,
+		"tunnelguard_peers_endpoint_type" + labels("dns", "") + `,type="dns"} 1`,
+		"tunnelguard_peers_endpoint_type" + labels("roaming", "") + `,type="dynamic"} 1`,
+		"tunnelguard_peers_endpoint_type" + labels("roaming", "") + `,type="static"} 0`,
+		"tunnelguard_peers_reset_duration_seconds" + labels("dns", "") + "} ",
+	}
+	for _, line := range want {
+		if !strings.Contains(buf.String(), line) {
+			t.Errorf("metrics do not contain %q", line)
+		}
+	}
+}
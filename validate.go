@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// validateConfig reads the config and all WireGuard config files it references and returns every problem found.
+// Unknown options are rejected and problems in the WireGuard config files are treated as errors.
+func validateConfig(file string, discover func() ([]string, error)) error {
+	config, err := loadConfig(file, true)
+	if err != nil {
+		return err
+	}
+
+	var errs error
+	for _, option := range []struct {
+		name  string
+		value int
+	}{
+		{"max_resets_per_minute", config.MaxResetsPerMinute},
+		{"health_max_heartbeat_age_seconds", config.HealthMaxHeartbeatAgeSeconds},
+		{"config_watch_interval_seconds", config.ConfigWatchIntervalSeconds},
+		{"shutdown_timeout_seconds", config.ShutdownTimeoutSeconds},
+	} {
+		if option.value < 0 {
+			errs = errors.Join(errs, fmt.Errorf("%s: invalid value %d", option.name, option.value))
+		}
+	}
+
+	if _, err := buildMetricsWriter(config); err != nil {
+		errs = errors.Join(errs, fmt.Errorf("metrics: %w", err))
+	}
+
+	if err := config.Logging.validate(); err != nil {
+		errs = errors.Join(errs, fmt.Errorf("logging: %w", err))
+	}
+
+	if err := config.validateStateFile(); err != nil {
+		errs = errors.Join(errs, fmt.Errorf("state_file: %w", err))
+	}
+
+	if config.ResetOnlyOnAddressChange {
+		if _, err := NewResolver(config.Resolver); err != nil {
+			errs = errors.Join(errs, fmt.Errorf("resolver: %w", err))
+		}
+	}
+
+	if config.Notifications.IsEnabled() {
+		notifiers, err := BuildNotifiers(config.Notifications)
+		if err == nil {
+			_, err = NewNotificationDispatcher(config.Notifications, notifiers...)
+		}
+		if err != nil {
+			errs = errors.Join(errs, fmt.Errorf("notifications: %w", err))
+		}
+	}
+
+	interfaces, err := config.GetInterfaces(discover)
+	if err != nil {
+		return errors.Join(errs, err)
+	}
+
+	for _, iface := range interfaces {
+		if _, err := newTunnelguardSettings(iface); err != nil {
+			errs = errors.Join(errs, fmt.Errorf("interface %s: %w", iface.Interface, err))
+		}
+
+		_, problems, err := parseWgQuickConfig(iface.ConfigFile)
+		if err != nil {
+			errs = errors.Join(errs, fmt.Errorf("interface %s: %w", iface.Interface, err))
+			continue
+		}
+		if err := validateProbeTargets(iface.Peers, iface.ConfigFile); err != nil {
+			errs = errors.Join(errs, fmt.Errorf("interface %s: %w", iface.Interface, err))
+		}
+		for _, problem := range problems {
+			errs = errors.Join(errs, fmt.Errorf("%s:%d: %s", iface.ConfigFile, problem.Line, problem.Message))
+		}
+	}
+
+	return errs
+}
@@ -0,0 +1,427 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	sectionInterface = "Interface"
+	sectionPeer      = "Peer"
+
+	wgKeyLen = 32
+)
+
+var (
+	interfaceKeys = []string{"PrivateKey", "Address", "ListenPort", "DNS", "MTU", "Table", "FwMark", "PreUp", "PostUp", "PreDown", "PostDown", "SaveConfig"}
+	peerKeys      = []string{"PublicKey", "PresharedKey", "AllowedIPs", "Endpoint", "PersistentKeepalive"}
+)
+
+// WgQuickConfig is the model of a wg-quick configuration file.
+type WgQuickConfig struct {
+	Interface WgQuickInterface
+	Peers     []WgQuickPeer
+}
+
+type WgQuickInterface struct {
+	// Line is the line number of the section header, 0 if the section is missing.
+	Line          int
+	HasPrivateKey bool
+	Addresses     []netip.Prefix
+	ListenPort    *int
+	Dns           []string
+	Mtu           *int
+	Table         string
+	FwMark        string
+	PreUp         []string
+	PostUp        []string
+	PreDown       []string
+	PostDown      []string
+	SaveConfig    bool
+	// Unknown holds all keys that are not known, keyed by their name.
+	Unknown map[string]string
+}
+
+type WgQuickPeer struct {
+	// Line is the line number of the section header.
+	Line         int
+	PublicKey    string
+	PresharedKey string
+	AllowedIPs   []netip.Prefix
+	Endpoint     *string
+	// PersistentKeepalive is the keepalive interval in seconds, nil if not set or off.
+	PersistentKeepalive *int
+	// Unknown holds all keys that are not known, keyed by their name.
+	Unknown map[string]string
+}
+
+// findPeer returns the peer identified by the given public key.
+func (c *WgQuickConfig) findPeer(publicKey string) (*WgQuickPeer, error) {
+	for idx := range c.Peers {
+		if c.Peers[idx].PublicKey == publicKey {
+			return &c.Peers[idx], nil
+		}
+	}
+	return nil, fmt.Errorf("public key %s not found", publicKey)
+}
+
+// WgConfig returns the peers and their endpoints.
+func (c *WgQuickConfig) WgConfig() *WgConfig {
+	config := &WgConfig{
+		Peers: []Peer{},
+	}
+	for _, peer := range c.Peers {
+		config.Peers = append(config.Peers, Peer{
+			PublicKey: peer.PublicKey,
+			Endpoint:  peer.Endpoint,
+		})
+	}
+	return config
+}
+
+// ConfigProblem describes an issue found while parsing a wg-quick configuration file.
+type ConfigProblem struct {
+	Line    int
+	Message string
+}
+
+func (p ConfigProblem) Error() string {
+	return fmt.Sprintf("line %d: %s", p.Line, p.Message)
+}
+
+// ConfigProblems is the list of all problems of a configuration file.
+type ConfigProblems []ConfigProblem
+
+func (p ConfigProblems) Error() string {
+	messages := make([]string, 0, len(p))
+	for _, problem := range p {
+		messages = append(messages, problem.Error())
+	}
+	return strings.Join(messages, "; ")
+}
+
+// parseWgQuickConfig parses the given wg-quick configuration file. The returned error is only set if the file can not
+// be read, issues with its content are returned as problems.
+func parseWgQuickConfig(configFile string) (*WgQuickConfig, ConfigProblems, error) {
+	file, err := os.Open(configFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open configuration file: %w", err)
+	}
+	defer file.Close()
+
+	return parseWgQuick(file)
+}
+
+func parseWgQuick(reader io.Reader) (*WgQuickConfig, ConfigProblems, error) {
+	parser := &wgQuickParser{
+		config: &WgQuickConfig{
+			Peers: []WgQuickPeer{},
+		},
+		publicKeys: map[string]int{},
+	}
+
+	scanner := bufio.NewScanner(reader)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		parser.parseLine(lineNumber, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, fmt.Errorf("error reading configuration file: %w", err)
+	}
+	parser.finishPeer()
+
+	return parser.config, parser.problems, nil
+}
+
+type wgQuickParser struct {
+	config   *WgQuickConfig
+	problems ConfigProblems
+
+	section    string
+	peer       *WgQuickPeer
+	seenKeys   map[string]int
+	publicKeys map[string]int
+}
+
+func (p *wgQuickParser) addProblem(line int, format string, args ...any) {
+	p.problems = append(p.problems, ConfigProblem{Line: line, Message: fmt.Sprintf(format, args...)})
+}
+
+func (p *wgQuickParser) parseLine(lineNumber int, raw string) {
+	line := strings.TrimSpace(stripComment(raw))
+	if line == "" {
+		return
+	}
+
+	if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+		p.startSection(lineNumber, strings.TrimSpace(strings.Trim(line, "[]")))
+		return
+	}
+
+	key, value, found := strings.Cut(line, "=")
+	if !found {
+		p.addProblem(lineNumber, "malformed line %q", line)
+		return
+	}
+	key = strings.TrimSpace(key)
+	value = strings.Trim(strings.TrimSpace(value), `"`)
+
+	switch p.section {
+	case sectionInterface:
+		p.parseInterfaceKey(lineNumber, canonicalKey(key, interfaceKeys), value)
+	case sectionPeer:
+		p.parsePeerKey(lineNumber, canonicalKey(key, peerKeys), value)
+	case "":
+		p.addProblem(lineNumber, "key %q outside of a section", key)
+	}
+}
+
+// stripComment removes a comment that starts with a # at the beginning of the line or after whitespace. A # inside a
+// value, e.g. in a PostUp command, is kept.
+func stripComment(line string) string {
+	for idx := 0; idx < len(line); idx++ {
+		if line[idx] == '#' && (idx == 0 || line[idx-1] == ' ' || line[idx-1] == '\t') {
+			return line[:idx]
+		}
+	}
+	return line
+}
+
+// canonicalKey returns the spelling of the known key that matches the key case-insensitively, as wg-quick does. Unknown
+// keys are returned unchanged.
+func canonicalKey(key string, known []string) string {
+	for _, candidate := range known {
+		if strings.EqualFold(key, candidate) {
+			return candidate
+		}
+	}
+	return key
+}
+
+func (p *wgQuickParser) startSection(lineNumber int, section string) {
+	p.finishPeer()
+	p.seenKeys = map[string]int{}
+	section = canonicalKey(section, []string{sectionInterface, sectionPeer})
+	p.section = section
+
+	switch section {
+	case sectionInterface:
+		if p.config.Interface.Line > 0 {
+			p.addProblem(lineNumber, "duplicate [Interface] section, first defined in line %d", p.config.Interface.Line)
+		} else {
+			p.config.Interface.Line = lineNumber
+		}
+	case sectionPeer:
+		p.peer = &WgQuickPeer{Line: lineNumber}
+	default:
+		p.addProblem(lineNumber, "unknown section [%s]", section)
+	}
+}
+
+func (p *wgQuickParser) finishPeer() {
+	if p.peer == nil {
+		return
+	}
+
+	if len(p.peer.PublicKey) == 0 {
+		p.addProblem(p.peer.Line, "peer without public key")
+	} else if first, found := p.publicKeys[p.peer.PublicKey]; found {
+		p.addProblem(p.peer.Line, "duplicate public key %s, first defined in line %d", p.peer.PublicKey, first)
+	} else {
+		p.publicKeys[p.peer.PublicKey] = p.peer.Line
+	}
+
+	p.config.Peers = append(p.config.Peers, *p.peer)
+	p.peer = nil
+}
+
+// checkDuplicateKey reports keys that may only be defined once per section.
+func (p *wgQuickParser) checkDuplicateKey(lineNumber int, key string) {
+	if first, found := p.seenKeys[key]; found {
+		p.addProblem(lineNumber, "duplicate key %s, first defined in line %d", key, first)
+		return
+	}
+	p.seenKeys[key] = lineNumber
+}
+
+func (p *wgQuickParser) parseInterfaceKey(lineNumber int, key, value string) {
+	iface := &p.config.Interface
+	switch key {
+	case "PrivateKey":
+		p.checkDuplicateKey(lineNumber, key)
+		if err := validateWgKey(value); err != nil {
+			p.addProblem(lineNumber, "invalid private key: %v", err)
+		}
+		iface.HasPrivateKey = true
+	case "Address":
+		iface.Addresses = append(iface.Addresses, p.parsePrefixes(lineNumber, key, value)...)
+	case "ListenPort":
+		p.checkDuplicateKey(lineNumber, key)
+		if port, err := strconv.ParseUint(value, 10, 16); err != nil {
+			p.addProblem(lineNumber, "invalid listen port %q", value)
+		} else {
+			listenPort := int(port)
+			iface.ListenPort = &listenPort
+		}
+	case "DNS":
+		iface.Dns = append(iface.Dns, splitList(value)...)
+	case "MTU":
+		p.checkDuplicateKey(lineNumber, key)
+		if mtu, err := strconv.Atoi(value); err != nil || mtu <= 0 {
+			p.addProblem(lineNumber, "invalid mtu %q", value)
+		} else {
+			iface.Mtu = &mtu
+		}
+	case "Table":
+		p.checkDuplicateKey(lineNumber, key)
+		iface.Table = value
+	case "FwMark":
+		p.checkDuplicateKey(lineNumber, key)
+		iface.FwMark = value
+	case "PreUp":
+		iface.PreUp = append(iface.PreUp, value)
+	case "PostUp":
+		iface.PostUp = append(iface.PostUp, value)
+	case "PreDown":
+		iface.PreDown = append(iface.PreDown, value)
+	case "PostDown":
+		iface.PostDown = append(iface.PostDown, value)
+	case "SaveConfig":
+		p.checkDuplicateKey(lineNumber, key)
+		iface.SaveConfig = value == "true"
+	default:
+		if iface.Unknown == nil {
+			iface.Unknown = map[string]string{}
+		}
+		iface.Unknown[key] = value
+		p.addProblem(lineNumber, "unknown key %s in [Interface]", key)
+	}
+}
+
+func (p *wgQuickParser) parsePeerKey(lineNumber int, key, value string) {
+	peer := p.peer
+	switch key {
+	case "PublicKey":
+		p.checkDuplicateKey(lineNumber, key)
+		if err := validateWgKey(value); err != nil {
+			p.addProblem(lineNumber, "invalid public key %q: %v", value, err)
+		}
+		peer.PublicKey = value
+	case "PresharedKey":
+		p.checkDuplicateKey(lineNumber, key)
+		if err := validateWgKey(value); err != nil {
+			p.addProblem(lineNumber, "invalid preshared key: %v", err)
+		}
+		peer.PresharedKey = value
+	case "AllowedIPs":
+		peer.AllowedIPs = append(peer.AllowedIPs, p.parsePrefixes(lineNumber, key, value)...)
+	case "Endpoint":
+		p.checkDuplicateKey(lineNumber, key)
+		if err := validateEndpoint(value); err != nil {
+			p.addProblem(lineNumber, "invalid endpoint %q: %v", value, err)
+		}
+		endpoint := value
+		peer.Endpoint = &endpoint
+	case "PersistentKeepalive":
+		p.checkDuplicateKey(lineNumber, key)
+		if value == "off" {
+			return
+		}
+		keepalive, err := strconv.ParseUint(value, 10, 16)
+		if err != nil {
+			p.addProblem(lineNumber, "invalid persistent keepalive %q", value)
+			return
+		}
+		if keepalive > 0 {
+			seconds := int(keepalive)
+			peer.PersistentKeepalive = &seconds
+		}
+	default:
+		if peer.Unknown == nil {
+			peer.Unknown = map[string]string{}
+		}
+		peer.Unknown[key] = value
+		p.addProblem(lineNumber, "unknown key %s in [Peer]", key)
+	}
+}
+
+// parsePrefixes parses a comma separated list of addresses with optional prefix length.
+func (p *wgQuickParser) parsePrefixes(lineNumber int, key, value string) []netip.Prefix {
+	var prefixes []netip.Prefix
+	for _, item := range splitList(value) {
+		prefix, err := parsePrefix(item)
+		if err != nil {
+			p.addProblem(lineNumber, "invalid %s entry %q", key, item)
+			continue
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes
+}
+
+// parsePrefix parses an address in CIDR notation, a bare address is treated as a single host.
+func parsePrefix(value string) (netip.Prefix, error) {
+	if strings.Contains(value, "/") {
+		return netip.ParsePrefix(value)
+	}
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); len(item) > 0 {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// validateWgKey checks whether the key is a base64 encoded 32 byte key.
+func validateWgKey(key string) error {
+	decoded, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return fmt.Errorf("not valid base64")
+	}
+	if len(decoded) != wgKeyLen {
+		return fmt.Errorf("expected %d bytes, got %d", wgKeyLen, len(decoded))
+	}
+	return nil
+}
+
+// validateEndpoint checks whether the endpoint consists of an address or hostname and a port.
+func validateEndpoint(endpoint string) error {
+	host, portStr, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		return err
+	}
+
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil || port == 0 {
+		return fmt.Errorf("invalid port %q", portStr)
+	}
+
+	if _, err := netip.ParseAddr(host); err == nil {
+		return nil
+	}
+
+	labels := strings.Split(strings.TrimSuffix(host, "."), ".")
+	for _, label := range labels {
+		if !hostnameRegex.MatchString(label) {
+			return fmt.Errorf("invalid host %q", host)
+		}
+	}
+	return nil
+}
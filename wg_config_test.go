@@ -0,0 +1,196 @@
+package main
+
+import (
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const (
+	testKeyA = "HUB2HTmOU08ceEe2fQMpzXsBEJoxK+UjV+60rTFZfk8="
+	testKeyB = "4HSO4ReY0T4W6pm9/45KaYSllbHboE+W1s+jnvEZZXw="
+)
+
+func Test_parseWgQuick(t *testing.T) {
+	input := `[Interface]
+PrivateKey = ` + testKeyA + `
+Address = 10.0.0.1/24, fd00::1/64
+ListenPort = 51820
+DNS = 10.0.0.53
+PostUp = iptables -A FORWARD -i %i -j ACCEPT # comment
+PostDown = echo down#%i	# comment after a tab
+
+[peer]
+# home router
+PublicKey = ` + testKeyB + `
+PresharedKey = ` + testKeyA + `
+AllowedIPs = 10.0.0.2/32, 192.168.1.0/24
+allowedips = fd00::2
+endpoint = home.example.com:51820
+PersistentKeepalive = 25
+`
+
+	config, problems, err := parseWgQuick(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) > 0 {
+		t.Fatalf("unexpected problems: %v", problems)
+	}
+
+	listenPort := 51820
+	keepalive := 25
+	endpoint := "home.example.com:51820"
+	want := &WgQuickConfig{
+		Interface: WgQuickInterface{
+			Line:          1,
+			HasPrivateKey: true,
+			Addresses:     []netip.Prefix{netip.MustParsePrefix("10.0.0.1/24"), netip.MustParsePrefix("fd00::1/64")},
+			ListenPort:    &listenPort,
+			Dns:           []string{"10.0.0.53"},
+			PostUp:        []string{"iptables -A FORWARD -i %i -j ACCEPT"},
+			PostDown:      []string{"echo down#%i"},
+		},
+		Peers: []WgQuickPeer{
+			{
+				Line:         9,
+				PublicKey:    testKeyB,
+				PresharedKey: testKeyA,
+				AllowedIPs: []netip.Prefix{
+					netip.MustParsePrefix("10.0.0.2/32"),
+					netip.MustParsePrefix("192.168.1.0/24"),
+					netip.MustParsePrefix("fd00::2/128"),
+				},
+				Endpoint:            &endpoint,
+				PersistentKeepalive: &keepalive,
+			},
+		},
+	}
+	if !reflect.DeepEqual(config, want) {
+		t.Errorf("parseWgQuick() got = %+v, want %+v", config, want)
+	}
+}
+
+func Test_parseWgQuick_problems(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     string
+		wantLines []int
+		wantMsg   string
+	}{
+		{
+			name:      "invalid public key",
+			input:     "[Peer]\nPublicKey = pub_a\n",
+			wantLines: []int{2},
+			wantMsg:   "invalid public key",
+		},
+		{
+			name:      "short key",
+			input:     "[Peer]\nPublicKey = aGVsbG8=\n",
+			wantLines: []int{2},
+			wantMsg:   "expected 32 bytes",
+		},
+		{
+			name:      "duplicate public key",
+			input:     "[Peer]\nPublicKey = " + testKeyA + "\n\n[Peer]\nPublicKey = " + testKeyA + "\n",
+			wantLines: []int{4},
+			wantMsg:   "duplicate public key",
+		},
+		{
+			name:      "unparseable endpoint",
+			input:     "[Peer]\nPublicKey = " + testKeyA + "\nEndpoint = host.example\n",
+			wantLines: []int{3},
+			wantMsg:   "invalid endpoint",
+		},
+		{
+			name:      "endpoint with invalid port",
+			input:     "[Peer]\nPublicKey = " + testKeyA + "\nEndpoint = host.example:99999\n",
+			wantLines: []int{3},
+			wantMsg:   "invalid endpoint",
+		},
+		{
+			name:      "invalid allowed ips",
+			input:     "[Peer]\nPublicKey = " + testKeyA + "\nAllowedIPs = 10.0.0.0/8, 10.0.0.300/32\n",
+			wantLines: []int{3},
+			wantMsg:   "invalid AllowedIPs entry",
+		},
+		{
+			name:      "unknown key",
+			input:     "[Peer]\nPublicKey = " + testKeyA + "\nFoo = bar\n",
+			wantLines: []int{3},
+			wantMsg:   "unknown key Foo",
+		},
+		{
+			name:      "malformed line",
+			input:     "[Interface]\nListenPort\n",
+			wantLines: []int{2},
+			wantMsg:   "malformed line",
+		},
+		{
+			name:      "peer without key",
+			input:     "[Interface]\nListenPort = 1\n[Peer]\nAllowedIPs = 10.0.0.1/32\n",
+			wantLines: []int{3},
+			wantMsg:   "peer without public key",
+		},
+		{
+			name:      "duplicate endpoint",
+			input:     "[Peer]\nPublicKey = " + testKeyA + "\nEndpoint = 1.1.1.1:1\nEndpoint = 1.1.1.1:2\n",
+			wantLines: []int{4},
+			wantMsg:   "duplicate key Endpoint",
+		},
+		{
+			name:      "key outside of section",
+			input:     "ListenPort = 1\n",
+			wantLines: []int{1},
+			wantMsg:   "outside of a section",
+		},
+		{
+			name:      "invalid keepalive",
+			input:     "[Peer]\nPublicKey = " + testKeyA + "\nPersistentKeepalive = often\n",
+			wantLines: []int{3},
+			wantMsg:   "invalid persistent keepalive",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, problems, err := parseWgQuick(strings.NewReader(tt.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var lines []int
+			for _, problem := range problems {
+				lines = append(lines, problem.Line)
+			}
+			if !reflect.DeepEqual(lines, tt.wantLines) {
+				t.Fatalf("problems in lines %v, want %v: %v", lines, tt.wantLines, problems)
+			}
+			if !strings.Contains(problems[0].Message, tt.wantMsg) {
+				t.Errorf("problem = %q, want it to contain %q", problems[0].Message, tt.wantMsg)
+			}
+		})
+	}
+}
+
+func Test_parseWgQuickConfig_examples(t *testing.T) {
+	config, problems, err := parseWgQuickConfig("examples/wg1.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(config.Peers) != 4 {
+		t.Fatalf("got %d peers, want 4", len(config.Peers))
+	}
+	if config.Peers[0].PresharedKey == "" || config.Peers[0].AllowedIPs[0] != netip.MustParsePrefix("10.15.200.0/24") {
+		t.Errorf("unexpected first peer %+v", config.Peers[0])
+	}
+	if keepalive := config.Peers[3].PersistentKeepalive; keepalive == nil || *keepalive != 25 {
+		t.Errorf("unexpected keepalive %v", keepalive)
+	}
+
+	// the example uses placeholders instead of real keys
+	if len(problems) == 0 {
+		t.Errorf("expected problems for placeholder keys")
+	}
+}
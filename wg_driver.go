@@ -1,25 +1,28 @@
 package main
 
 import (
-	"bufio"
 	"bytes"
 	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"os"
 	"os/exec"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
 type HandshakeData interface {
-	GetHandshakeData() ([]byte, error)
+	// GetDumpData returns the output of 'wg show <interface> dump'.
+	GetDumpData() ([]byte, error)
 }
 
 type WgCli struct {
 	interfaceName     string
 	configFile        string
+	wgConfig          wgConfigStore
 	handshakeProvider HandshakeData
 }
 
@@ -90,54 +93,111 @@ func (w *WgCli) ResetPeer(publicKey string, endpoint string) error {
 	return cmd.Run()
 }
 
-func (w *WgCli) GetEndpoint(publicKey string) (string, error) {
-	config, err := parseWireguardConfig(w.configFile)
+// ReaddPeer removes the peer and adds it again with the settings of the config file, which is read again to get
+// all settings of the peer. Both happen in a single 'wg set' call, so the peer is not lost if the command fails.
+func (w *WgCli) ReaddPeer(publicKey string, endpoint string) error {
+	config, _, err := parseWgQuickConfig(w.configFile)
 	if err != nil {
-		return "", err
+		return err
+	}
+	peer, err := config.findPeer(publicKey)
+	if err != nil {
+		return err
 	}
 
-	for _, peer := range config.Peers {
-		if peer.PublicKey == publicKey {
-			if peer.Endpoint == nil {
-				return "", nil
-			}
-			return *peer.Endpoint, nil
+	// the preshared key is passed on stdin to keep it out of the process list
+	var stdin io.Reader
+	if len(peer.PresharedKey) > 0 {
+		stdin = strings.NewReader(peer.PresharedKey)
+	}
+	args := readdPeerArgs(w.interfaceName, peer, endpoint)
+	if err := runWgCommand(stdin, args[0], args[1:]...); err != nil {
+		return fmt.Errorf("could not re-add peer: %w", err)
+	}
+	return nil
+}
+
+// readdPeerArgs returns the command that removes the peer and adds it again within the same configuration change.
+func readdPeerArgs(interfaceName string, peer *WgQuickPeer, endpoint string) []string {
+	args := []string{"wg", "set", interfaceName, "peer", peer.PublicKey, "remove", "peer", peer.PublicKey}
+	if len(endpoint) > 0 {
+		args = append(args, "endpoint", endpoint)
+	}
+	if len(peer.AllowedIPs) > 0 {
+		allowedIPs := make([]string, 0, len(peer.AllowedIPs))
+		for _, prefix := range peer.AllowedIPs {
+			allowedIPs = append(allowedIPs, prefix.String())
 		}
+		args = append(args, "allowed-ips", strings.Join(allowedIPs, ","))
+	}
+	if peer.PersistentKeepalive != nil {
+		args = append(args, "persistent-keepalive", strconv.Itoa(*peer.PersistentKeepalive))
+	}
+	if len(peer.PresharedKey) > 0 {
+		args = append(args, "preshared-key", "/dev/stdin")
 	}
+	return args
+}
 
-	return "", fmt.Errorf("public key %s not found", publicKey)
+// SyncConfig applies the config file using 'wg syncconf', which only touches peers whose settings differ.
+func (w *WgCli) SyncConfig() error {
+	stripped, err := exec.Command("wg-quick", "strip", w.configFile).Output() //#nosec:G204
+	if err != nil {
+		return fmt.Errorf("could not strip config: %w", err)
+	}
+	return runWgCommand(bytes.NewReader(stripped), "wg", "syncconf", w.interfaceName, "/dev/stdin")
 }
 
+// RestartInterface runs 'wg-quick down' followed by 'wg-quick up'. A failing 'down' is ignored as the interface
+// may already be down.
+func (w *WgCli) RestartInterface() error {
+	if err := runWgCommand(nil, "wg-quick", "down", w.interfaceName); err != nil {
+		slog.Warn("could not take interface down", "interface", w.interfaceName, "error", err)
+	}
+	return runWgCommand(nil, "wg-quick", "up", w.interfaceName)
+}
+
+// runWgCommand runs the command and includes its output in the error.
+func runWgCommand(stdin io.Reader, name string, args ...string) error {
+	cmd := exec.Command(name, args...) //#nosec:G204
+	cmd.Stdin = stdin
+
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s %s failed: %w, output: %s", name, strings.Join(args, " "), err, strings.TrimSpace(out.String()))
+	}
+	return nil
+}
+
+func (w *WgCli) GetEndpoint(publicKey string) (string, error) {
+	return w.wgConfig.getEndpoint(w.configFile, publicKey)
+}
+
+func (w *WgCli) SetWgConfig(config *WgConfig) {
+	w.wgConfig.set(config)
+}
+
+// GetPeers returns the peers of the interface as listed by a single 'wg show <interface> dump'.
 func (w *WgCli) GetPeers() ([]Peer, error) {
-	output, err := w.handshakeProvider.GetHandshakeData()
+	output, err := w.handshakeProvider.GetDumpData()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get WireGuard status: %w", err)
 	}
 
-	lines := strings.Split(string(output), "\n")
 	var peers []Peer
-
-	for _, line := range lines {
-		columns := strings.Fields(line)
-		if len(columns) < 2 {
+	for _, line := range strings.Split(string(output), "\n") {
+		// the first line describes the interface, all others a peer: public key, preshared key, endpoint, allowed ips,
+		// latest handshake, received bytes, sent bytes and persistent keepalive
+		columns := strings.Split(line, "\t")
+		if len(columns) != 8 {
 			continue
 		}
 
-		publicKey := columns[0]
-		handshakeTimestamp, err := strconv.ParseInt(columns[1], 10, 64)
+		peer, err := parseDumpPeer(columns)
 		if err != nil {
-			return nil, fmt.Errorf("failed to parse handshake time: %w", err)
-		}
-
-		var handshakeLastSeen *time.Time
-		if handshakeTimestamp != 0 {
-			hs := time.Unix(handshakeTimestamp, 0)
-			handshakeLastSeen = &hs
-		}
-
-		peer := Peer{
-			PublicKey:         publicKey,
-			HandshakeLastSeen: handshakeLastSeen,
+			return nil, err
 		}
 		peers = append(peers, peer)
 	}
@@ -145,74 +205,120 @@ func (w *WgCli) GetPeers() ([]Peer, error) {
 	return peers, nil
 }
 
+func parseDumpPeer(columns []string) (Peer, error) {
+	peer := Peer{PublicKey: columns[0]}
+	if columns[2] != "(none)" {
+		endpoint := columns[2]
+		peer.Endpoint = &endpoint
+	}
+
+	handshakeTimestamp, err := strconv.ParseInt(columns[4], 10, 64)
+	if err != nil {
+		return Peer{}, fmt.Errorf("failed to parse handshake time: %w", err)
+	}
+	if handshakeTimestamp != 0 {
+		handshake := time.Unix(handshakeTimestamp, 0)
+		peer.HandshakeLastSeen = &handshake
+	}
+
+	if peer.RxBytes, err = strconv.ParseInt(columns[5], 10, 64); err != nil {
+		return Peer{}, fmt.Errorf("failed to parse received bytes: %w", err)
+	}
+	if peer.TxBytes, err = strconv.ParseInt(columns[6], 10, 64); err != nil {
+		return Peer{}, fmt.Errorf("failed to parse sent bytes: %w", err)
+	}
+	return peer, nil
+}
+
 type WgHandshakeDataCli struct {
 	interfaceName string
 }
 
-func (w *WgHandshakeDataCli) GetHandshakeData() ([]byte, error) {
-	return exec.Command("wg", "show", w.interfaceName, "latest-handshakes").Output() //#nosec:G204
+func (w *WgHandshakeDataCli) GetDumpData() ([]byte, error) {
+	return exec.Command("wg", "show", w.interfaceName, "dump").Output() //#nosec:G204
 }
 
-func parseWireguardConfig(configFile string) (*WgConfig, error) {
-	file, err := os.Open(configFile)
+// wgConfigStore caches the peers of the WireGuard config file. The file is read again whenever its modification time
+// or size changes, a broken file keeps the previously read peers in use. Reloads replace the peers explicitly.
+type wgConfigStore struct {
+	mutex  sync.Mutex
+	config *WgConfig
+	// modTime and size identify the version of the file the peers have been read from
+	modTime time.Time
+	size    int64
+}
+
+func (s *wgConfigStore) set(config *WgConfig) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.config = config
+}
+
+// load reads the config file if it has not been read yet or changed since, the caller must hold the mutex.
+func (s *wgConfigStore) load(configFile string) error {
+	info, err := os.Stat(configFile)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open configuration file: %w", err)
+		if s.config != nil {
+			return nil
+		}
+		return err
 	}
-	defer file.Close()
-
-	config := &WgConfig{
-		Peers: []Peer{},
+	if s.config != nil && info.ModTime().Equal(s.modTime) && info.Size() == s.size {
+		return nil
 	}
 
-	var currentSection string
-	var currentPeer Peer
-
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-
-		// Skip empty lines and comments
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
+	config, err := parseWireguardConfig(configFile)
+	if err != nil {
+		if s.config == nil {
+			return err
 		}
+		slog.Warn("could not read changed wireguard config, using previously read peers", "file", configFile, "error", err)
+	} else {
+		s.config = config
+	}
+	s.modTime, s.size = info.ModTime(), info.Size()
+	return nil
+}
 
-		// Handle section headers
-		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
-			if currentSection == "Peer" {
-				config.Peers = append(config.Peers, currentPeer)
-				currentPeer = Peer{} // Reset the peer for the next one
-			}
-			currentSection = strings.Trim(line, "[]")
-			continue
-		}
+// getEndpoint returns the endpoint of the peer identified by the given public key as defined in the WireGuard config
+// file. An empty string is returned if the peer has no endpoint configured.
+func (s *wgConfigStore) getEndpoint(configFile string, publicKey string) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 
-		// Handle key-value pairs
-		parts := strings.SplitN(line, "=", 2)
-		if len(parts) != 2 {
-			continue // Skip malformed lines
-		}
-		key := strings.TrimSpace(parts[0])
-		value := strings.Trim(strings.TrimSpace(parts[1]), `"`)
-
-		switch currentSection {
-		case "Peer":
-			switch key {
-			case "PublicKey":
-				currentPeer.PublicKey = value
-			case "Endpoint":
-				currentPeer.Endpoint = &value
+	if err := s.load(configFile); err != nil {
+		return "", err
+	}
+
+	for _, peer := range s.config.Peers {
+		if peer.PublicKey == publicKey {
+			if peer.Endpoint == nil {
+				return "", nil
 			}
+			return *peer.Endpoint, nil
 		}
 	}
 
-	// Add the last peer if any
-	if currentSection == "Peer" {
-		config.Peers = append(config.Peers, currentPeer)
+	return "", fmt.Errorf("public key %s not found", publicKey)
+}
+
+// discoverWireguardInterfaces returns the names of all currently configured WireGuard interfaces.
+func discoverWireguardInterfaces() ([]string, error) {
+	output, err := exec.Command("wg", "show", "interfaces").Output()
+	if err != nil {
+		return nil, fmt.Errorf("could not list interfaces: %w", err)
 	}
 
-	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("error reading configuration file: %w", err)
+	return strings.Fields(string(output)), nil
+}
+
+// parseWireguardConfig returns the peers defined in the config file. Problems of the file's content are ignored, use
+// parseWgQuickConfig to get them.
+func parseWireguardConfig(configFile string) (*WgConfig, error) {
+	wgQuickConfig, _, err := parseWgQuickConfig(configFile)
+	if err != nil {
+		return nil, err
 	}
 
-	return config, nil
+	return wgQuickConfig.WgConfig(), nil
 }
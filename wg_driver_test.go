@@ -1,6 +1,9 @@
 package main
 
 import (
+	"net/netip"
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 	"time"
@@ -14,10 +17,11 @@ var (
 type wgTest struct {
 }
 
-func (w *wgTest) GetHandshakeData() ([]byte, error) {
-	data := `bbb	1725551118
-ccc		1725551097
-ddd		0
+func (w *wgTest) GetDumpData() ([]byte, error) {
+	data := `cHJpdmF0ZQ==	cHVibGlj	51820	off
+bbb	(none)	10.0.0.1:51820	10.0.0.2/32	1725551118	1024	2048	25
+ccc	cHNr	[2001:db8::1]:51820	10.0.0.3/32,fd00::3/128	1725551097	0	148	off
+ddd	(none)	(none)	(none)	0	0	0	off
 `
 	return []byte(data), nil
 }
@@ -43,10 +47,15 @@ func TestWg_GetPeers(t *testing.T) {
 				{
 					PublicKey:         "bbb",
 					HandshakeLastSeen: &t1,
+					Endpoint:          asPtr("10.0.0.1:51820"),
+					RxBytes:           1024,
+					TxBytes:           2048,
 				},
 				{
 					PublicKey:         "ccc",
 					HandshakeLastSeen: &t2,
+					Endpoint:          asPtr("[2001:db8::1]:51820"),
+					TxBytes:           148,
 				},
 				{
 					PublicKey:         "ddd",
@@ -101,6 +110,10 @@ func (p *Peer) Equals(other *Peer) bool {
 		return false
 	}
 
+	if p.RxBytes != other.RxBytes || p.TxBytes != other.TxBytes {
+		return false
+	}
+
 	return true
 }
 
@@ -218,6 +231,43 @@ func TestWg_GetEndpoint(t *testing.T) {
 	}
 }
 
+func TestWgConfigStore_changedFile(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "wg0.conf")
+	writeConfig := func(content string, modTime time.Time) {
+		if err := os.WriteFile(configFile, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(configFile, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store := &wgConfigStore{}
+	assertEndpoint := func(want string) {
+		t.Helper()
+		got, err := store.getEndpoint(configFile, "pub_a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("getEndpoint() = %q, want %q", got, want)
+		}
+	}
+
+	now := time.Now()
+	writeConfig("[Peer]\nPublicKey = pub_a\nEndpoint = 192.0.2.1:51820\n", now.Add(-time.Hour))
+	assertEndpoint("192.0.2.1:51820")
+
+	// edits are picked up without a reload
+	writeConfig("[Peer]\nPublicKey = pub_a\nEndpoint = 192.0.2.2:51820\n", now)
+	assertEndpoint("192.0.2.2:51820")
+
+	// a file that vanished keeps the previously read peers
+	if err := os.Remove(configFile); err != nil {
+		t.Fatal(err)
+	}
+	assertEndpoint("192.0.2.2:51820")
+}
+
 func Test_parseConfig(t *testing.T) {
 	type args struct {
 		filename string
@@ -288,3 +338,38 @@ func Test_parseConfig(t *testing.T) {
 		})
 	}
 }
+
+func Test_readdPeerArgs(t *testing.T) {
+	keepalive := 25
+	tests := []struct {
+		name     string
+		peer     WgQuickPeer
+		endpoint string
+		want     []string
+	}{
+		{
+			name: "all settings",
+			peer: WgQuickPeer{
+				PublicKey:           testKeyA,
+				PresharedKey:        testKeyB,
+				AllowedIPs:          []netip.Prefix{netip.MustParsePrefix("10.0.0.2/32"), netip.MustParsePrefix("fd00::2/128")},
+				PersistentKeepalive: &keepalive,
+			},
+			endpoint: "host.example:51820",
+			want: []string{"wg", "set", "wg0", "peer", testKeyA, "remove", "peer", testKeyA, "endpoint", "host.example:51820",
+				"allowed-ips", "10.0.0.2/32,fd00::2/128", "persistent-keepalive", "25", "preshared-key", "/dev/stdin"},
+		},
+		{
+			name: "no endpoint",
+			peer: WgQuickPeer{PublicKey: testKeyA},
+			want: []string{"wg", "set", "wg0", "peer", testKeyA, "remove", "peer", testKeyA},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := readdPeerArgs("wg0", &tt.peer, tt.endpoint); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("readdPeerArgs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
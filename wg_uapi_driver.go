@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultUapiSocketDir = "/var/run/wireguard"
+	defaultUapiTimeout   = 5 * time.Second
+)
+
+// WgUapi talks to the control socket of userspace WireGuard implementations (wireguard-go, boringtun) using the
+// cross-platform UAPI protocol, see https://www.wireguard.com/xplatform/
+type WgUapi struct {
+	interfaceName string
+	configFile    string
+	wgConfig      wgConfigStore
+	socketPath    string
+	timeout       time.Duration
+}
+
+func NewWgUapi(interfaceName string, configFile string, socketPath string) (*WgUapi, error) {
+	if len(interfaceName) == 0 {
+		return nil, errors.New("empty interface name provided")
+	}
+
+	if len(configFile) == 0 {
+		return nil, errors.New("empty config file provided")
+	}
+
+	if len(socketPath) == 0 {
+		socketPath = filepath.Join(defaultUapiSocketDir, interfaceName+".sock")
+	}
+
+	return &WgUapi{
+		interfaceName: interfaceName,
+		configFile:    configFile,
+		socketPath:    socketPath,
+		timeout:       defaultUapiTimeout,
+	}, nil
+}
+
+func (w *WgUapi) StartTunnel() error {
+	return errors.New("starting the tunnel is not supported by the uapi driver")
+}
+
+func (w *WgUapi) IsTunnelUp() (bool, error) {
+	if _, err := os.Stat(w.socketPath); os.IsNotExist(err) {
+		return false, nil
+	}
+
+	if _, err := w.get(); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (w *WgUapi) ResetPeer(publicKey string, endpoint string) error {
+	hexKey, err := base64KeyToHex(publicKey)
+	if err != nil {
+		return err
+	}
+
+	// the uapi protocol only accepts resolved addresses
+	addr, err := net.ResolveUDPAddr("udp", endpoint)
+	if err != nil {
+		return fmt.Errorf("could not resolve endpoint %q: %w", endpoint, err)
+	}
+
+	request := fmt.Sprintf("set=1\npublic_key=%s\nupdate_only=true\nendpoint=%s\n\n", hexKey, addr.String())
+	_, err = w.roundTrip(request)
+	return err
+}
+
+// ReaddPeer removes the peer and adds it again with the settings of the config file in a single request.
+func (w *WgUapi) ReaddPeer(publicKey string, endpoint string) error {
+	config, _, err := parseWgQuickConfig(w.configFile)
+	if err != nil {
+		return err
+	}
+	peer, err := config.findPeer(publicKey)
+	if err != nil {
+		return err
+	}
+
+	hexKey, err := base64KeyToHex(publicKey)
+	if err != nil {
+		return err
+	}
+
+	var request strings.Builder
+	fmt.Fprintf(&request, "set=1\npublic_key=%s\nremove=true\npublic_key=%s\n", hexKey, hexKey)
+	if len(endpoint) > 0 {
+		addr, err := net.ResolveUDPAddr("udp", endpoint)
+		if err != nil {
+			return fmt.Errorf("could not resolve endpoint %q: %w", endpoint, err)
+		}
+		fmt.Fprintf(&request, "endpoint=%s\n", addr.String())
+	}
+	if len(peer.PresharedKey) > 0 {
+		hexPresharedKey, err := base64KeyToHex(peer.PresharedKey)
+		if err != nil {
+			return fmt.Errorf("invalid preshared key: %w", err)
+		}
+		fmt.Fprintf(&request, "preshared_key=%s\n", hexPresharedKey)
+	}
+	if peer.PersistentKeepalive != nil {
+		fmt.Fprintf(&request, "persistent_keepalive_interval=%d\n", *peer.PersistentKeepalive)
+	}
+	request.WriteString("replace_allowed_ips=true\n")
+	for _, prefix := range peer.AllowedIPs {
+		fmt.Fprintf(&request, "allowed_ip=%s\n", prefix.String())
+	}
+	request.WriteString("\n")
+
+	_, err = w.roundTrip(request.String())
+	return err
+}
+
+func (w *WgUapi) SyncConfig() error {
+	return fmt.Errorf("syncing the config is not supported by the uapi driver: %w", errors.ErrUnsupported)
+}
+
+func (w *WgUapi) RestartInterface() error {
+	return fmt.Errorf("restarting the interface is not supported by the uapi driver: %w", errors.ErrUnsupported)
+}
+
+func (w *WgUapi) GetEndpoint(publicKey string) (string, error) {
+	return w.wgConfig.getEndpoint(w.configFile, publicKey)
+}
+
+func (w *WgUapi) SetWgConfig(config *WgConfig) {
+	w.wgConfig.set(config)
+}
+
+func (w *WgUapi) GetPeers() ([]Peer, error) {
+	lines, err := w.get()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get WireGuard status: %w", err)
+	}
+
+	return parseUapiPeers(lines)
+}
+
+func (w *WgUapi) get() ([]string, error) {
+	return w.roundTrip("get=1\n\n")
+}
+
+// roundTrip sends a request to the control socket and returns the response lines, excluding the errno line.
+func (w *WgUapi) roundTrip(request string) ([]string, error) {
+	conn, err := net.DialTimeout("unix", w.socketPath, w.timeout)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to %s: %w", w.socketPath, err)
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(w.timeout)); err != nil {
+		return nil, err
+	}
+
+	if _, err := io.WriteString(conn, request); err != nil {
+		return nil, fmt.Errorf("could not send request: %w", err)
+	}
+
+	var lines []string
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			break
+		}
+
+		if errno, found := strings.CutPrefix(line, "errno="); found {
+			if errno != "0" {
+				return nil, fmt.Errorf("uapi request failed with errno %s", errno)
+			}
+			return lines, nil
+		}
+		lines = append(lines, line)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read response: %w", err)
+	}
+
+	return nil, errors.New("incomplete uapi response, missing errno")
+}
+
+func parseUapiPeers(lines []string) ([]Peer, error) {
+	var peers []Peer
+	var current *Peer
+	var handshakeSec, handshakeNsec int64
+
+	finishPeer := func() {
+		if current == nil {
+			return
+		}
+		if handshakeSec != 0 || handshakeNsec != 0 {
+			hs := time.Unix(handshakeSec, handshakeNsec)
+			current.HandshakeLastSeen = &hs
+		}
+		peers = append(peers, *current)
+	}
+
+	for _, line := range lines {
+		key, value, found := strings.Cut(line, "=")
+		if !found {
+			return nil, fmt.Errorf("malformed line %q", line)
+		}
+
+		if key == "public_key" {
+			finishPeer()
+			publicKey, err := hexKeyToBase64(value)
+			if err != nil {
+				return nil, err
+			}
+			current = &Peer{PublicKey: publicKey}
+			handshakeSec, handshakeNsec = 0, 0
+			continue
+		}
+
+		// skip interface level keys
+		if current == nil {
+			continue
+		}
+
+		var err error
+		switch key {
+		case "endpoint":
+			endpoint := value
+			current.Endpoint = &endpoint
+		case "last_handshake_time_sec":
+			handshakeSec, err = strconv.ParseInt(value, 10, 64)
+		case "last_handshake_time_nsec":
+			handshakeNsec, err = strconv.ParseInt(value, 10, 64)
+		case "rx_bytes":
+			current.RxBytes, err = strconv.ParseInt(value, 10, 64)
+		case "tx_bytes":
+			current.TxBytes, err = strconv.ParseInt(value, 10, 64)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not parse %s: %w", key, err)
+		}
+	}
+	finishPeer()
+
+	return peers, nil
+}
+
+func base64KeyToHex(key string) (string, error) {
+	decoded, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return "", fmt.Errorf("invalid public key %q: %w", key, err)
+	}
+	return hex.EncodeToString(decoded), nil
+}
+
+func hexKeyToBase64(key string) (string, error) {
+	decoded, err := hex.DecodeString(key)
+	if err != nil {
+		return "", fmt.Errorf("invalid public key %q: %w", key, err)
+	}
+	return base64.StdEncoding.EncodeToString(decoded), nil
+}
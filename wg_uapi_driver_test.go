@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	uapiKeyA = "HUB2HTmOU08ceEe2fQMpzXsBEJoxK+UjV+60rTFZfk8="
+	uapiKeyB = "4HSO4ReY0T4W6pm9/45KaYSllbHboE+W1s+jnvEZZXw="
+)
+
+// uapiStandIn emulates the control socket of a userspace WireGuard implementation.
+type uapiStandIn struct {
+	listener net.Listener
+	dump     string
+	errno    string
+
+	mutex    sync.Mutex
+	requests []string
+}
+
+func newUapiStandIn(t *testing.T, dump string) (*uapiStandIn, string) {
+	t.Helper()
+
+	socket := filepath.Join(t.TempDir(), "wg0.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+
+	server := &uapiStandIn{
+		listener: listener,
+		dump:     dump,
+		errno:    "0",
+	}
+	go server.serve()
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+
+	return server, socket
+}
+
+func (s *uapiStandIn) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *uapiStandIn) handle(conn net.Conn) {
+	defer conn.Close()
+
+	var request []string
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		if scanner.Text() == "" {
+			break
+		}
+		request = append(request, scanner.Text())
+	}
+
+	s.mutex.Lock()
+	s.requests = append(s.requests, strings.Join(request, "\n"))
+	s.mutex.Unlock()
+
+	if len(request) > 0 && request[0] == "get=1" {
+		_, _ = conn.Write([]byte(s.dump))
+	}
+	_, _ = conn.Write([]byte("errno=" + s.errno + "\n\n"))
+}
+
+func (s *uapiStandIn) lastRequest() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if len(s.requests) == 0 {
+		return ""
+	}
+	return s.requests[len(s.requests)-1]
+}
+
+func mustHex(t *testing.T, key string) string {
+	t.Helper()
+	hexKey, err := base64KeyToHex(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hexKey
+}
+
+func TestWgUapi_GetPeers(t *testing.T) {
+	dump := strings.Join([]string{
+		"private_key=e84b5a6d2717c1003a13b431570353dbaca9146cf150c5f8575680feba52027a",
+		"listen_port=51820",
+		"public_key=" + mustHex(t, uapiKeyA),
+		"endpoint=10.0.0.1:51820",
+		"last_handshake_time_sec=1725551118",
+		"last_handshake_time_nsec=0",
+		"rx_bytes=1024",
+		"tx_bytes=2048",
+		"allowed_ip=10.15.0.2/32",
+		"public_key=" + mustHex(t, uapiKeyB),
+		"last_handshake_time_sec=0",
+		"last_handshake_time_nsec=0",
+		"rx_bytes=0",
+		"tx_bytes=0",
+	}, "\n") + "\n"
+
+	_, socket := newUapiStandIn(t, dump)
+	w, err := NewWgUapi("wg0", "examples/wg0.conf", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := w.GetPeers()
+	if err != nil {
+		t.Fatalf("GetPeers() error = %v", err)
+	}
+
+	want := []Peer{
+		{
+			PublicKey:         uapiKeyA,
+			HandshakeLastSeen: &t1,
+			Endpoint:          asPtr("10.0.0.1:51820"),
+			RxBytes:           1024,
+			TxBytes:           2048,
+		},
+		{
+			PublicKey: uapiKeyB,
+		},
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("GetPeers() got length = %d, want length %d", len(got), len(want))
+	}
+	for i := range got {
+		if !got[i].Equals(&want[i]) || got[i].RxBytes != want[i].RxBytes || got[i].TxBytes != want[i].TxBytes {
+			t.Errorf("GetPeers() got = %v, want %v", got[i], want[i])
+		}
+	}
+}
+
+func TestWgUapi_ResetPeer(t *testing.T) {
+	server, socket := newUapiStandIn(t, "")
+	w, err := NewWgUapi("wg0", "examples/wg0.conf", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.ResetPeer(uapiKeyA, "127.0.0.1:51820"); err != nil {
+		t.Fatalf("ResetPeer() error = %v", err)
+	}
+
+	want := strings.Join([]string{
+		"set=1",
+		"public_key=" + mustHex(t, uapiKeyA),
+		"update_only=true",
+		"endpoint=127.0.0.1:51820",
+	}, "\n")
+	if got := server.lastRequest(); got != want {
+		t.Errorf("ResetPeer() sent %q, want %q", got, want)
+	}
+}
+
+func TestWgUapi_ReaddPeer(t *testing.T) {
+	server, socket := newUapiStandIn(t, "")
+	configFile := filepath.Join(t.TempDir(), "wg0.conf")
+	config := "[Peer]\nPublicKey = " + uapiKeyA + "\nPresharedKey = " + uapiKeyB + "\nAllowedIPs = 10.0.0.2/32, fd00::2/128\nPersistentKeepalive = 25\n"
+	if err := os.WriteFile(configFile, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	w, err := NewWgUapi("wg0", configFile, socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.ReaddPeer(uapiKeyA, "127.0.0.1:51820"); err != nil {
+		t.Fatalf("ReaddPeer() error = %v", err)
+	}
+
+	want := strings.Join([]string{
+		"set=1",
+		"public_key=" + mustHex(t, uapiKeyA),
+		"remove=true",
+		"public_key=" + mustHex(t, uapiKeyA),
+		"endpoint=127.0.0.1:51820",
+		"preshared_key=" + mustHex(t, uapiKeyB),
+		"persistent_keepalive_interval=25",
+		"replace_allowed_ips=true",
+		"allowed_ip=10.0.0.2/32",
+		"allowed_ip=fd00::2/128",
+	}, "\n")
+	if got := server.lastRequest(); got != want {
+		t.Errorf("ReaddPeer() sent %q, want %q", got, want)
+	}
+
+	if err := w.ReaddPeer(uapiKeyB, "127.0.0.1:51820"); err == nil {
+		t.Error("ReaddPeer() expected error for unknown peer")
+	}
+	if err := w.RestartInterface(); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("RestartInterface() error = %v, want unsupported", err)
+	}
+}
+
+func TestWgUapi_Errno(t *testing.T) {
+	server, socket := newUapiStandIn(t, "")
+	server.errno = "1"
+	w, err := NewWgUapi("wg0", "examples/wg0.conf", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.ResetPeer(uapiKeyA, "127.0.0.1:51820"); err == nil {
+		t.Error("ResetPeer() expected error")
+	}
+	if _, err := w.GetPeers(); err == nil {
+		t.Error("GetPeers() expected error")
+	}
+}
+
+func TestWgUapi_IsTunnelUp(t *testing.T) {
+	_, socket := newUapiStandIn(t, "")
+	w, err := NewWgUapi("wg0", "examples/wg0.conf", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.timeout = time.Second
+
+	up, err := w.IsTunnelUp()
+	if err != nil || !up {
+		t.Errorf("IsTunnelUp() = %v, %v, want true", up, err)
+	}
+
+	w.socketPath = filepath.Join(t.TempDir(), "missing.sock")
+	up, err = w.IsTunnelUp()
+	if err != nil || up {
+		t.Errorf("IsTunnelUp() = %v, %v, want false", up, err)
+	}
+}